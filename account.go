@@ -0,0 +1,614 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Privacy request kinds in the privacy_requests table
+const (
+	privacyExport = "export"
+	privacyDelete = "delete"
+)
+
+// AccountProfile is the user_auth row, without the password hash.
+type AccountProfile struct {
+	ID            int64      `json:"id"`
+	Email         string     `json:"email"`
+	Role          string     `json:"role"`
+	FirstName     string     `json:"first_name"`
+	LastName      string     `json:"last_name"`
+	Phone         string     `json:"phone"`
+	IsActive      bool       `json:"is_active"`
+	EmailVerified bool       `json:"email_verified"`
+	CreatedAt     *time.Time `json:"created_at"`
+	LastLoginAt   *time.Time `json:"last_login_at"`
+}
+
+// CustomerRecord is a customers row - the contact details given with an estimate.
+type CustomerRecord struct {
+	CustomerID int64 `json:"customer_id"`
+	CustomerJSON
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SignatureJSON is an e-signature record, as exported.
+type SignatureJSON struct {
+	EstimateID   int       `json:"estimate_id"`
+	SignerName   string    `json:"signer_name"`
+	IPAddress    string    `json:"ip_address"`
+	UserAgent    string    `json:"user_agent"`
+	AcceptedAt   time.Time `json:"accepted_at"`
+	DocumentHash string    `json:"document_sha256"`
+	DocumentText string    `json:"document_text"`
+}
+
+// ChangeOrderSignatureJSON is the signature on an accepted change order, as exported.
+type ChangeOrderSignatureJSON struct {
+	EstimateID int       `json:"estimate_id"`
+	Seq        int       `json:"change_order"`
+	Reason     string    `json:"reason"`
+	TotalCost  float64   `json:"total_cost"`
+	SignerName string    `json:"signer_name"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	AcceptedAt time.Time `json:"accepted_at"`
+}
+
+// PrivacyRequest is one entry in the audit trail of export and deletion requests.
+type PrivacyRequest struct {
+	RequestID   int64      `json:"request_id"`
+	UserID      int64      `json:"user_id"`
+	Kind        string     `json:"kind"` // export or delete
+	IPAddress   string     `json:"ip_address"`
+	UserAgent   string     `json:"user_agent"`
+	RequestedAt time.Time  `json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at"` // nil if it failed
+}
+
+// AccountData is everything held about a user.
+type AccountData struct {
+	Profile    AccountProfile
+	Estimates  []DeckEstimate
+	Draft      *EstimateDraft // nil if none
+	Customers  []CustomerRecord
+	Contacts   []ContactSubmission
+	Signatures []Acceptance
+	Changes    []ChangeOrder // Accepted change orders
+	Requests   []PrivacyRequest
+}
+
+// AccountPageData holds data for the account page.
+type AccountPageData struct {
+	Data      AccountData
+	CanDelete bool // Homeowners can delete their own data
+	Deleted   bool // Shown once, after the data is deleted and the user signed out
+	Message   string
+	Error     string
+}
+
+// ownedEstimates selects the estimates saved by user $1 or given with their customer details.
+const ownedEstimates = `(SELECT estimate_id FROM estimates WHERE user_id = $1
+	OR customer_id IN (SELECT customer_id FROM customers WHERE user_id = $1))`
+
+// LoadAccountData gathers everything held about a user.
+func (s *SQLStore) LoadAccountData(ctx context.Context, userID int64) (AccountData, error) {
+	var data AccountData
+	var err error
+	if data.Profile, err = s.loadProfile(ctx, userID); err != nil {
+		return AccountData{}, err
+	}
+
+	estimateIDs, err := s.ownedEstimateIDs(ctx, userID)
+	if err != nil {
+		return AccountData{}, err
+	}
+	for _, id := range estimateIDs {
+		e, _, err := s.LoadEstimate(ctx, id)
+		if err != nil {
+			return AccountData{}, err
+		}
+		data.Estimates = append(data.Estimates, e)
+		if !e.AcceptDate.IsZero() {
+			a, err := s.LoadAcceptance(ctx, id)
+			if err == nil {
+				data.Signatures = append(data.Signatures, a)
+			} else if err != sql.ErrNoRows {
+				return AccountData{}, err
+			}
+			orders, err := s.LoadChangeOrders(ctx, id)
+			if err != nil {
+				return AccountData{}, err
+			}
+			for _, co := range orders {
+				if co.Status == changeAccepted {
+					data.Changes = append(data.Changes, co)
+				}
+			}
+		}
+	}
+
+	if d, err := s.LoadDraft(ctx, userID); err == nil {
+		data.Draft = &d
+	} else if err != sql.ErrNoRows {
+		return AccountData{}, err
+	}
+
+	if data.Customers, err = s.loadCustomerRecords(ctx, userID); err != nil {
+		return AccountData{}, err
+	}
+	if data.Contacts, err = s.loadContactSubmissions(ctx, userID, data.Profile.Email); err != nil {
+		return AccountData{}, err
+	}
+	if data.Requests, err = s.LoadPrivacyRequests(ctx, userID); err != nil {
+		return AccountData{}, err
+	}
+	return data, nil
+}
+
+func (s *SQLStore) loadProfile(ctx context.Context, userID int64) (AccountProfile, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	var p AccountProfile
+	var createdAt, lastLoginAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, `SELECT id, email, role, COALESCE(first_name, ''), COALESCE(last_name, ''),
+		COALESCE(phone, ''), COALESCE(is_active, FALSE), COALESCE(email_verified, FALSE), created_at, last_login_at
+		FROM user_auth WHERE id = $1`, userID).Scan(&p.ID, &p.Email, &p.Role, &p.FirstName, &p.LastName,
+		&p.Phone, &p.IsActive, &p.EmailVerified, &createdAt, &lastLoginAt)
+	if err != nil {
+		return AccountProfile{}, err
+	}
+	if createdAt.Valid {
+		p.CreatedAt = &createdAt.Time
+	}
+	if lastLoginAt.Valid {
+		p.LastLoginAt = &lastLoginAt.Time
+	}
+	return p, nil
+}
+
+func (s *SQLStore) ownedEstimateIDs(ctx context.Context, userID int64) ([]int, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT estimate_id FROM estimates WHERE estimate_id IN `+ownedEstimates+`
+		ORDER BY estimate_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLStore) loadCustomerRecords(ctx context.Context, userID int64) ([]CustomerRecord, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT customer_id, first_name, last_name, address, city, state, zip,
+		phone_number, email, created_at FROM customers
+		WHERE user_id = $1 OR customer_id IN (SELECT customer_id FROM estimates WHERE user_id = $1)
+		ORDER BY customer_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []CustomerRecord
+	for rows.Next() {
+		var c CustomerRecord
+		if err := rows.Scan(&c.CustomerID, &c.FirstName, &c.LastName, &c.Address, &c.City, &c.State, &c.Zip,
+			&c.PhoneNumber, &c.Email, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := piiKeys.decryptAll(&c.FirstName, &c.LastName, &c.Address, &c.PhoneNumber, &c.Email); err != nil {
+			return nil, err
+		}
+		records = append(records, c)
+	}
+	return records, rows.Err()
+}
+
+// loadContactSubmissions finds contact form submissions sent while logged in or from the account email.
+func (s *SQLStore) loadContactSubmissions(ctx context.Context, userID int64, email string) ([]ContactSubmission, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT submission_id, COALESCE(user_id, 0), name, email, phone, project,
+		message, ip_address, submitted_at FROM contact_submissions
+		WHERE user_id = $1 OR email_index = $2 ORDER BY submission_id`, userID, piiKeys.emailIndex(email))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []ContactSubmission
+	for rows.Next() {
+		var c ContactSubmission
+		if err := rows.Scan(&c.SubmissionID, &c.UserID, &c.Name, &c.Email, &c.Phone, &c.Project,
+			&c.Message, &c.IPAddress, &c.SubmittedAt); err != nil {
+			return nil, err
+		}
+		if err := piiKeys.decryptAll(&c.Name, &c.Email, &c.Phone, &c.Message); err != nil {
+			return nil, err
+		}
+		subs = append(subs, c)
+	}
+	return subs, rows.Err()
+}
+
+// AnonymizeAccount erases a user's personal data.  Estimates keep their inputs and
+// totals; names, contact details, descriptions and messages are cleared, and the
+// account is signed out everywhere and can no longer log in.  Signed acceptances
+// and accepted change orders are kept as they were signed, encrypted - they are the
+// contract and its amendments, which we must be able to produce if it is disputed.
+// The export and the privacy page say so (exportReadme).  It returns the
+// attachments removed so the caller can delete the files.
+func (s *SQLStore) AnonymizeAccount(ctx context.Context, userID int64) ([]Attachment, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var email string
+	if err := tx.QueryRowContext(ctx, `SELECT email FROM user_auth WHERE id = $1`, userID).Scan(&email); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+attachmentColumns+` FROM estimate_attachments
+		WHERE estimate_id IN `+ownedEstimates, userID)
+	if err != nil {
+		return nil, err
+	}
+	var removed []Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		removed = append(removed, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stmts := []string{
+		`DELETE FROM estimate_attachments WHERE estimate_id IN ` + ownedEstimates,
+		`DELETE FROM estimate_reminders WHERE estimate_id IN ` + ownedEstimates,
+		`UPDATE estimates SET description = '' WHERE estimate_id IN ` + ownedEstimates,
+		`DELETE FROM estimate_drafts WHERE user_id = $1`,
+		`DELETE FROM password_resets WHERE user_id = $1`,
+		// State is kept for sales tax
+		`UPDATE customers SET first_name = '', last_name = '', address = '', city = '', zip = '',
+			phone_number = '', email = '', email_index = ''
+			WHERE user_id = $1 OR customer_id IN (SELECT customer_id FROM estimates WHERE estimate_id IN ` + ownedEstimates + `)`,
+		`DELETE FROM contact_submissions WHERE user_id = $1`,
+		`UPDATE user_auth SET email = 'deleted-' || id || '@invalid', password_hash = '', first_name = NULL,
+			last_name = NULL, phone = NULL, is_active = FALSE, email_verified = FALSE, google_sub = NULL WHERE id = $1`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt, userID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM contact_submissions WHERE email_index = $1`,
+		piiKeys.emailIndex(email)); err != nil {
+		return nil, err
+	}
+	// Sign out every session the account has open (see signOutRevokedSession)
+	if _, err := tx.ExecContext(ctx, `UPDATE user_auth SET sessions_valid_from = $1 WHERE id = $2`,
+		time.Now(), userID); err != nil {
+		return nil, err
+	}
+	return removed, tx.Commit()
+}
+
+// InsertPrivacyRequest records a request in the audit trail and sets req.RequestID.
+func (s *SQLStore) InsertPrivacyRequest(ctx context.Context, req *PrivacyRequest) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	return s.db.QueryRowContext(ctx, `INSERT INTO privacy_requests (user_id, kind, ip_address, user_agent, requested_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING request_id`,
+		req.UserID, req.Kind, req.IPAddress, req.UserAgent, req.RequestedAt).Scan(&req.RequestID)
+}
+
+// CompletePrivacyRequest marks a request as carried out.
+func (s *SQLStore) CompletePrivacyRequest(ctx context.Context, requestID int64, completedAt time.Time) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE privacy_requests SET completed_at = $1 WHERE request_id = $2`,
+		completedAt, requestID)
+	return err
+}
+
+// LoadPrivacyRequests lists a user's requests, newest first.
+func (s *SQLStore) LoadPrivacyRequests(ctx context.Context, userID int64) ([]PrivacyRequest, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT request_id, user_id, kind, ip_address, user_agent, requested_at, completed_at
+		FROM privacy_requests WHERE user_id = $1 ORDER BY request_id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reqs []PrivacyRequest
+	for rows.Next() {
+		var req PrivacyRequest
+		var completedAt sql.NullTime
+		if err := rows.Scan(&req.RequestID, &req.UserID, &req.Kind, &req.IPAddress, &req.UserAgent,
+			&req.RequestedAt, &completedAt); err != nil {
+			return nil, err
+		}
+		if completedAt.Valid {
+			req.CompletedAt = &completedAt.Time
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, rows.Err()
+}
+
+// startPrivacyRequest writes the audit record before a request is carried out.
+func startPrivacyRequest(r *http.Request, userID int64, kind string) (PrivacyRequest, error) {
+	req := PrivacyRequest{
+		UserID:      userID,
+		Kind:        kind,
+		IPAddress:   clientIP(r),
+		UserAgent:   r.UserAgent(),
+		RequestedAt: time.Now(),
+	}
+	err := accountStore.InsertPrivacyRequest(r.Context(), &req)
+	return req, err
+}
+
+// finishPrivacyRequest marks the audit record complete.
+func finishPrivacyRequest(ctx context.Context, req PrivacyRequest) {
+	if err := accountStore.CompletePrivacyRequest(ctx, req.RequestID, time.Now()); err != nil {
+		log.Printf("Privacy request %d done but not marked complete: %v", req.RequestID, err)
+	}
+	log.Printf("Privacy request %d: %s for user %d", req.RequestID, req.Kind, req.UserID)
+}
+
+// exportReadme is README.txt in the account export.
+const exportReadme = `Your data held by Columbia Outdoor, as JSON files.
+
+If you delete your data from My Account, everything here is removed except:
+
+  - signatures.json and change_orders.json: the signed copy of each accepted
+    estimate and the signatures on its change orders, with the name, IP address
+    and browser they were signed from.  They are the record of the contract and
+    are kept, encrypted, for as long as we may need to show what was agreed.
+  - The prices in estimates.json, without your name, contact details or
+    descriptions, for our accounts.
+  - privacy_requests.json, the record of your export and deletion requests.
+
+Contact form messages are deleted a year after they are sent.
+`
+
+// writeAccountZip writes the account data as a ZIP of JSON files.
+func writeAccountZip(w http.ResponseWriter, data AccountData) error {
+	estimates := make([]EstimateDocument, 0, len(data.Estimates))
+	for _, e := range data.Estimates {
+		e.fillBreakdown(costs)
+		estimates = append(estimates, exportEstimate(e))
+	}
+	signatures := make([]SignatureJSON, 0, len(data.Signatures))
+	for _, a := range data.Signatures {
+		signatures = append(signatures, SignatureJSON{
+			EstimateID:   a.EstimateID,
+			SignerName:   a.SignerName,
+			IPAddress:    a.IPAddress,
+			UserAgent:    a.UserAgent,
+			AcceptedAt:   a.AcceptedAt,
+			DocumentHash: a.DocumentHash,
+			DocumentText: a.DocumentText,
+		})
+	}
+	changes := make([]ChangeOrderSignatureJSON, 0, len(data.Changes))
+	for _, co := range data.Changes {
+		changes = append(changes, ChangeOrderSignatureJSON{
+			EstimateID: co.EstimateID,
+			Seq:        co.Seq,
+			Reason:     co.Reason,
+			TotalCost:  co.TotalCost,
+			SignerName: co.SignerName,
+			IPAddress:  co.IPAddress,
+			UserAgent:  co.UserAgent,
+			AcceptedAt: co.AcceptDate,
+		})
+	}
+	var draft *EstimateDocument
+	if data.Draft != nil {
+		e := data.Draft.estimate()
+		e.Customer = data.Draft.Customer
+		doc := exportEstimate(e)
+		draft = &doc
+	}
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", data.Profile},
+		{"estimates.json", estimates},
+		{"draft.json", draft},
+		{"customers.json", data.Customers},
+		{"contact_submissions.json", data.Contacts},
+		{"signatures.json", signatures},
+		{"change_orders.json", changes},
+		{"privacy_requests.json", data.Requests},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="columbia-outdoor-data-%d.zip"`, data.Profile.ID))
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.v); err != nil {
+			return err
+		}
+	}
+	fw, err := zw.Create("README.txt")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(fw, exportReadme); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// **********************************************************************************
+// accountExportHandler - GET /account/export
+//
+//	Downloads everything held about the logged in user as a ZIP of JSON files.
+//
+// **********************************************************************************
+func accountExportHandler(w http.ResponseWriter, r *http.Request) {
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	userID := sd.UserAuth.ID
+
+	req, err := startPrivacyRequest(r, userID, privacyExport)
+	if err != nil {
+		log.Printf("Failed to record export request for user %d: %v", userID, err)
+		http.Error(w, "Database error: Export failed.", http.StatusInternalServerError)
+		return
+	}
+	data, err := accountStore.LoadAccountData(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to load account data for user %d: %v", userID, err)
+		http.Error(w, "Database error: Export failed.", http.StatusInternalServerError)
+		return
+	}
+	if err := writeAccountZip(w, data); err != nil {
+		log.Printf("Account export for user %d failed: %v", userID, err)
+		return
+	}
+	finishPrivacyRequest(r.Context(), req)
+}
+
+// **********************************************************************************
+// accountHandler - /account
+//
+//	GET shows what we hold about the logged in user, with a download link.
+//	POST op=delete anonymizes a homeowner's data and signs them out.
+//
+// **********************************************************************************
+func accountHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("account.html").Funcs(funcMap).ParseFiles("templates/account.html",
+		"templates/header.html", "templates/footer.html"))
+
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	userAuth := getUserAuth(r, w)
+	userAuth.Title = "My Account"
+	data := AccountPageData{CanDelete: sd.UserAuth.Role == roleHomeowner}
+	rd := renderData{
+		Page:   &data,
+		Header: &userAuth,
+	}
+	render := func() {
+		if err := tmpl.ExecuteTemplate(w, "account.html", rd); err != nil {
+			log.Printf("accountHandler execute error: %v", err)
+			panic(err)
+		}
+	}
+
+	userID := sd.UserAuth.ID
+
+	if r.Method == http.MethodPost {
+		switch r.FormValue("op") {
+		case "delete":
+			if !data.CanDelete {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if r.FormValue("confirm") != "yes" {
+				data.Error = "Please tick the box to confirm you want your data deleted."
+				break
+			}
+			req, err := startPrivacyRequest(r, userID, privacyDelete)
+			if err != nil {
+				log.Printf("Failed to record delete request for user %d: %v", userID, err)
+				data.Error = "Database error: Your data was not deleted.  Please try again."
+				break
+			}
+			removed, err := accountStore.AnonymizeAccount(r.Context(), userID)
+			if err != nil {
+				log.Printf("Failed to delete data for user %d: %v", userID, err)
+				data.Error = "Database error: Your data was not deleted.  Please try again."
+				break
+			}
+			for _, a := range removed {
+				deleteAttachmentFiles(a)
+			}
+			forgetSessionsValidFrom(userID)
+			finishPrivacyRequest(r.Context(), req)
+
+			sd.Delete(r, w)
+			rd.Header = &UserAuth{Title: "Account Deleted"}
+			data = AccountPageData{Deleted: true}
+			render()
+			return
+
+		default:
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch r.URL.Query().Get("verify") {
+	case "sent":
+		data.Message = "We sent a new verification link to your email."
+	case "done":
+		data.Message = "Your email is verified."
+	case "failed":
+		data.Error = "That verification link has expired or is not valid.  Send yourself a new one below."
+	case "error":
+		data.Error = "We could not send the verification email.  Please try again later."
+	}
+
+	if data.Data, err = accountStore.LoadAccountData(r.Context(), userID); err != nil {
+		log.Printf("Failed to load account data for user %d: %v", userID, err)
+		data.Error = "Database error: Account details not available."
+	}
+	render()
+}
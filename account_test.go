@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAnonymizeAccountKeepsContract(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	now := time.Now()
+
+	userID, err := st.CreateUser(ctx, &NewUser{Email: "ann@example.com", Role: roleHomeowner, IsActive: true, EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := DeckEstimate{Customer: Customer{FirstName: "Ann", LastName: "Smith", Email: "ann@example.com"},
+		SaveDate: now, ExpirationDate: now.Add(24 * time.Hour), ProductType: productDeck}
+	if err := st.InsertEstimate(ctx, &e, userID, statusSaved); err != nil {
+		t.Fatal(err)
+	}
+	signed := Acceptance{EstimateID: e.EstimateID, UserID: userID, SignerName: "Ann Smith", Consent: true,
+		IPAddress: "192.0.2.1", UserAgent: "test", AcceptedAt: now, DocumentText: "Customer: Ann Smith",
+		DocumentHash: hashDocument("Customer: Ann Smith")}
+	if err := st.InsertAcceptance(ctx, &signed, nil); err != nil {
+		t.Fatal(err)
+	}
+	co := ChangeOrder{EstimateID: e.EstimateID, Reason: "Wider stairs", Status: changePending, CreatedAt: now}
+	if err := st.InsertChangeOrder(ctx, &co); err != nil {
+		t.Fatal(err)
+	}
+	co.AcceptDate, co.SignerName, co.IPAddress, co.UserAgent = now, "Ann Smith", "192.0.2.1", "test"
+	if err := st.AcceptChangeOrder(ctx, &co); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.InsertContactSubmission(ctx, &ContactSubmission{UserID: userID, Name: "Ann", Email: "ann@example.com",
+		Message: "Hello", SubmittedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := st.LoadAccountData(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before.Signatures) != 1 || len(before.Changes) != 1 || before.Changes[0].SignerName != "Ann Smith" {
+		t.Errorf("export has %d signatures and change orders %+v, want both signatures", len(before.Signatures), before.Changes)
+	}
+	rec := httptest.NewRecorder()
+	if err := writeAccountZip(rec, before); err != nil {
+		t.Fatal(err)
+	}
+	body := rec.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]bool{}
+	for _, f := range zr.File {
+		files[f.Name] = true
+	}
+	for _, name := range []string{"signatures.json", "change_orders.json", "README.txt"} {
+		if !files[name] {
+			t.Errorf("export has no %s", name)
+		}
+	}
+
+	if _, err := st.AnonymizeAccount(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	a, err := st.LoadAcceptance(ctx, e.EstimateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.SignerName != "Ann Smith" || a.DocumentText != signed.DocumentText {
+		t.Errorf("acceptance = %q / %q, want it kept as signed", a.SignerName, a.DocumentText)
+	}
+	orders, err := st.LoadChangeOrders(ctx, e.EstimateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].SignerName != "Ann Smith" || orders[0].IPAddress != "192.0.2.1" {
+		t.Errorf("change orders = %+v, want the signature kept", orders)
+	}
+	var contacts int
+	if err := st.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM contact_submissions`).Scan(&contacts); err != nil {
+		t.Fatal(err)
+	}
+	if contacts != 0 {
+		t.Errorf("%d contact messages left, want 0", contacts)
+	}
+}
+
+func TestPurgeContactSubmissions(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	now := time.Now()
+
+	for _, age := range []time.Duration{0, contactRetention - time.Hour, contactRetention + time.Hour} {
+		if err := st.InsertContactSubmission(ctx, &ContactSubmission{Name: "Bob", Email: "bob@example.com",
+			Message: "Quote please", SubmittedAt: now.Add(-age)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := st.PurgeContactSubmissions(ctx, now.Add(-contactRetention))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("purged %d messages, want 1", n)
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png" // Register PNG for image.Decode
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// maxAttachmentSize caps one uploaded photo or document.
+const maxAttachmentSize = 10 << 20 // 10 MB
+
+// maxImagePixels guards against images that are small on disk but huge decoded.
+const maxImagePixels = 50_000_000
+
+// thumbSize is the longest side of a thumbnail, in pixels.
+const thumbSize = 240
+
+// Attachment visibility.  Customer attachments are seen by anyone who can see the estimate,
+// staff attachments (internal site notes, supplier quotes) only by admin and contractor users.
+const (
+	visibilityCustomer = "customer"
+	visibilityStaff    = "staff"
+)
+
+// allowedAttachmentTypes maps the sniffed content type to the stored file extension.
+var allowedAttachmentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+}
+
+// Attachment is a photo or document uploaded to a saved estimate.
+type Attachment struct {
+	AttachmentID int64
+	EstimateID   int
+	UserID       int64 // Uploader - 0 for a homeowner who is not logged in
+	Filename     string
+	ContentType  string
+	Size         int64
+	StorageKey   string
+	ThumbKey     string // Empty for documents
+	Visibility   string
+	UploadedAt   time.Time
+}
+
+// IsImage reports whether the attachment is shown as a photo in the gallery.
+func (a Attachment) IsImage() bool {
+	return a.ThumbKey != ""
+}
+
+var attachmentFiles Storage // attachmentFiles holds the uploaded files - see ATTACHMENT_DIR
+
+func init() {
+	dir := os.Getenv("ATTACHMENT_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+	store, err := newLocalStorage(dir)
+	if err != nil {
+		log.Printf("Attachments disabled: %v", err)
+		return
+	}
+	attachmentFiles = store
+}
+
+// isStaff reports whether the role can see staff attachments.
+func isStaff(role string) bool {
+	return role == roleAdmin || role == roleContractor
+}
+
+// visibleTo reports whether a user with the given role can see the attachment.
+func (a Attachment) visibleTo(role string) bool {
+	return a.Visibility == visibilityCustomer || isStaff(role)
+}
+
+// newStorageKey returns a random key so stored file names never come from the upload.
+func newStorageKey(estimateID int, ext string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("estimates/%d/%s%s", estimateID, hex.EncodeToString(b), ext), nil
+}
+
+// stripJPEGMetadata removes the APP1 segments (EXIF, including GPS location, and XMP) from a JPEG.
+// The image data is copied as is, so there is no re-encoding loss.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, fmt.Errorf("not a JPEG")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2]) // SOI
+
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, fmt.Errorf("corrupt JPEG at byte %d", i)
+		}
+		marker := data[i+1]
+		if marker == 0xDA { // Start of scan - the rest is image data
+			break
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + size
+		if size < 2 || end > len(data) {
+			return nil, fmt.Errorf("corrupt JPEG segment at byte %d", i)
+		}
+		if marker != 0xE1 { // APP1
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	out.Write(data[i:])
+	return out.Bytes(), nil
+}
+
+// stripPNGMetadata removes eXIf and text chunks, which can carry location and camera details.
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const sigLen = 8
+	if len(data) < sigLen {
+		return nil, fmt.Errorf("not a PNG")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:sigLen])
+
+	for i := sigLen; i < len(data); {
+		if i+8 > len(data) {
+			return nil, fmt.Errorf("corrupt PNG at byte %d", i)
+		}
+		size := int(binary.BigEndian.Uint32(data[i : i+4]))
+		end := i + 12 + size // length + type + data + CRC
+		if size < 0 || end > len(data) {
+			return nil, fmt.Errorf("corrupt PNG chunk at byte %d", i)
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return out.Bytes(), nil
+}
+
+// makeThumbnail scales an image down to thumbSize on its longest side and encodes it as JPEG.
+func makeThumbnail(data []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("image is %dx%d pixels - too large", cfg.Width, cfg.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > thumbSize || h > thumbSize {
+		if w >= h {
+			tw, th = thumbSize, max(1, h*thumbSize/w)
+		} else {
+			tw, th = max(1, w*thumbSize/h), thumbSize
+		}
+	}
+
+	// Average a 4x4 grid of samples from the source area behind each thumbnail pixel
+	const samples = 4
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			var r, g, bl, a uint32
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					px := b.Min.X + (x*samples+sx)*w/(tw*samples)
+					py := b.Min.Y + (y*samples+sy)*h/(th*samples)
+					cr, cg, cb, ca := src.At(px, py).RGBA()
+					r, g, bl, a = r+cr, g+cg, bl+cb, a+ca
+				}
+			}
+			n := uint32(samples * samples)
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// prepareUpload checks the type of an upload, strips image metadata and builds the thumbnail.
+func prepareUpload(data []byte) (contentType string, clean []byte, thumb []byte, err error) {
+	contentType = http.DetectContentType(data)
+	if _, ok := allowedAttachmentTypes[contentType]; !ok {
+		return "", nil, nil, fmt.Errorf("file type %s is not allowed - upload a JPEG, PNG or PDF", contentType)
+	}
+
+	switch contentType {
+	case "image/jpeg":
+		clean, err = stripJPEGMetadata(data)
+	case "image/png":
+		clean, err = stripPNGMetadata(data)
+	default:
+		return contentType, data, nil, nil
+	}
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if thumb, err = makeThumbnail(clean); err != nil {
+		return "", nil, nil, fmt.Errorf("unreadable image: %v", err)
+	}
+	return contentType, clean, thumb, nil
+}
+
+// InsertAttachment records an uploaded attachment and sets its ID.
+func (s *SQLStore) InsertAttachment(ctx context.Context, a *Attachment) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	return s.db.QueryRowContext(ctx, `INSERT INTO estimate_attachments (
+		estimate_id, user_id, filename, content_type, size_bytes, storage_key, thumb_key, visibility, uploaded_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING attachment_id`,
+		a.EstimateID, nullID(a.UserID), a.Filename, a.ContentType, a.Size, a.StorageKey, a.ThumbKey,
+		a.Visibility, a.UploadedAt).Scan(&a.AttachmentID)
+}
+
+const attachmentColumns = `attachment_id, estimate_id, COALESCE(user_id, 0), filename, content_type,
+	size_bytes, storage_key, COALESCE(thumb_key, ''), visibility, uploaded_at`
+
+func scanAttachment(row interface{ Scan(...any) error }) (Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.AttachmentID, &a.EstimateID, &a.UserID, &a.Filename, &a.ContentType,
+		&a.Size, &a.StorageKey, &a.ThumbKey, &a.Visibility, &a.UploadedAt)
+	return a, err
+}
+
+// LoadAttachment loads one attachment by ID.
+func (s *SQLStore) LoadAttachment(ctx context.Context, attachmentID int64) (Attachment, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	return scanAttachment(s.db.QueryRowContext(ctx, `SELECT `+attachmentColumns+`
+		FROM estimate_attachments WHERE attachment_id = $1`, attachmentID))
+}
+
+// LoadAttachments loads the attachments on an estimate that the role can see, oldest first.
+func (s *SQLStore) LoadAttachments(ctx context.Context, estimateID int, role string) ([]Attachment, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+attachmentColumns+`
+		FROM estimate_attachments WHERE estimate_id = $1 ORDER BY uploaded_at, attachment_id`, estimateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		if a.visibleTo(role) {
+			attachments = append(attachments, a)
+		}
+	}
+	return attachments, rows.Err()
+}
+
+// DeleteAttachment removes an attachment record.
+func (s *SQLStore) DeleteAttachment(ctx context.Context, attachmentID int64) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM estimate_attachments WHERE attachment_id = $1`, attachmentID)
+	return err
+}
+
+// deleteAttachment removes the record and the stored files.
+func deleteAttachment(ctx context.Context, a Attachment) error {
+	if err := attachmentStore.DeleteAttachment(ctx, a.AttachmentID); err != nil {
+		return err
+	}
+	deleteAttachmentFiles(a)
+	return nil
+}
+
+// deleteAttachmentFiles removes the stored file and thumbnail of a deleted attachment.
+func deleteAttachmentFiles(a Attachment) {
+	if attachmentFiles == nil {
+		return
+	}
+	for _, key := range []string{a.StorageKey, a.ThumbKey} {
+		if key == "" {
+			continue
+		}
+		if err := attachmentFiles.Delete(key); err != nil {
+			log.Printf("Attachment %d deleted but file %s was not: %v", a.AttachmentID, key, err)
+		}
+	}
+}
+
+// canAccessEstimate reports whether the session may see a saved estimate and its attachments:
+// an admin, the contractor assigned the job, the logged in owner, or a visitor who is not
+// logged in and has it in their session.  A logged in user's session estimate counts for
+// nothing - it may be one another account opened on the same browser.
+func canAccessEstimate(ctx context.Context, sd *SessionData, estimateID int, ownerID int64) bool {
+	if sd.UserAuth.IsAuthenticated && sd.UserAuth.Role == roleAdmin {
+		return true
+	}
+	if sd.UserAuth.IsAuthenticated && sd.UserAuth.Role == roleContractor {
+		assigned, err := jobStore.IsAssignedContractor(ctx, estimateID, sd.UserAuth.ID)
+		if err != nil {
+			log.Printf("Assignment check for estimate %d failed: %v", estimateID, err)
+		}
+		return assigned
+	}
+	if sd.UserAuth.IsAuthenticated && ownerID > 0 && ownerID == sd.UserAuth.ID {
+		return true
+	}
+	return !sd.UserAuth.IsAuthenticated && sd.Estimate.EstimateID == estimateID
+}
+
+// loadEstimateAttachments adds the attachments the user can see to a saved estimate.
+func loadEstimateAttachments(ctx context.Context, estimate *DeckEstimate, role string) {
+	var err error
+	if estimate.Attachments, err = attachmentStore.LoadAttachments(ctx, estimate.EstimateID, role); err != nil {
+		log.Printf("Failed to load attachments for estimate %d: %v", estimate.EstimateID, err)
+	}
+}
+
+// **********************************************************************************
+// uploadAttachmentHandler - POST /estimate/attachments?id=1000
+//
+//	Form: file, visibility (staff only - homeowner uploads are always visible
+//	to the customer).  The estimate id is in the query, where requireEstimate
+//	checks it - it does not read multipart bodies.  Returns to the estimate page.
+//
+// **********************************************************************************
+func uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if attachmentFiles == nil {
+		http.Error(w, "Attachments are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	estimateID, ok := checkedEstimateID(r) // Checked in requireEstimate
+	if !ok {
+		http.Error(w, "Missing estimate", http.StatusBadRequest)
+		return
+	}
+
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+(1<<20)) // Room for the other form fields
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Upload too large, or please choose a file to upload", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize+1))
+	if err != nil {
+		http.Error(w, "Upload failed", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxAttachmentSize {
+		http.Error(w, fmt.Sprintf("File is larger than %d MB", maxAttachmentSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	contentType, clean, thumb, err := prepareUpload(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	a := Attachment{
+		EstimateID:  estimateID,
+		Filename:    header.Filename,
+		ContentType: contentType,
+		Size:        int64(len(clean)),
+		Visibility:  visibilityCustomer,
+		UploadedAt:  time.Now(),
+	}
+	if sd.UserAuth.IsAuthenticated {
+		a.UserID = sd.UserAuth.ID
+		if isStaff(sd.UserAuth.Role) && r.FormValue("visibility") == visibilityStaff {
+			a.Visibility = visibilityStaff
+		}
+	}
+
+	if a.StorageKey, err = newStorageKey(estimateID, allowedAttachmentTypes[contentType]); err == nil {
+		err = attachmentFiles.Put(a.StorageKey, bytes.NewReader(clean))
+	}
+	if err == nil && thumb != nil {
+		if a.ThumbKey, err = newStorageKey(estimateID, "_thumb.jpg"); err == nil {
+			err = attachmentFiles.Put(a.ThumbKey, bytes.NewReader(thumb))
+		}
+	}
+	if err == nil {
+		err = attachmentStore.InsertAttachment(r.Context(), &a)
+	}
+	if err != nil {
+		log.Printf("Upload to estimate %d failed: %v", estimateID, err)
+		attachmentFiles.Delete(a.StorageKey)
+		if a.ThumbKey != "" {
+			attachmentFiles.Delete(a.ThumbKey)
+		}
+		http.Error(w, "Upload failed", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Attachment %d uploaded to estimate %d: %s, %d bytes, %s", a.AttachmentID, estimateID,
+		contentType, a.Size, a.Visibility)
+	http.Redirect(w, r, "/estimate", http.StatusSeeOther)
+}
+
+// **********************************************************************************
+// attachmentHandler - GET /attachment?id=12[&thumb=1]
+//
+//	Serves an attachment, or its thumbnail, to users who can see it
+//	(checked in requireAttachment).
+//
+// **********************************************************************************
+func attachmentHandler(w http.ResponseWriter, r *http.Request) {
+	a, ok := checkedAttachment(r)
+	if !ok {
+		notFoundHandler(w, r)
+		return
+	}
+
+	key, contentType := a.StorageKey, a.ContentType
+	if r.URL.Query().Get("thumb") == "1" && a.ThumbKey != "" {
+		key, contentType = a.ThumbKey, "image/jpeg"
+	}
+	f, err := attachmentFiles.Get(key)
+	if err != nil {
+		log.Printf("Attachment %d file %s missing: %v", a.AttachmentID, key, err)
+		notFoundHandler(w, r)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", a.Filename))
+	io.Copy(w, f)
+}
+
+// **********************************************************************************
+// deleteAttachmentHandler - POST /attachment/delete
+//
+//	Form: id.  The uploader or an admin can delete an attachment they can see
+//	(checked in requireAttachment).
+//
+// **********************************************************************************
+func deleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a, ok := checkedAttachment(r)
+	if !ok {
+		notFoundHandler(w, r)
+		return
+	}
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	isAdmin := sd.UserAuth.Role == roleAdmin
+	isUploader := a.UserID > 0 && a.UserID == sd.UserAuth.ID
+	if !isAdmin && !isUploader {
+		forbiddenHandler(w, r)
+		return
+	}
+
+	if err := deleteAttachment(r.Context(), a); err != nil {
+		log.Printf("Delete attachment %d failed: %v", a.AttachmentID, err)
+		http.Error(w, "Database error: Delete failed.", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Attachment %d on estimate %d deleted by user %d", a.AttachmentID, a.EstimateID, sd.UserAuth.ID)
+	http.Redirect(w, r, "/estimate", http.StatusSeeOther)
+}
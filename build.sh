@@ -40,6 +40,13 @@ case "$1" in
             exit 1
         fi
 
+        if [ -n "$PII_KEYS" ] && [ -n "$PII_INDEX_KEY" ]; then
+            echo "PII encryption keys are set"
+        else
+            echo "ERROR:  PII_KEYS or PII_INDEX_KEY is missing or empty"
+            exit 1
+        fi
+
         if [ -n "$GOOGLE_OAUTH_SECRET" ]; then
             echo "Google Oauth Client Secret is set"
         else 
@@ -51,7 +58,19 @@ case "$1" in
         docker tag "$IMAGE_NAME:latest" "$GCR_IMAGE"
         echo "Pushing to GCR..."
         docker push "$GCR_IMAGE" || { echo "Error: **** Docker Push failed for $GCR_IMAGE" >&2; exit 1; }
+        ## The new revision will not start with a migration pending, so apply them first with a
+        ## Cloud Run job running the same image.  Migrations must work with the old revision still serving.
+        echo "Applying database migrations..."
+        gcloud run jobs deploy "$IMAGE_NAME-migrate" \
+            --image "$GCR_IMAGE" \
+            --region us-central1 \
+            --project $PROJECT_ID \
+            --args migrate,up \
+            --set-env-vars DATABASE_URL=${DATABASE_URL_PROD} \
+            --execute-now \
+            --wait || { echo "Error: **** Migrations failed - not deploying" >&2; exit 1; }
         echo "Deploying to Cloud Run..."
+        ## PII_KEYS is a comma list - the ^@^ prefix stops gcloud splitting it on the commas
         gcloud run deploy "$IMAGE_NAME" \
             --image "$GCR_IMAGE" \
             --platform managed \
@@ -63,7 +82,10 @@ case "$1" in
             --set-env-vars CLOUDFLARE_SECRET_KEY=${CLOUDFLARE_SECRET_KEY} \
             --set-env-vars GOOGLE_OAUTH_SECRET=${GOOGLE_OAUTH_SECRET} \
             --set-env-vars DATABASE_URL=${DATABASE_URL_PROD} \
-            --set-env-vars SESSION_SECRET=${SESSION_SECRET}
+            --set-env-vars SESSION_SECRET=${SESSION_SECRET} \
+            --set-env-vars SESSION_BACKEND=database \
+            --set-env-vars "^@^PII_KEYS=${PII_KEYS}" \
+            --set-env-vars PII_INDEX_KEY=${PII_INDEX_KEY}
         echo "Deployed to Cloud Run!"
         ;;
     *)
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Change order line actions
+const (
+	changeAdd    = "add"
+	changeRemove = "remove"
+	changeModify = "modify"
+)
+
+// Change order status
+const (
+	changePending  = "pending"
+	changeAccepted = "accepted"
+)
+
+// changeOrderRows is the number of blank line item rows on the create form.
+const changeOrderRows = 5
+
+// changeCategories are the line items of an estimate that a change order can touch.
+var changeCategories = []string{"Demo", "Deck", "Rail", "Fascia", "Stairs", "Stair Rails", "Stair Fascia", "Stair Toe Kicks", "Other"}
+
+// ChangeOrderItem adds, removes or modifies one line item of the contract.
+type ChangeOrderItem struct {
+	Line        int
+	Action      string // add, remove, or modify
+	Category    string // Deck, Rail, Stairs ...
+	Description string
+	OldAmount   float64 // The contract's amount for the category, 0 for add
+	NewAmount   float64 // 0 for remove
+}
+
+// Delta is the change to the contract subtotal.
+func (i ChangeOrderItem) Delta() float64 {
+	return i.NewAmount - i.OldAmount
+}
+
+// ChangeOrder is a change to an accepted estimate.  The homeowner must accept it separately.
+type ChangeOrder struct {
+	ChangeOrderID int64
+	EstimateID    int
+	Seq           int // 1, 2, 3 ... per estimate
+	Reason        string
+	Items         []ChangeOrderItem
+	PrevSubtotal  float64 // Contract subtotal before this change
+	Subtotal      float64 // Contract subtotal after this change
+	SalesTax      float64 // Recomputed on the new subtotal
+	TotalCost     float64 // New contract total
+	Status        string  // pending or accepted
+	CreatedBy     int64
+	CreatedAt     time.Time
+	AcceptDate    time.Time
+	SignerName    string
+	IPAddress     string
+	UserAgent     string
+}
+
+// ChangeOrderPageData holds data for the change order page.
+type ChangeOrderPageData struct {
+	EstimateID    int
+	OrigSubtotal  float64 // Subtotal as accepted
+	OrigTotal     float64 // Total as accepted
+	ContractTotal float64 // Total after accepted change orders
+	ChangeOrders  []ChangeOrder
+	Pending       *ChangeOrder // Waiting for homeowner acceptance
+	IsAdmin       bool
+	CanAccept     bool               // The owner, and not who proposed the pending change order
+	Lines         map[string]float64 // Contract amount of each category, for admins
+	Categories    []string
+	Rows          []int
+	Message       string
+	Error         string
+}
+
+// contractTotals returns the subtotal and total after all accepted change orders.
+func contractTotals(origSubtotal, origTotal float64, orders []ChangeOrder) (float64, float64) {
+	subtotal, total := origSubtotal, origTotal
+	for _, co := range orders {
+		if co.Status == changeAccepted {
+			subtotal, total = co.Subtotal, co.TotalCost
+		}
+	}
+	return subtotal, total
+}
+
+// ContractTotal is the estimate total after accepted change orders.
+func (e DeckEstimate) ContractTotal() float64 {
+	_, total := contractTotals(e.Subtotal, e.TotalCost, e.ChangeOrders)
+	return total
+}
+
+// contractLines returns the amount of each change category on the contract: the
+// estimate's line items with the accepted change orders applied.
+func contractLines(e DeckEstimate, orders []ChangeOrder) map[string]float64 {
+	lines := map[string]float64{
+		"Demo":            e.DemoCost,
+		"Deck":            e.DeckCost,
+		"Rail":            e.RailCost,
+		"Fascia":          e.FasciaCost,
+		"Stairs":          e.StairCost,
+		"Stair Rails":     e.StairRailCost,
+		"Stair Fascia":    e.StairFasciaCost,
+		"Stair Toe Kicks": e.StairToeKickCost,
+	}
+	for _, co := range orders {
+		if co.Status == changeAccepted {
+			for _, item := range co.Items {
+				lines[item.Category] += item.Delta()
+			}
+		}
+	}
+	return lines
+}
+
+// priceChangeOrderItems sets the old amount of each remove and modify line to
+// what the contract has for its category, so only real amounts can be changed.
+// A modify line needs a new amount - taking a line to nothing is a remove.
+// Lines are applied in order - a second line for a category sees the first.
+func priceChangeOrderItems(items []ChangeOrderItem, lines map[string]float64) error {
+	current := make(map[string]float64, len(lines))
+	for category, amount := range lines {
+		current[category] = amount
+	}
+	for i := range items {
+		item := &items[i]
+		if item.Action != changeAdd {
+			item.OldAmount = current[item.Category]
+			if item.OldAmount <= 0 {
+				return fmt.Errorf("line %d: the contract has no %s to %s", item.Line, item.Category, item.Action)
+			}
+		}
+		if item.Action == changeModify && item.NewAmount <= 0 {
+			return fmt.Errorf("line %d: enter the new amount for %s, or remove it", item.Line, item.Category)
+		}
+		current[item.Category] += item.Delta()
+	}
+	return nil
+}
+
+// newChangeOrder prices the items against the current contract subtotal.  A change
+// that would take the subtotal below zero is an error.
+func newChangeOrder(estimateID int, reason string, items []ChangeOrderItem, prevSubtotal float64) (ChangeOrder, error) {
+	co := ChangeOrder{
+		EstimateID:   estimateID,
+		Reason:       reason,
+		Items:        items,
+		PrevSubtotal: prevSubtotal,
+		Subtotal:     prevSubtotal,
+		Status:       changePending,
+		CreatedAt:    time.Now(),
+	}
+	for _, item := range items {
+		co.Subtotal += item.Delta()
+	}
+	co.Subtotal = math.Round(co.Subtotal*100) / 100
+	if co.Subtotal < 0 {
+		return ChangeOrder{}, fmt.Errorf("the new subtotal would be %s", formatCost(co.Subtotal))
+	}
+	co.SalesTax = CalculateSalesTax(co.Subtotal)
+	co.TotalCost = co.Subtotal + co.SalesTax
+	return co, nil
+}
+
+// parseChangeOrderItems reads the line item rows from the create form.  Blank rows
+// are skipped.  Old amounts come from the contract - see priceChangeOrderItems.
+func parseChangeOrderItems(r *http.Request) ([]ChangeOrderItem, error) {
+	actions := r.Form["change"]
+	categories := r.Form["category"]
+	descriptions := r.Form["description"]
+	newAmounts := r.Form["newAmount"]
+
+	var items []ChangeOrderItem
+	for i := range actions {
+		if i >= len(categories) || i >= len(descriptions) || i >= len(newAmounts) {
+			break
+		}
+		desc := strings.TrimSpace(descriptions[i])
+		if actions[i] == "" && desc == "" {
+			continue
+		}
+
+		item := ChangeOrderItem{
+			Line:        len(items) + 1,
+			Action:      actions[i],
+			Category:    categories[i],
+			Description: desc,
+		}
+		if desc == "" {
+			return nil, fmt.Errorf("line %d: description is required", item.Line)
+		}
+		if !slices.Contains(changeCategories, item.Category) {
+			return nil, fmt.Errorf("line %d: select an item", item.Line)
+		}
+
+		amount := strings.TrimSpace(newAmounts[i])
+		if amount != "" {
+			v, err := strconv.ParseFloat(amount, 64)
+			if err != nil || v < 0 || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("line %d: amounts must be non-negative numbers", item.Line)
+			}
+			item.NewAmount = v
+		}
+
+		switch item.Action {
+		case changeAdd:
+		case changeRemove:
+			item.NewAmount = 0
+		case changeModify:
+			if amount == "" {
+				return nil, fmt.Errorf("line %d: enter the new amount", item.Line)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: select add, remove or modify", item.Line)
+		}
+		items = append(items, item)
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("add at least one line item")
+	}
+	return items, nil
+}
+
+// LoadAcceptedTotals reads the subtotal and total of an accepted estimate.
+func (s *SQLStore) LoadAcceptedTotals(ctx context.Context, estimateID int) (float64, float64, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	var subtotal, total float64
+	var acceptDate sql.NullTime
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(subtotal, 0), COALESCE(total_cost, 0), accept_date
+		FROM estimates WHERE estimate_id = $1`, estimateID).Scan(&subtotal, &total, &acceptDate)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !acceptDate.Valid {
+		return 0, 0, fmt.Errorf("estimate %d is not accepted", estimateID)
+	}
+	if subtotal == 0 && total > 0 {
+		// Saved before subtotal was stored - back it out of the total
+		subtotal = total / (1 + CalculateSalesTax(1))
+	}
+	return subtotal, total, nil
+}
+
+// InsertChangeOrder writes a pending change order and its items.
+func (s *SQLStore) InsertChangeOrder(ctx context.Context, co *ChangeOrder) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Only one pending change order at a time, so each one prices against a settled contract
+	var pending int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM change_orders WHERE estimate_id = $1 AND status = $2`,
+		co.EstimateID, changePending).Scan(&pending); err != nil {
+		return err
+	}
+	if pending > 0 {
+		return fmt.Errorf("estimate %d already has a pending change order", co.EstimateID)
+	}
+
+	const stmt = `INSERT INTO change_orders (
+		estimate_id, seq, reason, prev_subtotal, subtotal, sales_tax, total_cost, status, created_by, created_at)
+		VALUES ($1, (SELECT COALESCE(MAX(seq), 0) + 1 FROM change_orders WHERE estimate_id = $1),
+		$2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING change_order_id, seq`
+	var createdBy any
+	if co.CreatedBy > 0 {
+		createdBy = co.CreatedBy
+	}
+	err = tx.QueryRowContext(ctx, stmt, co.EstimateID, co.Reason, co.PrevSubtotal, co.Subtotal, co.SalesTax, co.TotalCost,
+		co.Status, createdBy, co.CreatedAt).Scan(&co.ChangeOrderID, &co.Seq)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range co.Items {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO change_order_items (
+			change_order_id, line, action, category, description, old_amount, new_amount)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			co.ChangeOrderID, item.Line, item.Action, item.Category, item.Description,
+			item.OldAmount, item.NewAmount); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AcceptChangeOrder records the homeowner's acceptance of a pending change order
+// and moves the payments not yet made to the new contract total.
+func (s *SQLStore) AcceptChangeOrder(ctx context.Context, co *ChangeOrder) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	// The signer's name and address identify the homeowner, as on an acceptance
+	signerName, ipAddress := co.SignerName, co.IPAddress
+	if err := piiKeys.encryptAll(&signerName, &ipAddress); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var total float64
+	var seq int
+	err = tx.QueryRowContext(ctx, `UPDATE change_orders SET status = $1, accept_date = $2, signer_name = $3,
+		ip_address = $4, user_agent = $5
+		WHERE change_order_id = $6 AND estimate_id = $7 AND status = $8
+		RETURNING total_cost, seq`,
+		changeAccepted, co.AcceptDate, signerName, ipAddress, co.UserAgent,
+		co.ChangeOrderID, co.EstimateID, changePending).Scan(&total, &seq)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("change order %d is not pending", co.ChangeOrderID)
+	}
+	if err != nil {
+		return err
+	}
+	if err := reschedulePayments(ctx, tx, co.EstimateID, total, fmt.Sprintf("Change order #%d", seq)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LoadChangeOrders reads the change order history for an estimate, oldest first.
+func (s *SQLStore) LoadChangeOrders(ctx context.Context, estimateID int) ([]ChangeOrder, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT change_order_id, estimate_id, seq, reason, prev_subtotal, subtotal,
+			sales_tax, total_cost, status, COALESCE(created_by, 0), created_at, accept_date,
+			COALESCE(signer_name, ''), COALESCE(ip_address, ''), COALESCE(user_agent, '')
+		FROM change_orders WHERE estimate_id = $1 ORDER BY seq`, estimateID)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []ChangeOrder
+	for rows.Next() {
+		var co ChangeOrder
+		var acceptDate sql.NullTime
+		if err := rows.Scan(&co.ChangeOrderID, &co.EstimateID, &co.Seq, &co.Reason, &co.PrevSubtotal,
+			&co.Subtotal, &co.SalesTax, &co.TotalCost, &co.Status, &co.CreatedBy, &co.CreatedAt, &acceptDate,
+			&co.SignerName, &co.IPAddress, &co.UserAgent); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := piiKeys.decryptAll(&co.SignerName, &co.IPAddress); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		co.AcceptDate = acceptDate.Time
+		orders = append(orders, co)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range orders {
+		items, err := s.db.QueryContext(ctx, `SELECT line, action, category, description, old_amount, new_amount
+			FROM change_order_items WHERE change_order_id = $1 ORDER BY line`, orders[i].ChangeOrderID)
+		if err != nil {
+			return nil, err
+		}
+		for items.Next() {
+			var item ChangeOrderItem
+			if err := items.Scan(&item.Line, &item.Action, &item.Category, &item.Description,
+				&item.OldAmount, &item.NewAmount); err != nil {
+				items.Close()
+				return nil, err
+			}
+			orders[i].Items = append(orders[i].Items, item)
+		}
+		items.Close()
+		if err := items.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return orders, nil
+}
+
+// canAcceptChangeOrder reports whether the change order is pending and was
+// proposed by someone other than userID.
+func canAcceptChangeOrder(orders []ChangeOrder, changeOrderID, userID int64) bool {
+	for _, co := range orders {
+		if co.ChangeOrderID == changeOrderID {
+			return co.Status == changePending && co.CreatedBy != userID
+		}
+	}
+	return false
+}
+
+// **********************************************************************************
+// changeOrderHandler - /changeorder?id=1000
+//
+//	GET  - Change order history, the contract total, and the pending change order.
+//	POST - op=create (admin) prices and saves a new pending change order.
+//	       op=accept (the estimate's owner) signs the pending change order.  Whoever
+//	       proposed it cannot accept it, even if it is their own estimate.
+//
+// **********************************************************************************
+func changeOrderHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("changeorder.html").Funcs(funcMap).ParseFiles("templates/changeorder.html",
+		"templates/header.html", "templates/footer.html"))
+
+	estimateID, ok := checkedEstimateID(r) // Checked in requireEstimate
+	if !ok {
+		notFoundHandler(w, r)
+		return
+	}
+
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	ownerID, err := estimateStore.EstimateOwner(r.Context(), estimateID)
+	if err == sql.ErrNoRows {
+		notFoundHandler(w, r)
+		return
+	} else if err != nil {
+		log.Printf("Failed to load owner of estimate %d: %v", estimateID, err)
+		http.Error(w, "Database error: Estimate not available.", http.StatusInternalServerError)
+		return
+	}
+	isAdmin := sd.UserAuth.IsAuthenticated && sd.UserAuth.Role == roleAdmin
+	isOwner := sd.UserAuth.IsAuthenticated && ownerID != 0 && ownerID == sd.UserAuth.ID
+	if !isAdmin && !isOwner {
+		notFoundHandler(w, r)
+		return
+	}
+
+	userAuth := getUserAuth(r, w)
+	userAuth.Title = "Change Orders"
+	data := ChangeOrderPageData{
+		EstimateID: estimateID,
+		IsAdmin:    isAdmin,
+		Categories: changeCategories,
+		Rows:       make([]int, changeOrderRows),
+	}
+	rd := renderData{
+		Page:   &data,
+		Header: &userAuth,
+	}
+	render := func() {
+		if err := tmpl.ExecuteTemplate(w, "changeorder.html", rd); err != nil {
+			log.Printf("changeOrderHandler execute error: %v", err)
+			panic(err)
+		}
+	}
+
+	data.OrigSubtotal, data.OrigTotal, err = estimateStore.LoadAcceptedTotals(r.Context(), estimateID)
+	if err == sql.ErrNoRows {
+		notFoundHandler(w, r)
+		return
+	} else if err != nil {
+		log.Printf("Change orders for estimate %d: %v", estimateID, err)
+		data.Error = "This estimate has not been accepted."
+		render()
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		orders, err := estimateStore.LoadChangeOrders(r.Context(), estimateID)
+		if err != nil {
+			log.Printf("Failed to load change orders for estimate %d: %v", estimateID, err)
+			data.Error = "Database error: Change orders not available."
+			render()
+			return
+		}
+
+		switch r.FormValue("op") {
+		case "create":
+			if !isAdmin {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			items, err := parseChangeOrderItems(r)
+			if err != nil {
+				data.Error = "Change order not saved: " + err.Error()
+				break
+			}
+			estimate, _, err := estimateStore.LoadEstimate(r.Context(), estimateID)
+			if err != nil {
+				log.Printf("Failed to load estimate %d: %v", estimateID, err)
+				data.Error = "Database error: Change order not saved."
+				break
+			}
+			estimate.fillBreakdown(costs)
+			if err := priceChangeOrderItems(items, contractLines(estimate, orders)); err != nil {
+				data.Error = "Change order not saved: " + err.Error()
+				break
+			}
+			subtotal, _ := contractTotals(data.OrigSubtotal, data.OrigTotal, orders)
+			co, err := newChangeOrder(estimateID, strings.TrimSpace(r.FormValue("reason")), items, subtotal)
+			if err != nil {
+				data.Error = "Change order not saved: " + err.Error()
+				break
+			}
+			co.CreatedBy = sd.UserAuth.ID
+			if err := estimateStore.InsertChangeOrder(r.Context(), &co); err != nil {
+				log.Printf("Failed to save change order for estimate %d: %v", estimateID, err)
+				data.Error = "Change order not saved.  Only one change order can be pending at a time."
+				break
+			}
+			log.Printf("Change order %d (#%d) created for estimate %d: new total %.2f",
+				co.ChangeOrderID, co.Seq, estimateID, co.TotalCost)
+			data.Message = fmt.Sprintf("Change order #%d saved.  Waiting for homeowner acceptance.", co.Seq)
+
+		case "accept":
+			changeOrderID, _ := strconv.ParseInt(r.FormValue("changeOrderID"), 10, 64)
+			if !isOwner || !canAcceptChangeOrder(orders, changeOrderID, sd.UserAuth.ID) {
+				log.Printf("Change order %d for estimate %d: user %d may not accept it", changeOrderID, estimateID, sd.UserAuth.ID)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			signerName := strings.TrimSpace(r.FormValue("signerName"))
+			if signerName == "" || r.FormValue("consent") != "on" {
+				data.Error = "Please type your full name and check the box to accept the change order."
+				break
+			}
+			co := ChangeOrder{
+				ChangeOrderID: changeOrderID,
+				EstimateID:    estimateID,
+				AcceptDate:    time.Now(),
+				SignerName:    signerName,
+				IPAddress:     clientIP(r),
+				UserAgent:     r.UserAgent(),
+			}
+			if err := estimateStore.AcceptChangeOrder(r.Context(), &co); err != nil {
+				log.Printf("Failed to accept change order %d: %v", changeOrderID, err)
+				data.Error = "Change order could not be accepted."
+				break
+			}
+			log.Printf("Change order %d for estimate %d accepted by %q", changeOrderID, estimateID, redactName(signerName))
+			data.Message = "Change order accepted."
+		}
+	}
+
+	data.ChangeOrders, err = estimateStore.LoadChangeOrders(r.Context(), estimateID)
+	if err != nil {
+		log.Printf("Failed to load change orders for estimate %d: %v", estimateID, err)
+		data.Error = "Database error: Change orders not available."
+	}
+	_, data.ContractTotal = contractTotals(data.OrigSubtotal, data.OrigTotal, data.ChangeOrders)
+	for i := range data.ChangeOrders {
+		if data.ChangeOrders[i].Status == changePending {
+			data.Pending = &data.ChangeOrders[i]
+			data.CanAccept = isOwner && data.Pending.CreatedBy != sd.UserAuth.ID
+		}
+	}
+	if isAdmin && data.Pending == nil {
+		if estimate, _, err := estimateStore.LoadEstimate(r.Context(), estimateID); err != nil {
+			log.Printf("Failed to load estimate %d: %v", estimateID, err)
+		} else {
+			estimate.fillBreakdown(costs)
+			data.Lines = contractLines(estimate, data.ChangeOrders)
+		}
+	}
+
+	render()
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPriceChangeOrder(t *testing.T) {
+	estimate := DeckEstimate{DeckCost: 10000, RailCost: 3000, StairCost: 1500, Subtotal: 14500}
+	accepted := []ChangeOrder{{
+		Status: changeAccepted,
+		Items:  []ChangeOrderItem{{Action: changeAdd, Category: "Other", NewAmount: 500}},
+	}}
+	pending := []ChangeOrder{{
+		Status: changePending,
+		Items:  []ChangeOrderItem{{Action: changeRemove, Category: "Deck", OldAmount: 10000}},
+	}}
+
+	tests := []struct {
+		name         string
+		orders       []ChangeOrder
+		prevSubtotal float64
+		items        []ChangeOrderItem
+		wantOld      []float64
+		wantSubtotal float64
+		wantErr      bool
+	}{
+		{
+			name:         "add",
+			prevSubtotal: 14500,
+			items:        []ChangeOrderItem{{Line: 1, Action: changeAdd, Category: "Fascia", NewAmount: 800}},
+			wantOld:      []float64{0},
+			wantSubtotal: 15300,
+		},
+		{
+			name:         "remove takes the contract amount",
+			prevSubtotal: 14500,
+			items:        []ChangeOrderItem{{Line: 1, Action: changeRemove, Category: "Rail"}},
+			wantOld:      []float64{3000},
+			wantSubtotal: 11500,
+		},
+		{
+			name:         "modify ignores a typed old amount",
+			prevSubtotal: 14500,
+			items:        []ChangeOrderItem{{Line: 1, Action: changeModify, Category: "Stairs", OldAmount: 99999, NewAmount: 2000}},
+			wantOld:      []float64{1500},
+			wantSubtotal: 15000,
+		},
+		{
+			name:         "second line sees the first",
+			prevSubtotal: 14500,
+			items: []ChangeOrderItem{
+				{Line: 1, Action: changeModify, Category: "Deck", NewAmount: 12000},
+				{Line: 2, Action: changeRemove, Category: "Deck"},
+			},
+			wantOld:      []float64{10000, 12000},
+			wantSubtotal: 4500,
+		},
+		{
+			name:         "accepted change orders count",
+			orders:       accepted,
+			prevSubtotal: 15000,
+			items:        []ChangeOrderItem{{Line: 1, Action: changeRemove, Category: "Other"}},
+			wantOld:      []float64{500},
+			wantSubtotal: 14500,
+		},
+		{
+			name:         "pending change orders do not",
+			orders:       pending,
+			prevSubtotal: 14500,
+			items:        []ChangeOrderItem{{Line: 1, Action: changeModify, Category: "Deck", NewAmount: 9000}},
+			wantOld:      []float64{10000},
+			wantSubtotal: 13500,
+		},
+		{
+			name:         "nothing to remove",
+			prevSubtotal: 14500,
+			items:        []ChangeOrderItem{{Line: 1, Action: changeRemove, Category: "Demo"}},
+			wantErr:      true,
+		},
+		{
+			name:         "modify to nothing",
+			prevSubtotal: 14500,
+			items:        []ChangeOrderItem{{Line: 1, Action: changeModify, Category: "Rail"}},
+			wantErr:      true,
+		},
+		{
+			name:         "removed twice",
+			prevSubtotal: 14500,
+			items: []ChangeOrderItem{
+				{Line: 1, Action: changeRemove, Category: "Rail"},
+				{Line: 2, Action: changeRemove, Category: "Rail"},
+			},
+			wantErr: true,
+		},
+		{
+			name:         "negative subtotal",
+			prevSubtotal: 1000, // Subtotal stored lower than the line items
+			items:        []ChangeOrderItem{{Line: 1, Action: changeRemove, Category: "Deck"}},
+			wantOld:      []float64{10000},
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := append([]ChangeOrderItem(nil), tt.items...)
+			err := priceChangeOrderItems(items, contractLines(estimate, tt.orders))
+			var co ChangeOrder
+			if err == nil {
+				for i, want := range tt.wantOld {
+					if items[i].OldAmount != want {
+						t.Errorf("line %d: OldAmount = %v, want %v", i+1, items[i].OldAmount, want)
+					}
+				}
+				co, err = newChangeOrder(1000, "", items, tt.prevSubtotal)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if co.PrevSubtotal != tt.prevSubtotal || co.Subtotal != tt.wantSubtotal {
+				t.Errorf("subtotal %v -> %v, want %v -> %v", co.PrevSubtotal, co.Subtotal, tt.prevSubtotal, tt.wantSubtotal)
+			}
+			if want := tt.wantSubtotal + CalculateSalesTax(tt.wantSubtotal); math.Abs(co.TotalCost-want) > 0.001 {
+				t.Errorf("TotalCost = %v, want %v", co.TotalCost, want)
+			}
+			if co.Status != changePending {
+				t.Errorf("Status = %q, want pending", co.Status)
+			}
+		})
+	}
+}
+
+func TestContractTotals(t *testing.T) {
+	orders := []ChangeOrder{
+		{Status: changeAccepted, Subtotal: 11000, TotalCost: 11957},
+		{Status: changeAccepted, Subtotal: 12000, TotalCost: 13044},
+		{Status: changePending, Subtotal: 20000, TotalCost: 21740},
+	}
+	tests := []struct {
+		name          string
+		orders        []ChangeOrder
+		subtotal, tot float64
+	}{
+		{"none", nil, 10000, 10870},
+		{"latest accepted", orders[:2], 12000, 13044},
+		{"pending ignored", orders, 12000, 13044},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subtotal, total := contractTotals(10000, 10870, tt.orders)
+			if subtotal != tt.subtotal || total != tt.tot {
+				t.Errorf("got %v / %v, want %v / %v", subtotal, total, tt.subtotal, tt.tot)
+			}
+		})
+	}
+}
+
+func TestCanAcceptChangeOrder(t *testing.T) {
+	const admin, owner = 1, 2
+	orders := []ChangeOrder{
+		{ChangeOrderID: 10, Status: changeAccepted, CreatedBy: admin},
+		{ChangeOrderID: 11, Status: changePending, CreatedBy: admin},
+		{ChangeOrderID: 12, Status: changePending, CreatedBy: owner},
+	}
+	tests := []struct {
+		name          string
+		changeOrderID int64
+		userID        int64
+		want          bool
+	}{
+		{"owner accepts admin's change", 11, owner, true},
+		{"proposer cannot accept", 11, admin, false},
+		{"owner cannot accept their own proposal", 12, owner, false},
+		{"already accepted", 10, owner, false},
+		{"unknown change order", 99, owner, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canAcceptChangeOrder(orders, tt.changeOrderID, tt.userID); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseChangeOrderItemsModifyAmount(t *testing.T) {
+	for _, amount := range []string{"", "  "} {
+		form := url.Values{"change": {changeModify}, "category": {"Rail"}, "description": {"Glass rail"},
+			"newAmount": {amount}}
+		r := httptest.NewRequest("POST", "/changeorder", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseChangeOrderItems(r); err == nil {
+			t.Errorf("modify with amount %q parsed", amount)
+		}
+	}
+}
+
+func TestAcceptChangeOrderReschedulesPayments(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	now := time.Now()
+
+	e := DeckEstimate{Customer: Customer{FirstName: "Ann"}, SaveDate: now, ExpirationDate: now.Add(24 * time.Hour),
+		ProductType: productDeck}
+	if err := st.InsertEstimate(ctx, &e, 0, statusSaved); err != nil {
+		t.Fatal(err)
+	}
+	a := Acceptance{EstimateID: e.EstimateID, SignerName: "Ann", Consent: true, AcceptedAt: now, DocumentHash: hashDocument("")}
+	payments := []PaymentMilestone{
+		{Seq: 1, Name: "Deposit", Due: "On signing", Percent: 50, Amount: 5000},
+		{Seq: 2, Name: "Final", Due: "On completion", Percent: 50, Amount: 5000},
+	}
+	if err := st.InsertAcceptance(ctx, &a, payments); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.db.ExecContext(ctx, `UPDATE payment_milestones SET paid_at = $1 WHERE estimate_id = $2 AND seq = 1`,
+		now, e.EstimateID); err != nil {
+		t.Fatal(err)
+	}
+
+	co := ChangeOrder{EstimateID: e.EstimateID, Reason: "Wider stairs", Subtotal: 11000, TotalCost: 12000,
+		Status: changePending, CreatedAt: now}
+	if err := st.InsertChangeOrder(ctx, &co); err != nil {
+		t.Fatal(err)
+	}
+	co.AcceptDate, co.SignerName = now, "Ann"
+	if err := st.AcceptChangeOrder(ctx, &co); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.AcceptChangeOrder(ctx, &co); err == nil {
+		t.Errorf("change order accepted twice")
+	}
+
+	got, err := st.LoadPaymentSchedule(ctx, e.EstimateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Amount != 5000 || !got[0].Paid || got[1].Amount != 7000 || got[1].Paid {
+		t.Errorf("payments = %+v, want the paid deposit kept and 7000 left to pay", got)
+	}
+}
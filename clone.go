@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EstimateTemplate is a named set of calculator inputs, e.g. "16x12 composite, 3ft high, one stair".
+// Only inputs are kept - using a template always reprices against the current costs.yaml.
+type EstimateTemplate struct {
+	TemplateID     int64
+	Name           string
+	Desc           string
+	ProductType    string
+	Length         float64
+	Width          float64
+	Height         float64
+	Material       string
+	RailMaterial   string
+	RailInfill     string
+	StairWidth     float64
+	StairRailCount float64
+	HasDemo        bool
+	HasFascia      bool
+	HasStairFascia bool
+	HasStairTK     bool
+	CreatedBy      int64
+	CreatedAt      time.Time
+}
+
+// TemplatesPageData holds data for the estimate templates page.
+type TemplatesPageData struct {
+	Templates []EstimateTemplate
+	Estimate  DeckEstimate // Current session estimate - can be saved as a template
+	IsAdmin   bool
+	Message   string
+	Error     string
+}
+
+// copyInputs copies the calculator inputs from src, leaving costs, customer and dates alone.
+func (e *DeckEstimate) copyInputs(src DeckEstimate) {
+	e.Desc = src.Desc
+	e.ProductType = src.ProductType
+	e.Length = src.Length
+	e.Width = src.Width
+	e.Height = src.Height
+	e.Material = src.Material
+	e.RailMaterial = src.RailMaterial
+	e.RailInfill = src.RailInfill
+	e.StairWidth = src.StairWidth
+	e.StairRailCount = src.StairRailCount
+	e.HasDemo = src.HasDemo
+	e.HasFascia = src.HasFascia
+	e.HasStairFascia = src.HasStairFascia
+	e.HasStairTK = src.HasStairTK
+	if e.ProductType == "" {
+		e.ProductType = productDeck
+	}
+}
+
+// templateFromEstimate keeps the inputs of an estimate under a name.
+func templateFromEstimate(name string, e DeckEstimate) EstimateTemplate {
+	return EstimateTemplate{
+		Name:           name,
+		Desc:           e.Desc,
+		ProductType:    e.ProductType,
+		Length:         e.Length,
+		Width:          e.Width,
+		Height:         e.Height,
+		Material:       e.Material,
+		RailMaterial:   e.RailMaterial,
+		RailInfill:     e.RailInfill,
+		StairWidth:     e.StairWidth,
+		StairRailCount: e.StairRailCount,
+		HasDemo:        e.HasDemo,
+		HasFascia:      e.HasFascia,
+		HasStairFascia: e.HasStairFascia,
+		HasStairTK:     e.HasStairTK,
+	}
+}
+
+// estimate returns the template inputs as an unpriced estimate.
+func (t EstimateTemplate) estimate() DeckEstimate {
+	return DeckEstimate{
+		Desc:           t.Desc,
+		ProductType:    t.ProductType,
+		Length:         t.Length,
+		Width:          t.Width,
+		Height:         t.Height,
+		Material:       t.Material,
+		RailMaterial:   t.RailMaterial,
+		RailInfill:     t.RailInfill,
+		StairWidth:     t.StairWidth,
+		StairRailCount: t.StairRailCount,
+		HasDemo:        t.HasDemo,
+		HasFascia:      t.HasFascia,
+		HasStairFascia: t.HasStairFascia,
+		HasStairTK:     t.HasStairTK,
+	}
+}
+
+// SaveTemplate inserts or replaces the template with the same name.
+func (s *SQLStore) SaveTemplate(ctx context.Context, t *EstimateTemplate) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	const stmt = `INSERT INTO estimate_templates (
+		name, description, product_type, length, width, height, material, rail_material, rail_infill,
+		stair_width, stair_rail_count, has_demo, has_fascia, has_stair_fascia, has_stair_tk, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (name) DO UPDATE SET
+		description = EXCLUDED.description, product_type = EXCLUDED.product_type,
+		length = EXCLUDED.length, width = EXCLUDED.width, height = EXCLUDED.height,
+		material = EXCLUDED.material, rail_material = EXCLUDED.rail_material, rail_infill = EXCLUDED.rail_infill,
+		stair_width = EXCLUDED.stair_width, stair_rail_count = EXCLUDED.stair_rail_count,
+		has_demo = EXCLUDED.has_demo, has_fascia = EXCLUDED.has_fascia,
+		has_stair_fascia = EXCLUDED.has_stair_fascia, has_stair_tk = EXCLUDED.has_stair_tk,
+		created_by = EXCLUDED.created_by
+		RETURNING template_id`
+	return s.db.QueryRowContext(ctx, stmt, t.Name, t.Desc, t.ProductType, t.Length, t.Width, t.Height,
+		t.Material, t.RailMaterial, t.RailInfill, t.StairWidth, t.StairRailCount,
+		t.HasDemo, t.HasFascia, t.HasStairFascia, t.HasStairTK, nullID(t.CreatedBy)).Scan(&t.TemplateID)
+}
+
+const templateColumns = `template_id, name, description, product_type, length, width, height,
+	material, rail_material, rail_infill, stair_width, stair_rail_count,
+	has_demo, has_fascia, has_stair_fascia, has_stair_tk, COALESCE(created_by, 0), created_at`
+
+// scanTemplate reads templateColumns from a row.
+func scanTemplate(row interface{ Scan(...any) error }) (EstimateTemplate, error) {
+	var t EstimateTemplate
+	err := row.Scan(&t.TemplateID, &t.Name, &t.Desc, &t.ProductType, &t.Length, &t.Width, &t.Height,
+		&t.Material, &t.RailMaterial, &t.RailInfill, &t.StairWidth, &t.StairRailCount,
+		&t.HasDemo, &t.HasFascia, &t.HasStairFascia, &t.HasStairTK, &t.CreatedBy, &t.CreatedAt)
+	return t, err
+}
+
+// LoadTemplates reads every template by name.
+func (s *SQLStore) LoadTemplates(ctx context.Context) ([]EstimateTemplate, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+templateColumns+` FROM estimate_templates ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []EstimateTemplate
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// LoadTemplate reads one template.
+func (s *SQLStore) LoadTemplate(ctx context.Context, templateID int64) (EstimateTemplate, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	return scanTemplate(s.db.QueryRowContext(ctx, `SELECT `+templateColumns+` FROM estimate_templates WHERE template_id = $1`, templateID))
+}
+
+// DeleteTemplate removes a template.
+func (s *SQLStore) DeleteTemplate(ctx context.Context, templateID int64) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM estimate_templates WHERE template_id = $1`, templateID)
+	return err
+}
+
+// startDraft reprices the inputs against current costs and makes it the session estimate.
+// The session customer is kept so staff can go straight to saving.
+func startDraft(w http.ResponseWriter, r *http.Request, sd *SessionData, inputs DeckEstimate) DeckEstimate {
+	draft := DeckEstimate{}
+	draft.copyInputs(inputs)
+	draft.unsave()
+	draft.Calculate(costs)
+
+	sd.Estimate = draft
+	if err := sd.Save(r, w); err != nil {
+		log.Printf("Failed to save Session Data in startDraft()")
+	}
+	return draft
+}
+
+// **********************************************************************************
+// cloneHandler - POST /estimate/clone  id=1000
+//
+//	Copies the inputs and customer of a saved estimate into a new unsaved draft,
+//	repriced at today's costs.  Anyone who can see the estimate can clone it -
+//	requireEstimate checks that (see canAccessEstimate).
+//
+// **********************************************************************************
+func cloneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/estimate", http.StatusSeeOther)
+		return
+	}
+
+	estimateID, ok := checkedEstimateID(r) // Checked in requireEstimate
+	if !ok {
+		notFoundHandler(w, r)
+		return
+	}
+
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	src, _, err := estimateStore.LoadEstimate(r.Context(), estimateID)
+	if err != nil {
+		log.Printf("Failed to load estimate %d: %v", estimateID, err)
+		renderEstimate(w, r, DeckEstimate{Error: "Database error: Estimate not available."})
+		return
+	}
+
+	sd.Customer = src.Customer
+	draft := startDraft(w, r, sd, src)
+	log.Printf("Estimate %d cloned to a new draft: %s", estimateID, formatCost(draft.TotalCost))
+
+	http.Redirect(w, r, "/estimate", http.StatusSeeOther)
+}
+
+// **********************************************************************************
+// templatesHandler - /templates  (staff)
+//
+//	GET  - List the saved estimate templates.
+//	POST - op=use id=N  Start a new estimate from a template and open the calculator.
+//
+// **********************************************************************************
+func templatesHandler(w http.ResponseWriter, r *http.Request) {
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	data := TemplatesPageData{Estimate: sd.Estimate, IsAdmin: sd.UserAuth.Role == roleAdmin}
+
+	if r.Method == http.MethodPost && r.FormValue("op") == "use" {
+		templateID, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		t, err := templateStore.LoadTemplate(r.Context(), templateID)
+		if err != nil {
+			log.Printf("Failed to load template %d: %v", templateID, err)
+			data.Error = "Template not available."
+		} else {
+			draft := startDraft(w, r, sd, t.estimate())
+			log.Printf("Estimate started from template %q: %s", t.Name, formatCost(draft.TotalCost))
+			http.Redirect(w, r, "/calc", http.StatusSeeOther)
+			return
+		}
+	}
+	renderTemplates(w, r, &data)
+}
+
+// **********************************************************************************
+// templateEditHandler - POST /templates/edit  (admin)
+//
+//	op=save   name=..  Save the session estimate inputs as a template.
+//	op=delete id=N     Remove a template.
+//
+// **********************************************************************************
+func templateEditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/templates", http.StatusSeeOther)
+		return
+	}
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	data := TemplatesPageData{Estimate: sd.Estimate, IsAdmin: true}
+
+	switch r.FormValue("op") {
+	case "save":
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" || sd.Estimate.Length <= 0 || sd.Estimate.Width <= 0 {
+			data.Error = "Calculate an estimate and give the template a name before saving."
+			break
+		}
+		t := templateFromEstimate(name, sd.Estimate)
+		t.CreatedBy = sd.UserAuth.ID
+		if err := templateStore.SaveTemplate(r.Context(), &t); err != nil {
+			log.Printf("Failed to save template %q: %v", name, err)
+			data.Error = "Database error: Template not saved."
+			break
+		}
+		data.Message = "Template \"" + name + "\" saved."
+
+	case "delete":
+		templateID, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err := templateStore.DeleteTemplate(r.Context(), templateID); err != nil {
+			log.Printf("Failed to delete template %d: %v", templateID, err)
+			data.Error = "Database error: Template not deleted."
+			break
+		}
+		data.Message = "Template deleted."
+	}
+	renderTemplates(w, r, &data)
+}
+
+// renderTemplates lists the saved templates on the templates page.
+func renderTemplates(w http.ResponseWriter, r *http.Request, data *TemplatesPageData) {
+	tmpl := template.Must(template.New("templates.html").Funcs(funcMap).ParseFiles("templates/templates.html",
+		"templates/header.html", "templates/footer.html"))
+
+	var err error
+	if data.Templates, err = templateStore.LoadTemplates(r.Context()); err != nil {
+		log.Printf("Failed to load templates: %v", err)
+		data.Error = "Database error: Templates not available."
+	}
+
+	userAuth := getUserAuth(r, w)
+	userAuth.Title = "Estimate Templates"
+	rd := renderData{
+		Page:   data,
+		Header: &userAuth,
+	}
+	if err := tmpl.ExecuteTemplate(w, "templates.html", rd); err != nil {
+		log.Printf("templatesHandler execute error: %v", err)
+		panic(err)
+	}
+}
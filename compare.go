@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// maxCompare is the most estimates shown side by side.
+const maxCompare = 3
+
+// CompareRow is one input or cost line across the compared estimates.
+type CompareRow struct {
+	Label   string
+	Values  []string
+	Differs bool // Not every estimate has the same value
+}
+
+// ComparePageData holds data for the estimate comparison page.
+type ComparePageData struct {
+	Saved     []DeckEstimate // The user's saved estimates to pick from
+	Estimates []DeckEstimate
+	Inputs    []CompareRow
+	Costs     []CompareRow
+	Error     string
+}
+
+// compareRow builds a row and flags it when the values are not all the same.
+func compareRow(label string, estimates []DeckEstimate, value func(e DeckEstimate) string) CompareRow {
+	row := CompareRow{Label: label}
+	for _, e := range estimates {
+		v := value(e)
+		if len(row.Values) > 0 && v != row.Values[0] {
+			row.Differs = true
+		}
+		row.Values = append(row.Values, v)
+	}
+	return row
+}
+
+// yesNo formats a bool for the comparison table.
+func yesNo(b bool) string {
+	if b {
+		return "Yes"
+	}
+	return "No"
+}
+
+// buildComparison lines up the inputs and every cost category shown on estimate.html.
+func buildComparison(estimates []DeckEstimate) ([]CompareRow, []CompareRow) {
+	feet := func(f float64) string { return fmt.Sprintf("%.1f ft", f) }
+
+	inputs := []CompareRow{
+		compareRow("Description", estimates, func(e DeckEstimate) string { return e.Desc }),
+		compareRow("Length", estimates, func(e DeckEstimate) string { return feet(e.Length) }),
+		compareRow("Width", estimates, func(e DeckEstimate) string { return feet(e.Width) }),
+		compareRow("Height", estimates, func(e DeckEstimate) string { return feet(e.Height) }),
+		compareRow("Deck Area", estimates, func(e DeckEstimate) string { return fmt.Sprintf("%.1f sq ft", e.DeckArea) }),
+		compareRow("Decking", estimates, func(e DeckEstimate) string { return e.Material }),
+		compareRow("Rail Material", estimates, func(e DeckEstimate) string { return e.RailMaterial }),
+		compareRow("Rail Infill", estimates, func(e DeckEstimate) string { return e.RailInfill }),
+		compareRow("Fascia", estimates, func(e DeckEstimate) string { return yesNo(e.HasFascia) }),
+		compareRow("Demo", estimates, func(e DeckEstimate) string { return yesNo(e.HasDemo) }),
+		compareRow("Stair Width", estimates, func(e DeckEstimate) string { return feet(e.StairWidth) }),
+		compareRow("Stair Rails", estimates, func(e DeckEstimate) string { return fmt.Sprintf("%.0f", e.StairRailCount) }),
+		compareRow("Stair Fascia", estimates, func(e DeckEstimate) string { return yesNo(e.HasStairFascia) }),
+		compareRow("Stair Toe Kicks", estimates, func(e DeckEstimate) string { return yesNo(e.HasStairTK) }),
+	}
+
+	cost := func(label string, f func(e DeckEstimate) float64) CompareRow {
+		return compareRow(label, estimates, func(e DeckEstimate) string { return formatCost(f(e)) })
+	}
+	costRows := []CompareRow{
+		cost("Demo", func(e DeckEstimate) float64 { return e.DemoCost }),
+		cost("Deck", func(e DeckEstimate) float64 { return e.DeckCost }),
+		cost("Rail", func(e DeckEstimate) float64 { return e.RailCost }),
+		cost("Fascia", func(e DeckEstimate) float64 { return e.FasciaCost }),
+		cost("Stairs", func(e DeckEstimate) float64 { return e.StairCost }),
+		cost("Stair Rails", func(e DeckEstimate) float64 { return e.StairRailCost }),
+		cost("Stair Fascia", func(e DeckEstimate) float64 { return e.StairFasciaCost }),
+		cost("Stair Toe Kicks", func(e DeckEstimate) float64 { return e.StairToeKickCost }),
+		cost("Subtotal", func(e DeckEstimate) float64 { return e.Subtotal }),
+		cost("Sales Tax", func(e DeckEstimate) float64 { return e.SalesTax }),
+		cost("Total", func(e DeckEstimate) float64 { return e.TotalCost }),
+	}
+	return inputs, costRows
+}
+
+// loadOwnedEstimate loads a saved estimate with its cost breakdown, if it belongs to the user.
+func loadOwnedEstimate(ctx context.Context, estimateID int, userID int64) (DeckEstimate, error) {
+	e, ownerID, err := estimateStore.LoadEstimate(ctx, estimateID)
+	if err != nil {
+		return DeckEstimate{}, err
+	}
+	if ownerID == 0 || ownerID != userID {
+		return DeckEstimate{}, sql.ErrNoRows
+	}
+
+	e.fillBreakdown(costs)
+	return e, nil
+}
+
+// **********************************************************************************
+// compareHandler - /estimate/compare?id=1000&id=1001&id=1002
+//
+//	Shows up to three of the user's saved estimates side by side, with the
+//	inputs and costs that differ highlighted.
+//
+// **********************************************************************************
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("compare.html").Funcs(funcMap).ParseFiles("templates/compare.html",
+		"templates/header.html", "templates/footer.html"))
+
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	userAuth := getUserAuth(r, w)
+	userAuth.Title = "Compare Estimates"
+	data := ComparePageData{}
+	rd := renderData{
+		Page:   &data,
+		Header: &userAuth,
+	}
+	render := func() {
+		if err := tmpl.ExecuteTemplate(w, "compare.html", rd); err != nil {
+			log.Printf("compareHandler execute error: %v", err)
+			panic(err)
+		}
+	}
+
+	var ids []int
+	seen := map[int]bool{}
+	for _, v := range r.URL.Query()["id"] {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	if len(ids) < 2 || len(ids) > maxCompare {
+		if len(ids) > 0 {
+			data.Error = fmt.Sprintf("Select 2 to %d saved estimates to compare.", maxCompare)
+		}
+		if data.Saved, err = estimateStore.LoadUserEstimates(r.Context(), sd.UserAuth.ID); err != nil {
+			log.Printf("Compare - failed to load estimates for user %d: %v", sd.UserAuth.ID, err)
+			data.Error = "Database error: Estimates not available."
+		}
+		render()
+		return
+	}
+
+	for _, id := range ids {
+		e, err := loadOwnedEstimate(r.Context(), id, sd.UserAuth.ID)
+		if err == sql.ErrNoRows {
+			notFoundHandler(w, r)
+			return
+		} else if err != nil {
+			log.Printf("Compare - failed to load estimate %d: %v", id, err)
+			data.Error = "Database error: Estimate not available."
+			render()
+			return
+		}
+		data.Estimates = append(data.Estimates, e)
+	}
+
+	data.Inputs, data.Costs = buildComparison(data.Estimates)
+	render()
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"html/template"
 	"io"
@@ -10,8 +11,8 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
@@ -27,14 +28,50 @@ type ContactForm struct {
 	Message string `json:"message"`
 }
 
-var sg *sendgrid.Client
+// ContactSubmission is a contact form message as stored in contact_submissions.
+type ContactSubmission struct {
+	SubmissionID int64     `json:"submission_id"`
+	UserID       int64     `json:"-"` // Logged in user when sent, 0 if none
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	Phone        string    `json:"phone"`
+	Project      string    `json:"project"`
+	Message      string    `json:"message"`
+	IPAddress    string    `json:"ip_address"`
+	SubmittedAt  time.Time `json:"submitted_at"`
+}
+
+// contactRetention is how long contact form messages are kept.  They are stored so
+// they can be answered and included in an account export, and are deleted by the
+// expiration sweep once they are older than this.
+var contactRetention = envDuration("CONTACT_RETENTION", 365*24*time.Hour)
+
+// InsertContactSubmission stores a contact form message, encrypted, and sets c.SubmissionID.
+func (s *SQLStore) InsertContactSubmission(ctx context.Context, c *ContactSubmission) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	enc := *c
+	if err := piiKeys.encryptAll(&enc.Name, &enc.Email, &enc.Phone, &enc.Message); err != nil {
+		return err
+	}
+	return s.db.QueryRowContext(ctx, `INSERT INTO contact_submissions
+		(user_id, name, email, email_index, phone, project, message, ip_address, submitted_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING submission_id`,
+		nullID(c.UserID), enc.Name, enc.Email, piiKeys.emailIndex(c.Email), enc.Phone, enc.Project, enc.Message,
+		c.IPAddress, c.SubmittedAt).Scan(&c.SubmissionID)
+}
+
+// PurgeContactSubmissions deletes the contact form messages submitted before the given time.
+func (s *SQLStore) PurgeContactSubmissions(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
 
-func init() {
-	apiKey := os.Getenv("SENDGRID_API_KEY")
-	if apiKey == "" {
-		log.Fatal("Unable to send email: SENDGRID_API_KEY is required")
+	res, err := s.db.ExecContext(ctx, `DELETE FROM contact_submissions WHERE submitted_at < $1`, before)
+	if err != nil {
+		return 0, err
 	}
-	sg = sendgrid.NewSendClient(apiKey)
+	return res.RowsAffected()
 }
 
 func contactHandler(w http.ResponseWriter, r *http.Request) {
@@ -93,6 +130,20 @@ func contactHandler(w http.ResponseWriter, r *http.Request) {
 			Message: r.FormValue("message"),
 		}
 
+		sub := ContactSubmission{
+			UserID:      getUserAuth(r, w).ID,
+			Name:        data.Name,
+			Email:       data.Email,
+			Phone:       data.Phone,
+			Project:     data.Project,
+			Message:     data.Message,
+			IPAddress:   clientIP(r),
+			SubmittedAt: time.Now(),
+		}
+		if err := contactStore.InsertContactSubmission(r.Context(), &sub); err != nil {
+			log.Printf("Failed to save contact submission: %v", err)
+		}
+
 		from := mail.NewEmail("Columbia Outdoor", "support@columbiaoutdoor.com")
 		toTeam := mail.NewEmail("Team - CO", "support@columbiaoutdoor.com")
 		replyTo := mail.NewEmail(data.Name, data.Email)
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -15,6 +16,22 @@ type Costs struct {
 	RailInfills   map[string]float64 `yaml:"rail_infills"`
 	DemoCost      float64            `yaml:"demo_cost"`
 	FasciaCost    float64            `yaml:"fascia_cost"`
+
+	ExpirationDays map[string]int `yaml:"expiration_days"` // Estimate valid days by product type
+	ReminderDays   []int          `yaml:"reminder_days"`   // Days before expiry to remind the customer
+
+	PaymentSchedules map[string][]PaymentTemplate `yaml:"payment_schedules"` // By product type
+}
+
+const defaultExpirationDays = 30
+
+// expirationWindow returns how long a saved estimate of this product type is valid.
+func (c Costs) expirationWindow(productType string) time.Duration {
+	days, ok := c.ExpirationDays[productType]
+	if !ok || days <= 0 {
+		days = defaultExpirationDays
+	}
+	return time.Duration(days) * 24 * time.Hour
 }
 
 // costs is the global pricing data, loaded at startup.
@@ -29,9 +46,57 @@ func loadCosts() error {
 	if err := yaml.Unmarshal(data, &costs); err != nil {
 		return fmt.Errorf("failed to parse costs.yaml: %v", err)
 	}
+	if err := validatePaymentSchedules(costs); err != nil {
+		return fmt.Errorf("invalid costs.yaml: %v", err)
+	}
 	return nil
 }
 
+// Calculate prices the estimate from its inputs using the given costs.
+// It stops at the first input problem and leaves the message in e.Error.
+func (e *DeckEstimate) Calculate(costs Costs) {
+	e.Error = ""
+	e.DeckArea = e.Length * e.Width
+
+	e.CalculateDeckCost(costs)
+	if e.Error != "" {
+		return
+	}
+	e.CalcStairCost(costs)
+	if e.Error != "" {
+		return
+	}
+	e.CalculateRailCost(costs)
+	if e.Error != "" {
+		return
+	}
+
+	e.CalculateStairRailCost(costs)
+	e.CalcStairFasciaCost(costs)
+	e.CalcStairToeKickCost(costs)
+	e.CalculateDemoCost(costs)
+	e.CalculateFasciaCost(costs)
+
+	e.Subtotal = e.DeckCost + e.RailCost + e.StairCost + e.StairRailCost + e.DemoCost + e.FasciaCost + e.StairFasciaCost
+	e.SalesTax = CalculateSalesTax(e.Subtotal)
+	e.TotalCost = e.Subtotal + e.SalesTax
+}
+
+// fillBreakdown works out the cost categories of an estimate saved before the
+// breakdown was stored.  Keep the totals - they are what the homeowner was quoted.
+func (e *DeckEstimate) fillBreakdown(costs Costs) {
+	if e.DeckCost > 0 {
+		return // Stored with the estimate
+	}
+	totalCost, subtotal, salesTax := e.TotalCost, e.Subtotal, e.SalesTax
+	e.Calculate(costs)
+	e.Error = ""
+	e.TotalCost = totalCost
+	if subtotal > 0 {
+		e.Subtotal, e.SalesTax = subtotal, salesTax
+	}
+}
+
 // Calculate Deck Costs
 func (e *DeckEstimate) CalculateDeckCost(costs Costs) {
 	area := e.Length * e.Width
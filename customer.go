@@ -45,7 +45,7 @@ func customerHandler(w http.ResponseWriter, r *http.Request) {
 			State:       r.FormValue("state"),
 			Zip:         r.FormValue("zip"),
 		}
-		log.Printf("Customer POST: %+v", customer)
+		log.Printf("Customer POST: %v", customer)
 		sessionData.Customer = customer
 		if err := sessionData.Save(r, w); err != nil {
 			log.Printf("Session save error: %v", err)
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EstimateDraft is the unsaved estimate a user was working on when they logged in,
+// kept with their account so it follows them to another browser.  Like a template
+// it keeps only the inputs - resuming it reprices at current costs.
+type EstimateDraft struct {
+	UserID    int64
+	Inputs    EstimateTemplate // Name, TemplateID, CreatedBy and CreatedAt are unused
+	Customer  Customer
+	UpdatedAt time.Time
+}
+
+// hasEstimate reports whether the draft has calculator inputs, not only a customer.
+func (d EstimateDraft) hasEstimate() bool {
+	return d.Inputs.Length > 0 && d.Inputs.Width > 0
+}
+
+// estimate returns the draft repriced at current costs.
+func (d EstimateDraft) estimate() DeckEstimate {
+	var e DeckEstimate
+	e.copyInputs(d.Inputs.estimate())
+	e.Calculate(costs)
+	return e
+}
+
+// mergeSessionOnLogin attaches the anonymous session's estimate and customer to
+// the account that just logged in, signed up or came back from Google.  Call it
+// once sd.UserAuth.ID is set, then save the session.  It returns a note for the
+// user, or "".
+//
+//	Session work, no stored draft     the session's estimate becomes the draft
+//	No session work, stored draft     the draft is resumed into the session
+//	Both, with the same inputs        nothing to do
+//	Both, different                   the session wins - it is what the user was
+//	                                  just doing - and replaces the stored draft
+//
+// The customer is the session's if one was entered, otherwise the draft's.
+// Estimates already saved are in the estimates table and are left alone.
+func mergeSessionOnLogin(ctx context.Context, sd *SessionData) (string, error) {
+	userID := sd.UserAuth.ID
+	if userID <= 0 {
+		return "", nil // No account row to attach to
+	}
+
+	stored, err := draftStore.LoadDraft(ctx, userID)
+	hasDraft := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	hasEstimate := sd.Estimate.EstimateID == 0 && sd.Estimate.TotalCost > 0
+	hasCustomer := sd.Customer != Customer{}
+
+	if !hasEstimate && !hasCustomer && !hasDraft {
+		return "", nil
+	}
+
+	merged := EstimateDraft{UserID: userID, Customer: sd.Customer}
+	switch {
+	case hasEstimate:
+		merged.Inputs = templateFromEstimate("", sd.Estimate)
+		if merged.Inputs.ProductType == "" {
+			merged.Inputs.ProductType = productDeck
+		}
+	case hasDraft && stored.hasEstimate():
+		merged.Inputs = stored.Inputs
+		sd.Estimate = stored.estimate()
+	}
+	if !hasCustomer && hasDraft {
+		merged.Customer = stored.Customer
+		sd.Customer = stored.Customer
+	}
+	sd.Estimate.Customer = sd.Customer
+
+	if !hasDraft || merged.Inputs != stored.Inputs || merged.Customer != stored.Customer {
+		if err := draftStore.SaveDraft(ctx, &merged); err != nil {
+			return "", err
+		}
+	}
+
+	switch {
+	case hasDraft && !hasEstimate && stored.hasEstimate():
+		return fmt.Sprintf("We brought back what you were working on %s.",
+			stored.UpdatedAt.Format("January 2")), nil
+	case hasDraft && merged.Inputs != stored.Inputs:
+		return fmt.Sprintf("Your estimate replaced the draft you started %s.",
+			stored.UpdatedAt.Format("January 2")), nil
+	case hasEstimate:
+		return "Your estimate has been kept with your account.", nil
+	}
+	return "", nil
+}
+
+// forgetPreviousUser clears the estimate and customer a different account left
+// in the session, so they do not pass to userID, who is logging in on the same
+// browser without the other signing out.  Call it before setting sd.UserAuth.
+// Work from before anyone logged in is kept - mergeSessionOnLogin attaches it.
+func forgetPreviousUser(sd *SessionData, userID int64) {
+	if sd.UserAuth.ID != 0 && sd.UserAuth.ID != userID {
+		sd.Estimate = DeckEstimate{}
+		sd.Customer = Customer{}
+	}
+}
+
+// mergeOnLogin runs mergeSessionOnLogin and adds its note to the welcome message.
+// A failure is logged - it should not stop the login.
+func mergeOnLogin(r *http.Request, sd *SessionData) {
+	note, err := mergeSessionOnLogin(r.Context(), sd)
+	if err != nil {
+		log.Printf("Keeping session estimate for user %d failed: %v", sd.UserAuth.ID, err)
+		return
+	}
+	if note != "" {
+		sd.UserAuth.Message = strings.TrimSpace(sd.UserAuth.Message + " " + note)
+	}
+}
+
+// SaveDraft stores the user's draft, replacing any they had.
+func (s *SQLStore) SaveDraft(ctx context.Context, d *EstimateDraft) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var customerID any
+	if d.Customer != (Customer{}) {
+		if customerID, err = customerFor(ctx, tx, d.UserID, d.Customer); err != nil {
+			return err
+		}
+	}
+
+	d.UpdatedAt = time.Now()
+	t := d.Inputs
+	_, err = tx.ExecContext(ctx, `INSERT INTO estimate_drafts (
+		user_id, customer_id, description, product_type, length, width, height, material, rail_material,
+		rail_infill, stair_width, stair_rail_count, has_demo, has_fascia, has_stair_fascia, has_stair_tk, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		ON CONFLICT (user_id) DO UPDATE SET
+		customer_id = EXCLUDED.customer_id, description = EXCLUDED.description,
+		product_type = EXCLUDED.product_type, length = EXCLUDED.length, width = EXCLUDED.width,
+		height = EXCLUDED.height, material = EXCLUDED.material, rail_material = EXCLUDED.rail_material,
+		rail_infill = EXCLUDED.rail_infill, stair_width = EXCLUDED.stair_width,
+		stair_rail_count = EXCLUDED.stair_rail_count, has_demo = EXCLUDED.has_demo,
+		has_fascia = EXCLUDED.has_fascia, has_stair_fascia = EXCLUDED.has_stair_fascia,
+		has_stair_tk = EXCLUDED.has_stair_tk, updated_at = EXCLUDED.updated_at`,
+		d.UserID, customerID, t.Desc, t.ProductType, t.Length, t.Width, t.Height, t.Material, t.RailMaterial,
+		t.RailInfill, t.StairWidth, t.StairRailCount, t.HasDemo, t.HasFascia, t.HasStairFascia, t.HasStairTK,
+		d.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LoadDraft reads the user's draft, or sql.ErrNoRows if they have none.
+func (s *SQLStore) LoadDraft(ctx context.Context, userID int64) (EstimateDraft, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	d := EstimateDraft{UserID: userID}
+	t := &d.Inputs
+	c := &d.Customer
+	err := s.db.QueryRowContext(ctx, `SELECT d.description, d.product_type, d.length, d.width, d.height,
+		d.material, d.rail_material, d.rail_infill, d.stair_width, d.stair_rail_count,
+		d.has_demo, d.has_fascia, d.has_stair_fascia, d.has_stair_tk, d.updated_at,
+		COALESCE(c.first_name, ''), COALESCE(c.last_name, ''), COALESCE(c.address, ''), COALESCE(c.city, ''),
+		COALESCE(c.state, ''), COALESCE(c.zip, ''), COALESCE(c.phone_number, ''), COALESCE(c.email, '')
+		FROM estimate_drafts d LEFT JOIN customers c ON c.customer_id = d.customer_id
+		WHERE d.user_id = $1`, userID).Scan(&t.Desc, &t.ProductType, &t.Length, &t.Width, &t.Height,
+		&t.Material, &t.RailMaterial, &t.RailInfill, &t.StairWidth, &t.StairRailCount,
+		&t.HasDemo, &t.HasFascia, &t.HasStairFascia, &t.HasStairTK, &d.UpdatedAt,
+		&c.FirstName, &c.LastName, &c.Address, &c.City, &c.State, &c.Zip, &c.PhoneNumber, &c.Email)
+	if err != nil {
+		return EstimateDraft{}, err
+	}
+	if err := c.decrypt(); err != nil {
+		return EstimateDraft{}, err
+	}
+	return d, nil
+}
+
+// DeleteDraft removes the user's draft, once it has been saved as an estimate.
+func (s *SQLStore) DeleteDraft(ctx context.Context, userID int64) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM estimate_drafts WHERE user_id = $1`, userID)
+	return err
+}
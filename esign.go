@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Acceptance is the e-signature record captured when a homeowner accepts an estimate.
+// Once written to estimate_acceptances it is never changed - pii rotate only
+// re-encrypts SignerName and DocumentText (see sql/migrations).
+type Acceptance struct {
+	AcceptanceID int64
+	EstimateID   int
+	UserID       int64
+	SignerName   string // Typed name of the signer
+	Consent      bool   // "I agree" checkbox
+	IPAddress    string
+	UserAgent    string
+	AcceptedAt   time.Time
+	DocumentHash string // sha256 of DocumentText
+	DocumentText string // The exact estimate and terms text shown to the signer
+}
+
+// SignedPageData holds data for the signed copy page.
+type SignedPageData struct {
+	Acceptance Acceptance
+	Verified   bool // DocumentText still matches DocumentHash
+	Payments   []PaymentMilestone
+	Error      string
+}
+
+// loadTerms reads the terms and conditions shown with every saved estimate.
+func loadTerms() string {
+	terms, err := os.ReadFile("static/t_and_c.txt")
+	if err != nil {
+		// Fallback if file is missing
+		return "Terms and Conditions not available."
+	}
+	return string(terms)
+}
+
+// acceptanceDocument renders the estimate and terms as plain text.
+// This is what gets hashed and stored, so keep the layout stable - any change
+// here changes the hash of every estimate shown afterwards.
+func acceptanceDocument(e DeckEstimate, terms string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Columbia Outdoor - Deck Estimate %d\n", e.EstimateID)
+	fmt.Fprintf(&b, "Saved: %s\n", e.SaveDate.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Expires: %s\n\n", e.ExpirationDate.UTC().Format(time.RFC3339))
+
+	fmt.Fprintf(&b, "Customer: %s %s\n", e.Customer.FirstName, e.Customer.LastName)
+	fmt.Fprintf(&b, "Address: %s, %s, %s %s\n", e.Customer.Address, e.Customer.City, e.Customer.State, e.Customer.Zip)
+	fmt.Fprintf(&b, "Phone: %s\n", e.Customer.PhoneNumber)
+	fmt.Fprintf(&b, "Email: %s\n\n", e.Customer.Email)
+
+	fmt.Fprintf(&b, "Description: %s\n", e.Desc)
+	fmt.Fprintf(&b, "Demo: %s\n", formatCost(e.DemoCost))
+	fmt.Fprintf(&b, "Deck: %s %s\n", formatDeckDescription(e), formatCost(e.DeckCost))
+	fmt.Fprintf(&b, "Rail: %s %s %.1f ft %s\n", e.RailMaterial, e.RailInfill, e.RailFeet, formatCost(e.RailCost))
+	fmt.Fprintf(&b, "Fascia: %.1f ft %s\n", e.FasciaFeet, formatCost(e.FasciaCost))
+	fmt.Fprintf(&b, "Stairs: %.1f ft wide %s\n", e.StairWidth, formatCost(e.StairCost))
+	fmt.Fprintf(&b, "Stair Rails: %.0f %s\n", e.StairRailCount, formatCost(e.StairRailCost))
+	fmt.Fprintf(&b, "Stair Fascia: %s\n", formatCost(e.StairFasciaCost))
+	fmt.Fprintf(&b, "Stair Toe Kicks: %s\n", formatCost(e.StairToeKickCost))
+	fmt.Fprintf(&b, "Subtotal: %s\n", formatCost(e.Subtotal))
+	fmt.Fprintf(&b, "Sales Tax: %s\n", formatCost(e.SalesTax))
+	fmt.Fprintf(&b, "Total: %s\n\n", formatCost(e.TotalCost))
+
+	fmt.Fprintf(&b, "Terms and Conditions\n%s\n", terms)
+	return b.String()
+}
+
+// hashDocument returns the hex sha256 of the document text.
+func hashDocument(doc string) string {
+	sum := sha256.Sum256([]byte(doc))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientIP returns the caller's IP.  Behind Cloud Run the connection comes from
+// Google's front end, which appends the address it saw to X-Forwarded-For - so
+// the client is the last entry, and anything to its left came from the client
+// and cannot be trusted.  TRUSTED_PROXY_HOPS (default 1) is how many proxies in
+// front of us append an entry.  Without a usable entry it is RemoteAddr.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+		entries := strings.Split(strings.Join(fwd, ","), ",")
+		if i := len(entries) - envInt("TRUSTED_PROXY_HOPS", 1); i >= 0 {
+			if ip := net.ParseIP(strings.TrimSpace(entries[i])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// acceptEstimate validates the signature form, writes the acceptance record and
+// marks the estimate accepted.  Any problem is returned in estimate.Error.
+func acceptEstimate(w http.ResponseWriter, r *http.Request, estimate *DeckEstimate, sd *SessionData) {
+	if estimate.IsExpired() {
+		estimate.Error = "This estimate expired on " + estimate.ExpirationDate.Format("2006-01-02") +
+			".  Please contact us or create a new estimate."
+		return
+	}
+
+	if !sd.UserAuth.IsAuthenticated {
+		estimate.Error = "Please log in to accept the estimate."
+		return
+	}
+	// Only the owner signs - an admin or contractor may have it open from ?id=
+	if ownerID, err := estimateStore.EstimateOwner(r.Context(), estimate.EstimateID); err != nil || ownerID != sd.UserAuth.ID {
+		if err != nil {
+			log.Printf("Failed to load owner of estimate %d: %v", estimate.EstimateID, err)
+		}
+		estimate.Error = "Only the homeowner the estimate was saved for can accept it."
+		return
+	}
+	if !emailVerified(r, w, sd) {
+		estimate.Error = "Please verify your email address before accepting.  We emailed you a link - " +
+			"or send a new one from My Account."
+		return
+	}
+
+	signerName := strings.TrimSpace(r.FormValue("signerName"))
+	if signerName == "" {
+		estimate.Error = "Please type your full name to sign the estimate."
+		return
+	}
+	if r.FormValue("consent") != "on" {
+		estimate.Error = "Please check the box to agree to the estimate and terms."
+		return
+	}
+
+	// The hash posted back must match what we would show now.  If the estimate
+	// or the terms changed since the page was rendered, make them review again.
+	doc := acceptanceDocument(*estimate, loadTerms())
+	docHash := hashDocument(doc)
+	if r.FormValue("docHash") != docHash {
+		log.Printf("Accept estimate %d: document hash mismatch", estimate.EstimateID)
+		estimate.Error = "The estimate or terms changed.  Please review and sign again."
+		return
+	}
+
+	a := Acceptance{
+		EstimateID:   estimate.EstimateID,
+		UserID:       sd.UserAuth.ID,
+		SignerName:   signerName,
+		Consent:      true,
+		IPAddress:    clientIP(r),
+		UserAgent:    r.UserAgent(),
+		AcceptedAt:   time.Now(),
+		DocumentHash: docHash,
+		DocumentText: doc,
+	}
+
+	payments := buildPaymentSchedule(costs, estimate.ProductType, estimate.TotalCost)
+	if err := estimateStore.InsertAcceptance(r.Context(), &a, payments); err != nil {
+		log.Printf("Failed to save acceptance for estimate %d: %v", estimate.EstimateID, err)
+		estimate.Error = "Database error: Accept Estimate failed."
+		return
+	}
+
+	estimate.AcceptDate = a.AcceptedAt
+	estimate.Acceptance = a
+	estimate.Acceptance.DocumentText = "" // Keep the session small - the signed copy lives in the DB
+	estimate.Payments = payments
+	sd.Estimate = *estimate
+	if err := sd.Save(r, w); err != nil {
+		log.Printf("Failed to save Session Data in acceptEstimate()")
+	}
+
+	log.Printf("Estimate %d accepted by %q at %v (acceptance %d)", a.EstimateID, redactName(a.SignerName), a.AcceptedAt, a.AcceptanceID)
+}
+
+// InsertAcceptance writes the acceptance record and payment schedule, and stamps
+// accept_date on the estimate.  It all happens in one transaction so an estimate
+// is never accepted without a record.
+func (s *SQLStore) InsertAcceptance(ctx context.Context, a *Acceptance, payments []PaymentMilestone) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const stmt = `INSERT INTO estimate_acceptances (
+		estimate_id, user_id, signer_name, consent, ip_address, user_agent,
+		accepted_at, document_hash, document_text)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING acceptance_id`
+
+	var userID any
+	if a.UserID > 0 {
+		userID = a.UserID
+	}
+	// The document has the customer's name and address in it, so both are PII
+	signerName, docText := a.SignerName, a.DocumentText
+	if err := piiKeys.encryptAll(&signerName, &docText); err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx, stmt, a.EstimateID, userID, signerName, a.Consent, a.IPAddress, a.UserAgent,
+		a.AcceptedAt, a.DocumentHash, docText).Scan(&a.AcceptanceID)
+	if err != nil {
+		return err
+	}
+
+	// Only a saved estimate that has not expired can be accepted
+	res, err := tx.ExecContext(ctx, `UPDATE estimates SET accept_date = $1, status = $2
+		WHERE estimate_id = $3 AND accept_date IS NULL AND status = $4 AND expiration_date > $1`,
+		a.AcceptedAt, statusAccepted, a.EstimateID, statusSaved)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return fmt.Errorf("estimate %d not found, expired, or already accepted", a.EstimateID)
+	}
+
+	if err := insertPaymentSchedule(ctx, tx, a.EstimateID, payments); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// LoadAcceptance reads the acceptance record for an estimate.
+func (s *SQLStore) LoadAcceptance(ctx context.Context, estimateID int) (Acceptance, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	const query = `SELECT acceptance_id, estimate_id, COALESCE(user_id, 0), signer_name, consent,
+		ip_address, user_agent, accepted_at, document_hash, document_text
+		FROM estimate_acceptances WHERE estimate_id = $1`
+
+	var a Acceptance
+	err := s.db.QueryRowContext(ctx, query, estimateID).Scan(&a.AcceptanceID, &a.EstimateID, &a.UserID, &a.SignerName,
+		&a.Consent, &a.IPAddress, &a.UserAgent, &a.AcceptedAt, &a.DocumentHash, &a.DocumentText)
+	if err != nil {
+		return Acceptance{}, err
+	}
+	return a, piiKeys.decryptAll(&a.SignerName, &a.DocumentText)
+}
+
+// **********************************************************************************
+// signedHandler - /estimate/signed?id=1000
+//
+//	Shows the signed copy of an accepted estimate: the exact text that was
+//	signed, who signed it, from where, whether the text still matches its hash,
+//	and the payment schedule agreed to.
+//	Only the signer (estimate in their session) or an admin can view it.
+//
+// **********************************************************************************
+func signedHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("signed.html").Funcs(funcMap).ParseFiles("templates/signed.html",
+		"templates/header.html", "templates/footer.html"))
+
+	userAuth := getUserAuth(r, w)
+	userAuth.Title = "Signed Estimate"
+	data := SignedPageData{}
+	rd := renderData{
+		Page:   &data,
+		Header: &userAuth,
+	}
+
+	estimateID, ok := checkedEstimateID(r) // Checked in requireEstimate
+	if !ok {
+		notFoundHandler(w, r)
+		return
+	}
+
+	var err error
+	if data.Acceptance, err = estimateStore.LoadAcceptance(r.Context(), estimateID); err == sql.ErrNoRows {
+		notFoundHandler(w, r)
+		return
+	} else if err != nil {
+		log.Printf("Failed to load acceptance for estimate %d: %v", estimateID, err)
+		data.Error = "Database error: Signed estimate not available."
+	} else {
+		data.Verified = hashDocument(data.Acceptance.DocumentText) == data.Acceptance.DocumentHash
+		if data.Payments, err = estimateStore.LoadPaymentSchedule(r.Context(), estimateID); err != nil {
+			log.Printf("Failed to load payment schedule for estimate %d: %v", estimateID, err)
+		}
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "signed.html", rd); err != nil {
+		log.Printf("signedHandler execute error: %v", err)
+		panic(err)
+	}
+}
@@ -1,17 +1,16 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 
 	"encoding/gob"
 	"html/template"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 
-	// _ "github.com/mattn/go-sqlite3" // SQLite driver
 	_ "github.com/jackc/pgx/v5/stdlib" // registers "pgx" driver
 )
 
@@ -26,6 +25,7 @@ var funcMap = template.FuncMap{
 // DeckEstimate holds all data for a deck cost estimate.
 type DeckEstimate struct {
 	Desc             string
+	ProductType      string // deck - Sets the expiration window in costs.yaml
 	Length           float64
 	Width            float64
 	Height           float64
@@ -57,19 +57,50 @@ type DeckEstimate struct {
 	ExpirationDate   time.Time
 	SaveDate         time.Time
 	AcceptDate       time.Time
+	Acceptance       Acceptance         // E-signature record, set once accepted
+	Payments         []PaymentMilestone // Payment schedule, set once accepted
+	ChangeOrders     []ChangeOrder      // Change orders after acceptance - loaded from the DB, not kept in session
+	Attachments      []Attachment       // Photos and documents - loaded from the DB, not kept in session
 	Terms            string
+	DocHash          string // Hash of the estimate and terms as rendered for signing
 	Error            string
 }
 
+// Product types - see expiration_days in costs.yaml
+const productDeck = "deck"
+
+// Estimate status in the estimates table
+const (
+	statusSaved    = "saved"
+	statusAccepted = "accepted"
+	statusExpired  = "expired"
+)
+
+// IsExpired reports whether a saved, unaccepted estimate is past its expiration date.
+func (e DeckEstimate) IsExpired() bool {
+	return !e.ExpirationDate.IsZero() && e.AcceptDate.IsZero() && time.Now().After(e.ExpirationDate)
+}
+
+// unsave clears everything tied to a saved estimate, making it a new unsaved draft.
+func (e *DeckEstimate) unsave() {
+	e.SaveDate = time.Time{}
+	e.EstimateID = 0
+	e.ExpirationDate = time.Time{}
+	e.AcceptDate = time.Time{}
+	e.Acceptance = Acceptance{}
+	e.Payments = nil
+	e.ChangeOrders = nil
+	e.Attachments = nil
+	e.Error = ""
+}
+
 // renderEstimate executes the "estimate.html" template with the given estimate, handling errors.
 func renderEstimate(w http.ResponseWriter, r *http.Request, estimate DeckEstimate) {
 	// Terms is not part of session
-	terms, err := os.ReadFile("static/t_and_c.txt")
-	if err != nil {
-		// Fallback if file is missing
-		terms = []byte("Terms and Conditions not available.")
+	estimate.Terms = loadTerms()
+	if !estimate.SaveDate.IsZero() && estimate.AcceptDate.IsZero() && !estimate.IsExpired() {
+		estimate.DocHash = hashDocument(acceptanceDocument(estimate, estimate.Terms))
 	}
-	estimate.Terms = string(terms)
 
 	userAuth := getUserAuth(r, w)
 	userAuth.Title = "Deck Estimate"
@@ -84,56 +115,9 @@ func renderEstimate(w http.ResponseWriter, r *http.Request, estimate DeckEstimat
 }
 
 var tmpl *template.Template // tmpl is the global template for estimate.html, initialized at startup.
-var db *sql.DB              // db is the SQLite database connection
 
 func init() {
-	// This is now in PostgreSQL.  See console.neon.tech
-	/*
-			// Initialize SQLite database
-			var err error
-			dbDir := os.Getenv("DB_DIR")
-			if dbDir == "" {
-				dbDir = "./db" // Default to ./db if DB_DIR not set
-			}
-			// Initialize SQLite database
-			db, err = sql.Open("sqlite3", dbDir+"/estimates.db")
-			if err != nil {
-				log.Fatalf("Failed to open SQLite DB: %v", err)
-			}
-
-			// Create Estimates table
-			createTableSQL := `
-		    CREATE TABLE IF NOT EXISTS estimates (
-		        estimate_id INTEGER PRIMARY KEY,
-				desc TEXT,
-		        length REAL,
-		        width REAL,
-		        height REAL,
-		        material TEXT,
-		        rail_material TEXT,
-		        rail_infill TEXT,
-		        stair_width REAL,
-				stair_rail_count REAL,
-		        has_demo BOOLEAN,
-		        has_fascia BOOLEAN,
-		        total_cost REAL,
-		        first_name TEXT,
-		        last_name TEXT,
-		        address TEXT,
-		        city TEXT,
-		        state TEXT,
-		        zip TEXT,
-		        phone_number TEXT,
-		        email TEXT,
-		        save_date TEXT,
-		        accept_date TEXT,
-		        expiration_date TEXT
-		    );`
-			if _, err := db.Exec(createTableSQL); err != nil {
-				log.Fatalf("Failed to create Estimates table: %v", err)
-			}
-	*/
-
+	// Sessions are JSON documents now (sessiondoc.go) - gob is still needed to read older sessions
 	gob.Register(DeckEstimate{})
 	gob.Register(Customer{})
 	gob.Register(UserAuth{})
@@ -144,22 +128,6 @@ func init() {
 
 // saveEstimate updates the estimate with save details and persists it to the session.
 func saveEstimate(w http.ResponseWriter, r *http.Request, estimate *DeckEstimate, sd *SessionData) {
-	// In your init or main
-	dbURL := os.Getenv("DATABASE_URL") // We'll set this to the Neon string
-
-	if dbURL == "" {
-		log.Printf("DATABASE_URL environment variable is required")
-		renderEstimate(w, r, DeckEstimate{Error: "Database Env - not set up."})
-		return
-	}
-
-	db, err := sql.Open("pgx", dbURL)
-	if err != nil {
-		log.Printf("Unable to connect to database: %v", err)
-		renderEstimate(w, r, DeckEstimate{Error: "Database Connect failed."})
-		return
-	}
-
 	// Before saving, see if the user is authenticated
 	sessionData, err := GetSession(r, w)
 	if err != nil {
@@ -171,46 +139,276 @@ func saveEstimate(w http.ResponseWriter, r *http.Request, estimate *DeckEstimate
 		sessionData.Save(r, w)
 		loginUrl := "/login?rurl=/estimate"
 		http.Redirect(w, r, loginUrl, http.StatusSeeOther)
+		return
 	}
 
+	if estimate.ProductType == "" {
+		estimate.ProductType = productDeck
+	}
 	estimate.SaveDate = time.Now()
-	estimate.ExpirationDate = estimate.SaveDate.Add(30 * 24 * time.Hour) // Today + 30 days
+	estimate.ExpirationDate = estimate.SaveDate.Add(costs.expirationWindow(estimate.ProductType)) // Today + 30 days for decks
+
+	err = estimateStore.InsertEstimate(r.Context(), estimate, sessionData.UserAuth.ID, statusSaved)
+	if err != nil {
+		log.Printf("Failed to save estimate to DB: %v", err)
+		renderEstimate(w, r, DeckEstimate{Error: "Database error: Save Estimate failed."})
+		return
+	}
+	// The draft kept at login is now a saved estimate
+	if err := draftStore.DeleteDraft(r.Context(), sessionData.UserAuth.ID); err != nil {
+		log.Printf("Failed to delete draft for user %d: %v", sessionData.UserAuth.ID, err)
+	}
+
+	sd.Estimate = *estimate
+	err = sd.Save(r, w)
+	if err != nil {
+		log.Printf("Failed to save Session Data in Deck Estimate - saveEstimate()")
+	}
+
+	log.Printf("Estimate saved: ID=%d, SaveDate=%v, ExpirationDate=%v", estimate.EstimateID, estimate.SaveDate, estimate.ExpirationDate)
+}
+
+// InsertEstimate writes a new estimate row, with its customer, and sets estimate.EstimateID.
+func (s *SQLStore) InsertEstimate(ctx context.Context, estimate *DeckEstimate, userID int64, status string) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	customerID, err := customerFor(ctx, tx, userID, estimate.Customer)
+	if err != nil {
+		return err
+	}
 
 	//Prepared Statement - PostgreSQL handle the ID
 	stmt := `INSERT INTO estimates (
     	description, length, width, height, material, rail_material, rail_infill,
     	stair_width, stair_rail_count, has_demo, has_fascia, total_cost,
-    	first_name, last_name, address, city, state, zip, phone_number, email,
-    	save_date, accept_date, expiration_date) 
+    	customer_id, save_date, accept_date, expiration_date, product_type, status, subtotal, sales_tax,
+    	has_stair_fascia, has_stair_tk, user_id,
+    	deck_area, deck_cost, rail_feet, rail_cost, stair_cost, stair_rail_cost,
+    	stair_fascia_cost, stair_toe_kick_cost, fascia_feet, fascia_cost, demo_cost) 
 		VALUES (
 		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12,
-        $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23	
+        $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23,
+        $24, $25, $26, $27, $28, $29, $30, $31, $32, $33, $34
 		) RETURNING estimate_id`
 
 	var newID int64
-	err = db.QueryRow(stmt, estimate.Desc, estimate.Length, estimate.Width, estimate.Height,
+	err = tx.QueryRowContext(ctx, stmt, estimate.Desc, estimate.Length, estimate.Width, estimate.Height,
 		estimate.Material, estimate.RailMaterial, estimate.RailInfill,
 		estimate.StairWidth, estimate.StairRailCount, estimate.HasDemo, estimate.HasFascia, estimate.TotalCost,
-		estimate.Customer.FirstName, estimate.Customer.LastName, estimate.Customer.Address,
-		estimate.Customer.City, estimate.Customer.State, estimate.Customer.Zip,
-		estimate.Customer.PhoneNumber, estimate.Customer.Email,
-		estimate.SaveDate.Format("2006-01-02 15:04:05"),
+		customerID,
+		estimate.SaveDate,
 		nil,
-		estimate.ExpirationDate.Format("2006-01-02 15:04:05")).Scan(&newID)
+		estimate.ExpirationDate,
+		estimate.ProductType, status, estimate.Subtotal, estimate.SalesTax,
+		estimate.HasStairFascia, estimate.HasStairTK, nullID(userID),
+		estimate.DeckArea, estimate.DeckCost, estimate.RailFeet, estimate.RailCost, estimate.StairCost, estimate.StairRailCost,
+		estimate.StairFasciaCost, estimate.StairToeKickCost, estimate.FasciaFeet, estimate.FasciaCost, estimate.DemoCost).Scan(&newID)
 	if err != nil {
-		log.Printf("Failed to save estimate to DB: %v", err)
-		renderEstimate(w, r, DeckEstimate{Error: "Database error: Save Estimate failed."})
-		return
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
 	}
 
 	estimate.EstimateID = int(newID) // Add the new Estimate ID to the Struct
-	sd.Estimate = *estimate
-	err = sd.Save(r, w)
+	return nil
+}
+
+// customerFor returns the customers row with exactly these details, adding one if
+// there is none.  Rows are shared but never changed, so estimates keep their details.
+// The details are encrypted, so candidates are found by email index and compared here.
+func customerFor(ctx context.Context, tx *sqlTx, userID int64, c Customer) (int64, error) {
+	emailIndex := piiKeys.emailIndex(c.Email)
+	rows, err := tx.QueryContext(ctx, `SELECT customer_id, first_name, last_name, address, city, state, zip,
+		phone_number, email FROM customers
+		WHERE COALESCE(user_id, 0) = $1 AND email_index = $2 AND city = $3 AND state = $4 AND zip = $5
+		ORDER BY customer_id`, userID, emailIndex, c.City, c.State, c.Zip)
 	if err != nil {
-		log.Printf("Failed to save Session Data in Deck Estimate - saveEstimate()")
+		return 0, err
+	}
+	for rows.Next() {
+		var id int64
+		var found Customer
+		if err := rows.Scan(&id, &found.FirstName, &found.LastName, &found.Address, &found.City, &found.State,
+			&found.Zip, &found.PhoneNumber, &found.Email); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err := found.decrypt(); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if found == c {
+			rows.Close()
+			return id, nil
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
 	}
 
-	log.Printf("Estimate saved: ID=%d, SaveDate=%v, ExpirationDate=%v", estimate.EstimateID, estimate.SaveDate, estimate.ExpirationDate)
+	enc, err := c.encrypt()
+	if err != nil {
+		return 0, err
+	}
+	var customerID int64
+	err = tx.QueryRowContext(ctx, `INSERT INTO customers
+		(user_id, first_name, last_name, address, city, state, zip, phone_number, email, email_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING customer_id`,
+		nullID(userID), enc.FirstName, enc.LastName, enc.Address, enc.City, enc.State, enc.Zip, enc.PhoneNumber,
+		enc.Email, emailIndex).Scan(&customerID)
+	return customerID, err
+}
+
+// nullID stores a zero ID as NULL for optional foreign keys.
+func nullID(id int64) any {
+	if id <= 0 {
+		return nil
+	}
+	return id
+}
+
+// LoadEstimate reads a saved estimate, with its customer, cost breakdown and - once
+// accepted - payment schedule, and the ID of the user who saved it.  Estimates saved before the breakdown was
+// stored have only the totals - see fillBreakdown.
+func (s *SQLStore) LoadEstimate(ctx context.Context, estimateID int) (DeckEstimate, int64, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	const query = `SELECT e.estimate_id, COALESCE(e.description, ''), e.length, e.width, e.height,
+		COALESCE(e.material, ''), COALESCE(e.rail_material, ''), COALESCE(e.rail_infill, ''),
+		COALESCE(e.stair_width, 0), COALESCE(e.stair_rail_count, 0), COALESCE(e.has_demo, FALSE),
+		COALESCE(e.has_fascia, FALSE), COALESCE(e.has_stair_fascia, FALSE), COALESCE(e.has_stair_tk, FALSE),
+		COALESCE(e.total_cost, 0), COALESCE(e.subtotal, 0), COALESCE(e.sales_tax, 0),
+		COALESCE(e.deck_area, 0), COALESCE(e.deck_cost, 0), COALESCE(e.rail_feet, 0), COALESCE(e.rail_cost, 0),
+		COALESCE(e.stair_cost, 0), COALESCE(e.stair_rail_cost, 0), COALESCE(e.stair_fascia_cost, 0),
+		COALESCE(e.stair_toe_kick_cost, 0), COALESCE(e.fascia_feet, 0), COALESCE(e.fascia_cost, 0),
+		COALESCE(e.demo_cost, 0),
+		COALESCE(c.first_name, ''), COALESCE(c.last_name, ''), COALESCE(c.address, ''), COALESCE(c.city, ''),
+		COALESCE(c.state, ''), COALESCE(c.zip, ''), COALESCE(c.phone_number, ''), COALESCE(c.email, ''),
+		e.save_date, e.accept_date, e.expiration_date, e.product_type, COALESCE(e.user_id, 0)
+		FROM estimates e LEFT JOIN customers c ON c.customer_id = e.customer_id
+		WHERE e.estimate_id = $1`
+
+	var e DeckEstimate
+	var saveDate, acceptDate, expirationDate sql.NullTime
+	var userID int64
+	err := s.db.QueryRowContext(ctx, query, estimateID).Scan(&e.EstimateID, &e.Desc, &e.Length, &e.Width, &e.Height,
+		&e.Material, &e.RailMaterial, &e.RailInfill,
+		&e.StairWidth, &e.StairRailCount, &e.HasDemo,
+		&e.HasFascia, &e.HasStairFascia, &e.HasStairTK,
+		&e.TotalCost, &e.Subtotal, &e.SalesTax,
+		&e.DeckArea, &e.DeckCost, &e.RailFeet, &e.RailCost,
+		&e.StairCost, &e.StairRailCost, &e.StairFasciaCost,
+		&e.StairToeKickCost, &e.FasciaFeet, &e.FasciaCost,
+		&e.DemoCost,
+		&e.Customer.FirstName, &e.Customer.LastName, &e.Customer.Address, &e.Customer.City,
+		&e.Customer.State, &e.Customer.Zip, &e.Customer.PhoneNumber, &e.Customer.Email,
+		&saveDate, &acceptDate, &expirationDate, &e.ProductType, &userID)
+	if err != nil {
+		return DeckEstimate{}, 0, err
+	}
+	if err := e.Customer.decrypt(); err != nil {
+		return DeckEstimate{}, 0, err
+	}
+	if e.DeckArea == 0 {
+		e.DeckArea = e.Length * e.Width
+	}
+	e.SaveDate = saveDate.Time
+	e.AcceptDate = acceptDate.Time
+	e.ExpirationDate = expirationDate.Time
+	if !e.AcceptDate.IsZero() {
+		if e.Payments, err = s.LoadPaymentSchedule(ctx, e.EstimateID); err != nil {
+			return DeckEstimate{}, 0, err
+		}
+	}
+	return e, userID, nil
+}
+
+// EstimateOwner returns the user_id of a saved estimate, 0 if it has none.
+func (s *SQLStore) EstimateOwner(ctx context.Context, estimateID int) (int64, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	var ownerID int64
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(user_id, 0) FROM estimates WHERE estimate_id = $1`,
+		estimateID).Scan(&ownerID)
+	return ownerID, err
+}
+
+// LoadUserEstimates lists the estimates a user saved, newest first.  Only the
+// summary columns are filled in: ID, description, size, material, total and dates.
+func (s *SQLStore) LoadUserEstimates(ctx context.Context, userID int64) ([]DeckEstimate, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT estimate_id, COALESCE(description, ''), length, width, height,
+		COALESCE(material, ''), COALESCE(total_cost, 0), save_date, accept_date, expiration_date
+		FROM estimates WHERE user_id = $1 ORDER BY estimate_id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var estimates []DeckEstimate
+	for rows.Next() {
+		var e DeckEstimate
+		var saveDate, acceptDate, expirationDate sql.NullTime
+		if err := rows.Scan(&e.EstimateID, &e.Desc, &e.Length, &e.Width, &e.Height, &e.Material, &e.TotalCost,
+			&saveDate, &acceptDate, &expirationDate); err != nil {
+			return nil, err
+		}
+		e.DeckArea = e.Length * e.Width
+		e.SaveDate = saveDate.Time
+		e.AcceptDate = acceptDate.Time
+		e.ExpirationDate = expirationDate.Time
+		estimates = append(estimates, e)
+	}
+	return estimates, rows.Err()
+}
+
+// openSavedEstimate loads a saved estimate to show on the estimate page, with its
+// signature if it was accepted.
+func openSavedEstimate(ctx context.Context, estimateID int) (DeckEstimate, error) {
+	e, _, err := estimateStore.LoadEstimate(ctx, estimateID)
+	if err != nil {
+		return DeckEstimate{}, err
+	}
+	e.fillBreakdown(costs)
+	if !e.AcceptDate.IsZero() {
+		if e.Acceptance, err = estimateStore.LoadAcceptance(ctx, estimateID); err != nil && err != sql.ErrNoRows {
+			return DeckEstimate{}, err
+		}
+		e.Acceptance.DocumentText = "" // Keep the session small - the signed copy lives in the DB
+	}
+	return e, nil
+}
+
+// loadEstimatePayments replaces the session's copy of the payment schedule with
+// the one stored when the estimate was accepted.
+func loadEstimatePayments(ctx context.Context, estimate *DeckEstimate) {
+	payments, err := estimateStore.LoadPaymentSchedule(ctx, estimate.EstimateID)
+	if err != nil {
+		log.Printf("Failed to load payment schedule for estimate %d: %v", estimate.EstimateID, err)
+		return
+	}
+	estimate.Payments = payments
+}
+
+// loadEstimateChangeOrders adds the change order history to an accepted estimate.
+func loadEstimateChangeOrders(ctx context.Context, estimate *DeckEstimate) {
+	var err error
+	if estimate.ChangeOrders, err = estimateStore.LoadChangeOrders(ctx, estimate.EstimateID); err != nil {
+		log.Printf("Failed to load change orders for estimate %d: %v", estimate.EstimateID, err)
+	}
 }
 
 // EstimatePageData holds data for the estimate page, including customer info.
@@ -226,6 +424,8 @@ type EstimatePageData struct {
 //
 //   Calculater  - Full details
 //   /calc/deck  - /calc?option=deck - Basic Deck with Finish Level
+//
+//  GET /estimate?id=1000 opens a saved estimate - the link in reminder emails.
 // **********************************************************************************
 
 func estimateHandler(w http.ResponseWriter, r *http.Request) {
@@ -246,7 +446,26 @@ func estimateHandler(w http.ResponseWriter, r *http.Request) {
 
 	// ************* GET  ********************************
 	if r.Method != http.MethodPost {
-		// Load estimate from session for GET
+		// Load estimate from session for GET - or the saved one in ?id= (checked in requireEstimate)
+		if estimateID, ok := checkedEstimateID(r); ok && estimateID != estimate.EstimateID {
+			if estimate, err = openSavedEstimate(r.Context(), estimateID); err != nil {
+				log.Printf("Failed to open estimate %d: %v", estimateID, err)
+				renderEstimate(w, r, DeckEstimate{Error: "Database error: Estimate not available."})
+				return
+			}
+			sd.Customer = estimate.Customer
+			sd.Estimate = estimate
+			if err := sd.Save(r, w); err != nil {
+				log.Printf("Failed to save Session Data in estimateHandler()")
+			}
+		}
+		if !estimate.AcceptDate.IsZero() {
+			loadEstimatePayments(r.Context(), &estimate)
+			loadEstimateChangeOrders(r.Context(), &estimate)
+		}
+		if estimate.EstimateID > 0 {
+			loadEstimateAttachments(r.Context(), &estimate, sd.UserAuth.Role)
+		}
 		renderEstimate(w, r, estimate)
 		return
 	}
@@ -264,10 +483,8 @@ func estimateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// ************* POST - Accept  - After Save ********************************
-	if r.FormValue("accept") == "true" && !estimate.SaveDate.IsZero() {
-		estimate.AcceptDate = time.Now()
-		saveEstimate(w, r, &estimate, sd)
-		log.Printf("Estimate accepted at %v", estimate.AcceptDate)
+	if r.FormValue("accept") == "true" && !estimate.SaveDate.IsZero() && estimate.AcceptDate.IsZero() {
+		acceptEstimate(w, r, &estimate, sd)
 		renderEstimate(w, r, estimate)
 		return
 	}
@@ -303,6 +520,7 @@ func estimateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	estimate.Desc = r.FormValue("desc")
+	estimate.ProductType = productDeck
 	estimate.Length = length
 	estimate.Width = width
 	estimate.Height = height
@@ -397,40 +615,15 @@ func estimateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Unsave - if it was previously saved - It is changed :(
-	estimate.SaveDate = time.Time{}
-	estimate.EstimateID = 0 // Static ID for now
-	estimate.ExpirationDate = time.Time{}
-	estimate.AcceptDate = time.Time{}
-	estimate.Error = ""
-
-	estimate.CalculateDeckCost(costs)
-	if estimate.Error != "" {
-		renderEstimate(w, r, estimate)
-		return
-	}
+	estimate.unsave()
 
-	estimate.CalcStairCost(costs)
+	estimate.Calculate(costs)
 	if estimate.Error != "" {
 		renderEstimate(w, r, estimate)
 		return
 	}
-	estimate.CalculateRailCost(costs)
-	if estimate.Error != "" {
-		renderEstimate(w, r, estimate)
-		return
-	}
-
-	estimate.CalculateStairRailCost(costs)
-	estimate.CalcStairFasciaCost(costs)
-	estimate.CalcStairToeKickCost(costs)
-	estimate.CalculateDemoCost(costs)
-	estimate.CalculateFasciaCost(costs)
-
-	log.Printf("Estimate: %+v", estimate)
 
-	estimate.Subtotal = estimate.DeckCost + estimate.RailCost + estimate.StairCost + estimate.StairRailCost + estimate.DemoCost + estimate.FasciaCost + estimate.StairFasciaCost
-	estimate.SalesTax = CalculateSalesTax(estimate.Subtotal)
-	estimate.TotalCost = estimate.Subtotal + estimate.SalesTax
+	log.Printf("Estimate calculated: %s for %v", formatCost(estimate.TotalCost), estimate.Customer)
 
 	// Save estimate to session
 	sd.Estimate = estimate
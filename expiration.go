@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"time"
+)
+
+// defaultExpirationSweep is how often the scheduler runs unless EXPIRATION_SWEEP_INTERVAL is set.
+const defaultExpirationSweep = time.Hour
+
+// reminderBatchSize caps the emails sent per sweep.
+const reminderBatchSize = 50
+
+// reminderLease is how long a claimed reminder is left to its sweep before
+// another one may send it.
+const reminderLease = 15 * time.Minute
+
+// EstimateReminder is a queued "your estimate expires soon" email.
+type EstimateReminder struct {
+	ReminderID     int64
+	EstimateID     int
+	DaysBefore     int
+	Email          string
+	FirstName      string
+	ExpirationDate time.Time
+}
+
+// startExpirationScheduler runs the expiration sweep in the background until the process exits.
+//
+//	Each sweep:
+//	  1. Marks saved estimates past expiration_date as expired
+//	  2. Queues reminder emails at reminder_days (costs.yaml) before expiry
+//	  3. Sends queued reminders
+//	  4. Deletes contact form messages older than CONTACT_RETENTION
+func startExpirationScheduler() {
+	interval := envDuration("EXPIRATION_SWEEP_INTERVAL", defaultExpirationSweep)
+	log.Printf("Expiration scheduler running every %v, reminders at %v days", interval, costs.ReminderDays)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runExpirationSweep(context.Background(), time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// runExpirationSweep does one pass of expiring estimates and sending reminders.
+func runExpirationSweep(ctx context.Context, now time.Time) {
+	expired, err := estimateStore.ExpireEstimates(ctx, now)
+	if err != nil {
+		log.Printf("Expiration sweep - expire failed: %v", err)
+	} else if expired > 0 {
+		log.Printf("Expiration sweep - %d estimates expired", expired)
+	}
+
+	for _, days := range costs.ReminderDays {
+		if err := estimateStore.QueueReminders(ctx, now, days); err != nil {
+			log.Printf("Expiration sweep - queue %d day reminders failed: %v", days, err)
+		}
+	}
+
+	if err := sendReminders(ctx, now); err != nil {
+		log.Printf("Expiration sweep - send reminders failed: %v", err)
+	}
+
+	purged, err := contactStore.PurgeContactSubmissions(ctx, now.Add(-contactRetention))
+	if err != nil {
+		log.Printf("Expiration sweep - purge contact messages failed: %v", err)
+	} else if purged > 0 {
+		log.Printf("Expiration sweep - %d contact messages deleted", purged)
+	}
+}
+
+// ExpireEstimates marks every saved, unaccepted estimate past its expiration date as expired.
+func (s *SQLStore) ExpireEstimates(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `UPDATE estimates SET status = $1
+		WHERE status = $2 AND accept_date IS NULL AND expiration_date <= $3`,
+		statusExpired, statusSaved, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// QueueReminders adds a reminder for each saved estimate that is within daysBefore of expiring.
+// The unique (estimate_id, days_before) key keeps a reminder from being queued twice.
+func (s *SQLStore) QueueReminders(ctx context.Context, now time.Time, daysBefore int) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	if daysBefore <= 0 {
+		return nil
+	}
+	remindFrom := now.Add(time.Duration(daysBefore) * 24 * time.Hour)
+	_, err := s.db.ExecContext(ctx, `INSERT INTO estimate_reminders (estimate_id, days_before, email, queued_at)
+		SELECT e.estimate_id, $1, c.email, $2 FROM estimates e JOIN customers c ON c.customer_id = e.customer_id
+		WHERE e.status = $3 AND e.accept_date IS NULL AND c.email <> ''
+		  AND e.expiration_date > $2 AND e.expiration_date <= $4
+		ON CONFLICT (estimate_id, days_before) DO NOTHING`,
+		daysBefore, now, statusSaved, remindFrom)
+	return err
+}
+
+// ClaimReminders claims up to limit unsent reminders for estimates that are still
+// open, oldest first, for reminderLease, and returns them to send.  Every instance
+// runs the sweep - the claimed_until check in the update means each reminder is
+// claimed by one of them only, until the claim runs out.  MarkReminderSent records
+// the send.
+func (s *SQLStore) ClaimReminders(ctx context.Context, now time.Time, limit int) ([]EstimateReminder, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `UPDATE estimate_reminders SET claimed_until = $1
+		WHERE sent_at IS NULL AND (claimed_until IS NULL OR claimed_until <= $2) AND reminder_id IN (
+			SELECT r.reminder_id FROM estimate_reminders r JOIN estimates e ON e.estimate_id = r.estimate_id
+			WHERE r.sent_at IS NULL AND (r.claimed_until IS NULL OR r.claimed_until <= $2)
+			  AND e.status = $3 AND e.expiration_date > $2
+			ORDER BY r.queued_at LIMIT $4)
+		RETURNING reminder_id, estimate_id, days_before, email`, now.Add(reminderLease), now, statusSaved, limit)
+	if err != nil {
+		return nil, err
+	}
+	var reminders []EstimateReminder
+	for rows.Next() {
+		var rem EstimateReminder
+		if err := rows.Scan(&rem.ReminderID, &rem.EstimateID, &rem.DaysBefore, &rem.Email); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		reminders = append(reminders, rem)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range reminders {
+		rem := &reminders[i]
+		if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(c.first_name, ''), e.expiration_date
+			FROM estimates e LEFT JOIN customers c ON c.customer_id = e.customer_id
+			WHERE e.estimate_id = $1`, rem.EstimateID).Scan(&rem.FirstName, &rem.ExpirationDate); err != nil {
+			return nil, err
+		}
+		if err := piiKeys.decryptAll(&rem.Email, &rem.FirstName); err != nil {
+			return nil, err
+		}
+	}
+	return reminders, nil
+}
+
+// MarkReminderSent records that a claimed reminder has been emailed.
+func (s *SQLStore) MarkReminderSent(ctx context.Context, reminderID int64, sentAt time.Time) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE estimate_reminders SET sent_at = $1, claimed_until = NULL
+		WHERE reminder_id = $2`, sentAt, reminderID)
+	return err
+}
+
+// ReleaseReminder puts a claimed reminder that could not be sent back in the queue.
+func (s *SQLStore) ReleaseReminder(ctx context.Context, reminderID int64) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE estimate_reminders SET claimed_until = NULL
+		WHERE reminder_id = $1 AND sent_at IS NULL`, reminderID)
+	return err
+}
+
+// sendReminders emails queued reminders for estimates that are still open.
+func sendReminders(ctx context.Context, now time.Time) error {
+	reminders, err := estimateStore.ClaimReminders(ctx, now, reminderBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, rem := range reminders {
+		if err := sendReminderEmail(ctx, rem); err != nil {
+			log.Printf("Reminder %d for estimate %d failed: %v", rem.ReminderID, rem.EstimateID, err)
+			if err := estimateStore.ReleaseReminder(ctx, rem.ReminderID); err != nil {
+				log.Printf("Reminder %d not sent and not released: %v", rem.ReminderID, err)
+			}
+			continue
+		}
+		// If this fails the claim runs out and the reminder is sent again
+		if err := estimateStore.MarkReminderSent(ctx, rem.ReminderID, time.Now()); err != nil {
+			log.Printf("Reminder %d sent but not marked: %v", rem.ReminderID, err)
+		}
+	}
+	return nil
+}
+
+var reminderEmailHTML = template.Must(template.New("reminder").Parse(`
+	<p>Hi {{.FirstName}},</p>
+	<p>Your Columbia Outdoor deck estimate #{{.EstimateID}} expires on
+	<strong>{{.ExpirationDate.Format "January 2, 2006"}}</strong>.</p>
+	<p>To lock in your price, log in and accept your estimate before then.</p>
+	<p><a href="{{.Link}}">View your estimate</a></p>
+	<hr>
+	<small>Columbia Outdoor – Pacific Northwest’s trusted outdoor living platform</small>
+`))
+
+// sendReminderEmail sends one expiry reminder.
+func sendReminderEmail(ctx context.Context, rem EstimateReminder) error {
+	var body bytes.Buffer
+	err := reminderEmailHTML.Execute(&body, struct {
+		EstimateReminder
+		Link string
+	}{rem, fmt.Sprintf("%s/estimate?id=%d", siteURL(), rem.EstimateID)})
+	if err != nil {
+		return err
+	}
+
+	subject := "Your deck estimate expires soon"
+	if rem.DaysBefore == 1 {
+		subject = "Your deck estimate expires tomorrow"
+	}
+	return mailer.Send(ctx, Email{ToName: rem.FirstName, ToAddress: rem.Email, Subject: subject, HTML: body.String()})
+}
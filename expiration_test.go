@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failMail fails every send.
+type failMail struct{}
+
+func (failMail) Send(ctx context.Context, e Email) error { return errors.New("mail is down") }
+
+func TestReminderLease(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	now := time.Now()
+
+	e := DeckEstimate{Customer: Customer{FirstName: "Ann", Email: "ann@example.com"},
+		SaveDate: now, ExpirationDate: now.Add(48 * time.Hour), ProductType: productDeck}
+	if err := st.InsertEstimate(ctx, &e, 0, statusSaved); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.QueueReminders(ctx, now, 3); err != nil {
+		t.Fatal(err)
+	}
+	claim := func(at time.Time) int {
+		t.Helper()
+		rems, err := st.ClaimReminders(ctx, at, reminderBatchSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(rems)
+	}
+
+	// A claim holds the reminder until its lease runs out, sent or not
+	if n := claim(now); n != 1 {
+		t.Fatalf("claimed %d reminders, want 1", n)
+	}
+	if n := claim(now.Add(time.Minute)); n != 0 {
+		t.Errorf("claimed %d reminders during the lease, want 0", n)
+	}
+	if n := claim(now.Add(reminderLease + time.Minute)); n != 1 {
+		t.Errorf("claimed %d reminders after the lease, want 1", n)
+	}
+
+	// A failed send releases it at once
+	later := now.Add(2*reminderLease + time.Minute)
+	prev := mailer
+	mailer = failMail{}
+	t.Cleanup(func() { mailer = prev })
+	if err := sendReminders(ctx, later); err != nil {
+		t.Fatal(err)
+	}
+	var sent int
+	if err := st.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM estimate_reminders WHERE sent_at IS NOT NULL`).Scan(&sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent != 0 {
+		t.Errorf("%d reminders marked sent after the mail failed, want 0", sent)
+	}
+
+	// A good send marks it sent, for good
+	mail := useSentMail(t)
+	if err := sendReminders(ctx, later); err != nil {
+		t.Fatal(err)
+	}
+	if len(mail.emails) != 1 || mail.emails[0].ToAddress != "ann@example.com" {
+		t.Errorf("sent %+v, want one reminder to ann@example.com", mail.emails)
+	}
+	if n := claim(later.Add(reminderLease + time.Minute)); n != 0 {
+		t.Errorf("claimed %d reminders after sending, want 0", n)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// estimateSchemaVersion is the version of the estimate JSON document.
+// Bump it when a field changes meaning or is removed, and keep reading the old versions in importEstimate.
+const estimateSchemaVersion = 1
+
+// maxImportSize caps an uploaded estimate JSON document.
+const maxImportSize = 1 << 20 // 1 MB
+
+// EstimateDocument is the stable JSON form of an estimate.  It is kept separate
+// from DeckEstimate so renaming a Go field never changes the exported schema.
+type EstimateDocument struct {
+	SchemaVersion int                `json:"schema_version"`
+	ExportedAt    time.Time          `json:"exported_at"`
+	EstimateID    int                `json:"estimate_id,omitempty"`
+	ProductType   string             `json:"product_type"`
+	Inputs        EstimateInputsJSON `json:"inputs"`
+	Costs         EstimateCostsJSON  `json:"costs"`
+	Customer      CustomerJSON       `json:"customer"`
+	Dates         EstimateDatesJSON  `json:"dates"`
+}
+
+// EstimateInputsJSON holds the calculator inputs.
+type EstimateInputsJSON struct {
+	Description    string  `json:"description"`
+	Length         float64 `json:"length_ft"`
+	Width          float64 `json:"width_ft"`
+	Height         float64 `json:"height_ft"`
+	Material       string  `json:"material"`
+	RailMaterial   string  `json:"rail_material"`
+	RailInfill     string  `json:"rail_infill"`
+	StairWidth     float64 `json:"stair_width_ft"`
+	StairRailCount float64 `json:"stair_rail_count"`
+	HasDemo        bool    `json:"has_demo"`
+	HasFascia      bool    `json:"has_fascia"`
+	HasStairFascia bool    `json:"has_stair_fascia"`
+	HasStairTK     bool    `json:"has_stair_toe_kick"`
+}
+
+// EstimateCostsJSON holds the computed costs, in dollars.
+type EstimateCostsJSON struct {
+	DeckArea         float64 `json:"deck_area_sqft"`
+	RailFeet         float64 `json:"rail_ft"`
+	FasciaFeet       float64 `json:"fascia_ft"`
+	DemoCost         float64 `json:"demo"`
+	DeckCost         float64 `json:"deck"`
+	RailCost         float64 `json:"rail"`
+	FasciaCost       float64 `json:"fascia"`
+	StairCost        float64 `json:"stairs"`
+	StairRailCost    float64 `json:"stair_rails"`
+	StairFasciaCost  float64 `json:"stair_fascia"`
+	StairToeKickCost float64 `json:"stair_toe_kicks"`
+	Subtotal         float64 `json:"subtotal"`
+	SalesTax         float64 `json:"sales_tax"`
+	TotalCost        float64 `json:"total"`
+}
+
+// CustomerJSON holds the customer contact details.
+type CustomerJSON struct {
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	Address     string `json:"address"`
+	City        string `json:"city"`
+	State       string `json:"state"`
+	Zip         string `json:"zip"`
+	PhoneNumber string `json:"phone_number"`
+	Email       string `json:"email"`
+}
+
+// EstimateDatesJSON holds the estimate dates.  Unset dates are null.
+type EstimateDatesJSON struct {
+	Saved    *time.Time `json:"saved"`
+	Expires  *time.Time `json:"expires"`
+	Accepted *time.Time `json:"accepted"`
+}
+
+// optTime returns nil for a zero time so it exports as null.
+func optTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// fromOptTime is the reverse of optTime.
+func fromOptTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// exportEstimate builds the JSON document for an estimate.
+func exportEstimate(e DeckEstimate) EstimateDocument {
+	return EstimateDocument{
+		SchemaVersion: estimateSchemaVersion,
+		ExportedAt:    time.Now().UTC(),
+		EstimateID:    e.EstimateID,
+		ProductType:   e.ProductType,
+		Inputs: EstimateInputsJSON{
+			Description:    e.Desc,
+			Length:         e.Length,
+			Width:          e.Width,
+			Height:         e.Height,
+			Material:       e.Material,
+			RailMaterial:   e.RailMaterial,
+			RailInfill:     e.RailInfill,
+			StairWidth:     e.StairWidth,
+			StairRailCount: e.StairRailCount,
+			HasDemo:        e.HasDemo,
+			HasFascia:      e.HasFascia,
+			HasStairFascia: e.HasStairFascia,
+			HasStairTK:     e.HasStairTK,
+		},
+		Costs: EstimateCostsJSON{
+			DeckArea:         e.DeckArea,
+			RailFeet:         e.RailFeet,
+			FasciaFeet:       e.FasciaFeet,
+			DemoCost:         e.DemoCost,
+			DeckCost:         e.DeckCost,
+			RailCost:         e.RailCost,
+			FasciaCost:       e.FasciaCost,
+			StairCost:        e.StairCost,
+			StairRailCost:    e.StairRailCost,
+			StairFasciaCost:  e.StairFasciaCost,
+			StairToeKickCost: e.StairToeKickCost,
+			Subtotal:         e.Subtotal,
+			SalesTax:         e.SalesTax,
+			TotalCost:        e.TotalCost,
+		},
+		Customer: CustomerJSON{
+			FirstName:   e.Customer.FirstName,
+			LastName:    e.Customer.LastName,
+			Address:     e.Customer.Address,
+			City:        e.Customer.City,
+			State:       e.Customer.State,
+			Zip:         e.Customer.Zip,
+			PhoneNumber: e.Customer.PhoneNumber,
+			Email:       e.Customer.Email,
+		},
+		Dates: EstimateDatesJSON{
+			Saved:    optTime(e.SaveDate),
+			Expires:  optTime(e.ExpirationDate),
+			Accepted: optTime(e.AcceptDate),
+		},
+	}
+}
+
+// importEstimate reads an estimate JSON document and checks it can be used.
+func importEstimate(r io.Reader) (DeckEstimate, error) {
+	var doc EstimateDocument
+	dec := json.NewDecoder(io.LimitReader(r, maxImportSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return DeckEstimate{}, fmt.Errorf("invalid estimate JSON: %v", err)
+	}
+
+	switch doc.SchemaVersion {
+	case 1:
+	case 0:
+		return DeckEstimate{}, fmt.Errorf("missing schema_version")
+	default:
+		return DeckEstimate{}, fmt.Errorf("unsupported schema_version %d (this server reads up to %d)",
+			doc.SchemaVersion, estimateSchemaVersion)
+	}
+
+	in, c := doc.Inputs, doc.Costs
+	e := DeckEstimate{
+		EstimateID:       doc.EstimateID,
+		ProductType:      doc.ProductType,
+		Desc:             in.Description,
+		Length:           in.Length,
+		Width:            in.Width,
+		Height:           in.Height,
+		Material:         in.Material,
+		RailMaterial:     in.RailMaterial,
+		RailInfill:       in.RailInfill,
+		StairWidth:       in.StairWidth,
+		StairRailCount:   in.StairRailCount,
+		HasDemo:          in.HasDemo,
+		HasFascia:        in.HasFascia,
+		HasStairFascia:   in.HasStairFascia,
+		HasStairTK:       in.HasStairTK,
+		DeckArea:         c.DeckArea,
+		RailFeet:         c.RailFeet,
+		FasciaFeet:       c.FasciaFeet,
+		DemoCost:         c.DemoCost,
+		DeckCost:         c.DeckCost,
+		RailCost:         c.RailCost,
+		FasciaCost:       c.FasciaCost,
+		StairCost:        c.StairCost,
+		StairRailCost:    c.StairRailCost,
+		StairFasciaCost:  c.StairFasciaCost,
+		StairToeKickCost: c.StairToeKickCost,
+		Subtotal:         c.Subtotal,
+		SalesTax:         c.SalesTax,
+		TotalCost:        c.TotalCost,
+		Customer: Customer{
+			FirstName:   doc.Customer.FirstName,
+			LastName:    doc.Customer.LastName,
+			Address:     doc.Customer.Address,
+			PhoneNumber: doc.Customer.PhoneNumber,
+			Email:       doc.Customer.Email,
+			City:        doc.Customer.City,
+			State:       doc.Customer.State,
+			Zip:         doc.Customer.Zip,
+		},
+		SaveDate:       fromOptTime(doc.Dates.Saved),
+		ExpirationDate: fromOptTime(doc.Dates.Expires),
+		AcceptDate:     fromOptTime(doc.Dates.Accepted),
+	}
+	if e.ProductType == "" {
+		e.ProductType = productDeck
+	}
+
+	if e.Length <= 0 || e.Width <= 0 || e.Height < 0 {
+		return DeckEstimate{}, fmt.Errorf("inputs: length and width must be positive, height non-negative")
+	}
+	if _, ok := costs.DeckMaterials[e.Material]; !ok {
+		return DeckEstimate{}, fmt.Errorf("inputs: unknown material %q", e.Material)
+	}
+	if e.TotalCost <= 0 {
+		return DeckEstimate{}, fmt.Errorf("costs: total must be positive")
+	}
+	return e, nil
+}
+
+// **********************************************************************************
+// exportHandler - GET /estimate/export?id=1000
+//
+//	Downloads an estimate as a versioned JSON document.  Without an id the
+//	estimate in the session is exported.  Admins can export any estimate,
+//	homeowners their own.
+//
+// **********************************************************************************
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	estimate := sd.Estimate
+	estimate.Customer = sd.Customer
+	if estimateID, ok := checkedEstimateID(r); ok { // Access checked in requireEstimate
+		estimate, _, err = estimateStore.LoadEstimate(r.Context(), estimateID)
+		if err == sql.ErrNoRows {
+			notFoundHandler(w, r)
+			return
+		} else if err != nil {
+			log.Printf("Export - failed to load estimate %d: %v", estimateID, err)
+			http.Error(w, "Database error: Estimate not available.", http.StatusInternalServerError)
+			return
+		}
+		estimate.fillBreakdown(costs)
+	}
+
+	if estimate.TotalCost <= 0 {
+		http.Error(w, "No estimate to export", http.StatusNotFound)
+		return
+	}
+
+	filename := "estimate.json"
+	if estimate.EstimateID > 0 {
+		filename = fmt.Sprintf("estimate-%d.json", estimate.EstimateID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(exportEstimate(estimate)); err != nil {
+		log.Printf("Export estimate %d failed: %v", estimate.EstimateID, err)
+	}
+}
+
+// **********************************************************************************
+// importHandler - POST /estimate/import
+//
+//	Admin only.  Takes an estimate JSON document, either as the request body or
+//	as the "file" field of a form upload, and saves it as a new estimate with
+//	its original inputs, costs, customer and dates.  Acceptance is not imported -
+//	it needs its own signature record - so imported estimates are saved, or
+//	expired if past their expiration date.
+//
+// **********************************************************************************
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if file, _, err := r.FormFile("file"); err == nil {
+		defer file.Close()
+		body = file
+	}
+
+	estimate, err := importEstimate(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sourceID := estimate.EstimateID
+	estimate.AcceptDate = time.Time{}
+	if estimate.SaveDate.IsZero() {
+		estimate.SaveDate = time.Now()
+	}
+	if estimate.ExpirationDate.IsZero() {
+		estimate.ExpirationDate = estimate.SaveDate.Add(costs.expirationWindow(estimate.ProductType))
+	}
+	status := statusSaved
+	if estimate.IsExpired() {
+		status = statusExpired
+	}
+
+	if err := estimateStore.InsertEstimate(r.Context(), &estimate, 0, status); err != nil {
+		log.Printf("Import estimate failed: %v", err)
+		http.Error(w, "Database error: Import failed.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Estimate imported: source ID=%d, new ID=%d, status=%s", sourceID, estimate.EstimateID, status)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"estimate_id": estimate.EstimateID, "status": status})
+}
+
+// estimateCSVHeader is the header row of the estimates CSV export.
+var estimateCSVHeader = []string{
+	"estimate_id", "status", "product_type", "description",
+	"length_ft", "width_ft", "height_ft", "material", "rail_material", "rail_infill",
+	"stair_width_ft", "stair_rail_count", "has_demo", "has_fascia",
+	"subtotal", "sales_tax", "total",
+	"first_name", "last_name", "city", "state", "zip", "phone_number", "email",
+	"saved", "expires", "accepted",
+}
+
+// csvTime formats an optional timestamp for spreadsheets.
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// csvText guards a text cell typed in by a user.  Spreadsheets run a cell that
+// starts with = + - or @ (or a tab or CR) as a formula, so those get a leading '.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// EstimateListing is one row of the estimate list, with its status.
+type EstimateListing struct {
+	Estimate DeckEstimate
+	Status   string
+}
+
+// ListEstimates lists estimates newest first, all of them or only those with the given status.
+func (s *SQLStore) ListEstimates(ctx context.Context, status string) ([]EstimateListing, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT e.estimate_id, e.status, e.product_type, COALESCE(e.description, ''),
+		e.length, e.width, e.height, COALESCE(e.material, ''), COALESCE(e.rail_material, ''), COALESCE(e.rail_infill, ''),
+		COALESCE(e.stair_width, 0), COALESCE(e.stair_rail_count, 0), COALESCE(e.has_demo, FALSE), COALESCE(e.has_fascia, FALSE),
+		COALESCE(e.subtotal, 0), COALESCE(e.sales_tax, 0), COALESCE(e.total_cost, 0),
+		COALESCE(c.first_name, ''), COALESCE(c.last_name, ''), COALESCE(c.city, ''), COALESCE(c.state, ''),
+		COALESCE(c.zip, ''), COALESCE(c.phone_number, ''), COALESCE(c.email, ''),
+		e.save_date, e.expiration_date, e.accept_date
+		FROM estimates e LEFT JOIN customers c ON c.customer_id = e.customer_id
+		WHERE ($1 = '' OR e.status = $1) ORDER BY e.estimate_id DESC`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var listings []EstimateListing
+	for rows.Next() {
+		var l EstimateListing
+		e := &l.Estimate
+		var saveDate, expirationDate, acceptDate sql.NullTime
+		if err := rows.Scan(&e.EstimateID, &l.Status, &e.ProductType, &e.Desc,
+			&e.Length, &e.Width, &e.Height, &e.Material, &e.RailMaterial, &e.RailInfill,
+			&e.StairWidth, &e.StairRailCount, &e.HasDemo, &e.HasFascia,
+			&e.Subtotal, &e.SalesTax, &e.TotalCost,
+			&e.Customer.FirstName, &e.Customer.LastName, &e.Customer.City, &e.Customer.State,
+			&e.Customer.Zip, &e.Customer.PhoneNumber, &e.Customer.Email,
+			&saveDate, &expirationDate, &acceptDate); err != nil {
+			return nil, err
+		}
+		if err := e.Customer.decrypt(); err != nil {
+			return nil, err
+		}
+		e.SaveDate, e.ExpirationDate, e.AcceptDate = saveDate.Time, expirationDate.Time, acceptDate.Time
+		listings = append(listings, l)
+	}
+	return listings, rows.Err()
+}
+
+// **********************************************************************************
+// estimatesCSVHandler - GET /estimates/export.csv?status=saved
+//
+//	Admin only.  Downloads the estimate list as CSV for the office, newest
+//	first.  Optional status filter: saved, accepted, or expired.
+//
+// **********************************************************************************
+func estimatesCSVHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", statusSaved, statusAccepted, statusExpired:
+	default:
+		http.Error(w, "Unknown status", http.StatusBadRequest)
+		return
+	}
+
+	listings, err := estimateStore.ListEstimates(r.Context(), status)
+	if err != nil {
+		log.Printf("CSV export query failed: %v", err)
+		http.Error(w, "Database error: Export failed.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="estimates-`+time.Now().Format("2006-01-02")+`.csv"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write(estimateCSVHeader)
+
+	num := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+	money := func(f float64) string { return strconv.FormatFloat(f, 'f', 2, 64) }
+	for _, l := range listings {
+		e := l.Estimate
+		cw.Write([]string{
+			strconv.Itoa(e.EstimateID), l.Status, csvText(e.ProductType), csvText(e.Desc),
+			num(e.Length), num(e.Width), num(e.Height), csvText(e.Material), csvText(e.RailMaterial), csvText(e.RailInfill),
+			num(e.StairWidth), num(e.StairRailCount), strconv.FormatBool(e.HasDemo), strconv.FormatBool(e.HasFascia),
+			money(e.Subtotal), money(e.SalesTax), money(e.TotalCost),
+			csvText(e.Customer.FirstName), csvText(e.Customer.LastName), csvText(e.Customer.City), csvText(e.Customer.State),
+			csvText(e.Customer.Zip), csvText(e.Customer.PhoneNumber), csvText(e.Customer.Email),
+			csvTime(e.SaveDate), csvTime(e.ExpirationDate), csvTime(e.AcceptDate),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("CSV export write failed: %v", err)
+	}
+	log.Printf("CSV export: %d estimates (status=%q)", len(listings), status)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// googleUserinfoURL returns the signed in Google user.  The v2 endpoint and the
+// OpenID Connect one (/v1/userinfo) both work - see googleIdentity.
+var googleUserinfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+
+// Why a Google sign-in could not be matched to an account.
+var (
+	errGoogleEmailUnverified = errors.New("google email is not verified")
+	errGoogleAccountConflict = errors.New("email belongs to an account linked to another Google account")
+	errAccountInactive       = errors.New("account is not active")
+	errGoogleEmailAmbiguous  = errors.New("more than one account has the email")
+)
+
+// configureGoogleOAuth applies the environment to googleOauthConfig.  Besides
+// GOOGLE_OAUTH_SECRET, the client, redirect and endpoints can be overridden to
+// sign in against a local fake provider:
+//
+//	GOOGLE_OAUTH_CLIENT_ID, GOOGLE_OAUTH_REDIRECT_URL,
+//	GOOGLE_AUTH_URL, GOOGLE_TOKEN_URL, GOOGLE_USERINFO_URL
+func configureGoogleOAuth() {
+	set := func(dst *string, env string) {
+		if v := os.Getenv(env); v != "" {
+			*dst = v
+		}
+	}
+	set(&googleOauthConfig.ClientSecret, "GOOGLE_OAUTH_SECRET")
+	set(&googleOauthConfig.ClientID, "GOOGLE_OAUTH_CLIENT_ID")
+	set(&googleOauthConfig.RedirectURL, "GOOGLE_OAUTH_REDIRECT_URL")
+	set(&googleOauthConfig.Endpoint.AuthURL, "GOOGLE_AUTH_URL")
+	set(&googleOauthConfig.Endpoint.TokenURL, "GOOGLE_TOKEN_URL")
+	set(&googleUserinfoURL, "GOOGLE_USERINFO_URL")
+	if googleOauthConfig.ClientSecret == "" {
+		log.Printf("Google sign-in is off: GOOGLE_OAUTH_SECRET is not set")
+	}
+}
+
+// GoogleIdentity is the Google account that signed in.
+type GoogleIdentity struct {
+	Subject       string // Google's stable ID for the account
+	Email         string
+	EmailVerified bool
+	Name          string
+	GivenName     string
+	FamilyName    string
+}
+
+// fetchGoogleIdentity asks the userinfo endpoint who the token belongs to.
+func fetchGoogleIdentity(ctx context.Context, token *oauth2.Token) (GoogleIdentity, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, googleUserinfoURL, nil)
+	if err != nil {
+		return GoogleIdentity{}, err
+	}
+	resp, err := googleOauthConfig.Client(ctx, token).Do(req)
+	if err != nil {
+		return GoogleIdentity{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return GoogleIdentity{}, fmt.Errorf("userinfo status %d: %s", resp.StatusCode, body)
+	}
+
+	var info struct {
+		ID            string `json:"id"`  // v2
+		Sub           string `json:"sub"` // OpenID Connect
+		Email         string `json:"email"`
+		VerifiedEmail bool   `json:"verified_email"` // v2
+		EmailVerified bool   `json:"email_verified"` // OpenID Connect
+		Name          string `json:"name"`
+		GivenName     string `json:"given_name"`
+		FamilyName    string `json:"family_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return GoogleIdentity{}, err
+	}
+	g := GoogleIdentity{
+		Subject:       info.Sub,
+		Email:         normalizeEmail(info.Email),
+		EmailVerified: info.VerifiedEmail || info.EmailVerified,
+		Name:          info.Name,
+		GivenName:     info.GivenName,
+		FamilyName:    info.FamilyName,
+	}
+	if g.Subject == "" {
+		g.Subject = info.ID
+	}
+	if g.Subject == "" || g.Email == "" {
+		return GoogleIdentity{}, fmt.Errorf("userinfo has no subject or email")
+	}
+	if g.GivenName == "" && g.FamilyName == "" {
+		g.GivenName = g.Name
+	}
+	return g, nil
+}
+
+// LinkGoogleUser returns the account for a Google sign-in, and whether it was
+// just created:
+//
+//	Account linked to this Google account     that account
+//	Account with the same, verified, email    linked to the Google account
+//	Neither                                   a new homeowner account
+//
+// Emails are unique whatever their case (migration 0019), but if two accounts
+// still differ only in case the sign-in is refused rather than linked to either.
+//
+// Linking an account whose email was never verified clears its password and
+// signs out its sessions - whoever set that password had not proved they own
+// the address.
+func (s *SQLStore) LinkGoogleUser(ctx context.Context, g GoogleIdentity) (UserLogin, bool, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return UserLogin{}, false, err
+	}
+	defer tx.Rollback()
+
+	u, err := scanUserLogin(tx.QueryRowContext(ctx, `SELECT `+userLoginColumns+`
+		FROM user_auth WHERE google_sub = $1`, g.Subject))
+	if err == nil {
+		if !u.IsActive {
+			return UserLogin{}, false, errAccountInactive
+		}
+		return u, false, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return UserLogin{}, false, err
+	}
+
+	// Not linked yet - go by email, which Google must have verified
+	if !g.EmailVerified {
+		return UserLogin{}, false, errGoogleEmailUnverified
+	}
+	now := time.Now()
+	var matches int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM user_auth WHERE LOWER(email) = LOWER($1)`,
+		g.Email).Scan(&matches); err != nil {
+		return UserLogin{}, false, err
+	}
+	if matches > 1 {
+		return UserLogin{}, false, errGoogleEmailAmbiguous
+	}
+	u, err = scanUserLogin(tx.QueryRowContext(ctx, `SELECT `+userLoginColumns+`
+		FROM user_auth WHERE LOWER(email) = LOWER($1)`, g.Email))
+	switch {
+	case err == sql.ErrNoRows:
+		err = tx.QueryRowContext(ctx, `INSERT INTO user_auth (
+			email, password_hash, role, first_name, last_name, is_active, email_verified, google_sub
+			) VALUES ($1, '', $2, $3, $4, TRUE, TRUE, $5) RETURNING id`,
+			g.Email, roleHomeowner, g.GivenName, g.FamilyName, g.Subject).Scan(&u.ID)
+		if err != nil {
+			return UserLogin{}, false, err
+		}
+		u = UserLogin{ID: u.ID, Email: g.Email, Role: roleHomeowner, FirstName: g.GivenName, LastName: g.FamilyName,
+			IsActive: true, EmailVerified: true, GoogleSub: g.Subject}
+		return u, true, tx.Commit()
+
+	case err != nil:
+		return UserLogin{}, false, err
+	case u.GoogleSub != "":
+		return UserLogin{}, false, errGoogleAccountConflict
+	case !u.IsActive:
+		return UserLogin{}, false, errAccountInactive
+	}
+
+	if u.EmailVerified {
+		_, err = tx.ExecContext(ctx, `UPDATE user_auth SET google_sub = $1, updated_at = $2 WHERE id = $3`,
+			g.Subject, now, u.ID)
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE user_auth SET google_sub = $1, email_verified = TRUE, password_hash = '',
+			sessions_valid_from = $2, updated_at = $2 WHERE id = $3`, g.Subject, now, u.ID)
+		u.PasswordHash, u.EmailVerified = "", true
+	}
+	if err != nil {
+		return UserLogin{}, false, err
+	}
+	u.GoogleSub = g.Subject
+	return u, false, tx.Commit()
+}
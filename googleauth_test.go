@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLinkGoogleUser(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+
+	newUser := func(email string, verified bool) int64 {
+		t.Helper()
+		id, err := st.CreateUser(ctx, &NewUser{Email: email, PasswordHash: "hash", Role: roleHomeowner,
+			IsActive: true, EmailVerified: verified})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	verified := newUser("ann@example.com", true)
+	unverified := newUser("bob@example.com", false)
+	linked := newUser("cat@example.com", true)
+	if _, _, err := st.LinkGoogleUser(ctx, GoogleIdentity{Subject: "cat", Email: "cat@example.com", EmailVerified: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two accounts differing only in case, as before migration 0019
+	if _, err := st.db.ExecContext(ctx, `DROP INDEX idx_user_auth_email_lower`); err != nil {
+		t.Fatal(err)
+	}
+	newUser("dan@example.com", true)
+	if _, err := st.db.ExecContext(ctx, `INSERT INTO user_auth (email, password_hash, role, is_active, email_verified)
+		VALUES ('Dan@example.com', 'hash', 'homeowner', TRUE, TRUE)`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		g           GoogleIdentity
+		wantID      int64 // 0 for a new account
+		wantCreated bool
+		wantHash    string
+		wantErr     error
+	}{
+		{"verified email", GoogleIdentity{Subject: "ann", Email: "ann@example.com", EmailVerified: true}, verified, false, "hash", nil},
+		{"linked again", GoogleIdentity{Subject: "ann", Email: "ann@example.com", EmailVerified: true}, verified, false, "hash", nil},
+		{"unverified email clears the password", GoogleIdentity{Subject: "bob", Email: "bob@example.com", EmailVerified: true}, unverified, false, "", nil},
+		{"new account", GoogleIdentity{Subject: "eve", Email: "eve@example.com", EmailVerified: true}, 0, true, "", nil},
+		{"email linked to another Google account", GoogleIdentity{Subject: "other", Email: "cat@example.com", EmailVerified: true}, linked, false, "", errGoogleAccountConflict},
+		{"Google has not verified the email", GoogleIdentity{Subject: "fay", Email: "fay@example.com"}, 0, false, "", errGoogleEmailUnverified},
+		{"two accounts for the email", GoogleIdentity{Subject: "dan", Email: "dan@example.com", EmailVerified: true}, 0, false, "", errGoogleEmailAmbiguous},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, created, err := st.LinkGoogleUser(ctx, tt.g)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if created != tt.wantCreated || (tt.wantID != 0 && u.ID != tt.wantID) {
+				t.Errorf("user %d created %v, want %d created %v", u.ID, created, tt.wantID, tt.wantCreated)
+			}
+			if u.GoogleSub != tt.g.Subject || u.PasswordHash != tt.wantHash || !u.EmailVerified {
+				t.Errorf("user = %+v, want linked to %q with password hash %q", u, tt.g.Subject, tt.wantHash)
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Job assignment status in the job_assignments table
+const (
+	assignRequested = "requested" // Contractor asked for the job, waiting for an admin
+	assignApproved  = "approved"  // Contractor has the job - only one per estimate
+	assignRejected  = "rejected"
+)
+
+// maxServiceAreas caps the ZIP codes a contractor can list.
+const maxServiceAreas = 30
+
+// Job is an accepted estimate as a contractor sees it.  Customer is only filled
+// in once the job is assigned to that contractor.
+type Job struct {
+	EstimateID     int
+	Desc           string
+	City           string
+	Zip            string
+	Length         float64
+	Width          float64
+	Height         float64
+	Material       string
+	RailMaterial   string
+	StairWidth     float64
+	TotalCost      float64
+	AcceptDate     time.Time
+	MyStatus       string // This contractor's assignment status, if any
+	Customer       Customer
+	Attachments    []Attachment
+	AssignmentID   int64
+	ContractorID   int64
+	ContractorName string // Admin list
+	RequestedAt    time.Time
+}
+
+// JobsPageData holds data for the contractor job board.
+type JobsPageData struct {
+	IsAdmin      bool
+	ServiceAreas string // Comma separated ZIP codes or prefixes
+	Jobs         []Job  // Open jobs in the contractor's service area
+	MyJobs       []Job  // Jobs assigned to the contractor
+	Requests     []Job  // Admin - requests waiting for approval
+	Assigned     []Job  // Admin - recent assignments
+	Message      string
+	Error        string
+}
+
+// parseServiceAreas cleans a comma or space separated list of ZIP codes or ZIP prefixes (3 to 5 digits).
+func parseServiceAreas(s string) ([]string, error) {
+	var areas []string
+	seen := map[string]bool{}
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t' }) {
+		if len(f) < 3 || len(f) > 5 || strings.Trim(f, "0123456789") != "" {
+			return nil, fmt.Errorf("%q is not a ZIP code or ZIP prefix", f)
+		}
+		if !seen[f] {
+			seen[f] = true
+			areas = append(areas, f)
+		}
+	}
+	if len(areas) > maxServiceAreas {
+		return nil, fmt.Errorf("list at most %d ZIP codes", maxServiceAreas)
+	}
+	return areas, nil
+}
+
+// LoadServiceAreas returns a contractor's ZIP codes and prefixes.
+func (s *SQLStore) LoadServiceAreas(ctx context.Context, contractorID int64) ([]string, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT zip_prefix FROM contractor_service_areas WHERE user_id = $1 ORDER BY zip_prefix`,
+		contractorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var areas []string
+	for rows.Next() {
+		var a string
+		if err := rows.Scan(&a); err != nil {
+			return nil, err
+		}
+		areas = append(areas, a)
+	}
+	return areas, rows.Err()
+}
+
+// SaveServiceAreas replaces a contractor's service area.
+func (s *SQLStore) SaveServiceAreas(ctx context.Context, contractorID int64, areas []string) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM contractor_service_areas WHERE user_id = $1`, contractorID); err != nil {
+		return err
+	}
+	for _, a := range areas {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO contractor_service_areas (user_id, zip_prefix) VALUES ($1, $2)`,
+			contractorID, a); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// jobColumns are the estimate columns a contractor can see before assignment - no customer
+// details beyond the city and ZIP.  Queries join the customer as c.
+const jobColumns = `e.estimate_id, COALESCE(e.description, ''), COALESCE(c.city, ''), COALESCE(c.zip, ''),
+	e.length, e.width, e.height, COALESCE(e.material, ''), COALESCE(e.rail_material, ''),
+	COALESCE(e.stair_width, 0), COALESCE(e.total_cost, 0), e.accept_date`
+
+func scanJob(row interface{ Scan(...any) error }, extra ...any) (Job, error) {
+	var j Job
+	dest := append([]any{&j.EstimateID, &j.Desc, &j.City, &j.Zip, &j.Length, &j.Width, &j.Height,
+		&j.Material, &j.RailMaterial, &j.StairWidth, &j.TotalCost, &j.AcceptDate}, extra...)
+	err := row.Scan(dest...)
+	return j, err
+}
+
+// LoadOpenJobs lists accepted, unassigned estimates in the contractor's service area.
+func (s *SQLStore) LoadOpenJobs(ctx context.Context, contractorID int64) ([]Job, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+jobColumns+`, COALESCE(a.status, '')
+		FROM estimates e
+		LEFT JOIN customers c ON c.customer_id = e.customer_id
+		LEFT JOIN job_assignments a ON a.estimate_id = e.estimate_id AND a.contractor_id = $1
+		WHERE e.accept_date IS NOT NULL
+		  AND NOT EXISTS (SELECT 1 FROM job_assignments x WHERE x.estimate_id = e.estimate_id AND x.status = $2)
+		  AND EXISTS (SELECT 1 FROM contractor_service_areas s
+		              WHERE s.user_id = $1 AND COALESCE(c.zip, '') LIKE s.zip_prefix || '%')
+		ORDER BY e.accept_date DESC`, contractorID, assignApproved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		var status string
+		j, err := scanJob(rows, &status)
+		if err != nil {
+			return nil, err
+		}
+		j.MyStatus = status
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// LoadAssignedJobs lists jobs assigned to a contractor, with the customer details.
+func (s *SQLStore) LoadAssignedJobs(ctx context.Context, contractorID int64) ([]Job, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+jobColumns+`, a.assignment_id,
+			COALESCE(c.first_name, ''), COALESCE(c.last_name, ''), COALESCE(c.address, ''), COALESCE(c.state, ''),
+			COALESCE(c.phone_number, ''), COALESCE(c.email, '')
+		FROM job_assignments a JOIN estimates e ON e.estimate_id = a.estimate_id
+		LEFT JOIN customers c ON c.customer_id = e.customer_id
+		WHERE a.contractor_id = $1 AND a.status = $2
+		ORDER BY a.decided_at DESC`, contractorID, assignApproved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		var assignmentID int64
+		var c Customer
+		j, err := scanJob(rows, &assignmentID, &c.FirstName, &c.LastName, &c.Address, &c.State, &c.PhoneNumber, &c.Email)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.decrypt(); err != nil {
+			return nil, err
+		}
+		c.City, c.Zip = j.City, j.Zip
+		j.AssignmentID, j.Customer, j.MyStatus = assignmentID, c, assignApproved
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// LoadAssignments lists assignments with the given status for the admin, newest first.
+func (s *SQLStore) LoadAssignments(ctx context.Context, status string, limit int) ([]Job, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+jobColumns+`, a.assignment_id, a.contractor_id,
+			TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '') || ' <' || u.email || '>'),
+			a.requested_at
+		FROM job_assignments a
+		JOIN estimates e ON e.estimate_id = a.estimate_id
+		LEFT JOIN customers c ON c.customer_id = e.customer_id
+		JOIN user_auth u ON u.id = a.contractor_id
+		WHERE a.status = $1
+		ORDER BY a.requested_at DESC LIMIT $2`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		var assignmentID, contractorID int64
+		var name string
+		var requestedAt time.Time
+		j, err := scanJob(rows, &assignmentID, &contractorID, &name, &requestedAt)
+		if err != nil {
+			return nil, err
+		}
+		j.AssignmentID, j.ContractorID, j.ContractorName, j.RequestedAt = assignmentID, contractorID, name, requestedAt
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// RequestJob records a contractor's request for an open job in their service area.
+// A rejected request can be made again.
+func (s *SQLStore) RequestJob(ctx context.Context, estimateID int, contractorID int64) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `INSERT INTO job_assignments (estimate_id, contractor_id, status, requested_at)
+		SELECT e.estimate_id, $2, $3, $4 FROM estimates e
+		LEFT JOIN customers c ON c.customer_id = e.customer_id
+		WHERE e.estimate_id = $1 AND e.accept_date IS NOT NULL
+		  AND NOT EXISTS (SELECT 1 FROM job_assignments x WHERE x.estimate_id = e.estimate_id AND x.status = $5)
+		  AND EXISTS (SELECT 1 FROM contractor_service_areas s
+		              WHERE s.user_id = $2 AND COALESCE(c.zip, '') LIKE s.zip_prefix || '%')
+		ON CONFLICT (estimate_id, contractor_id) DO UPDATE
+		SET status = EXCLUDED.status, requested_at = EXCLUDED.requested_at, decided_by = NULL, decided_at = NULL
+		WHERE job_assignments.status = $6`,
+		estimateID, contractorID, assignRequested, time.Now(), assignApproved, assignRejected)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("job %d is not open to contractor %d", estimateID, contractorID)
+	}
+	return nil
+}
+
+// ApproveAssignment gives the job to the contractor and turns down the other requests for it.
+func (s *SQLStore) ApproveAssignment(ctx context.Context, assignmentID int64, adminID int64) (int, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var estimateID int
+	err = tx.QueryRowContext(ctx, `UPDATE job_assignments SET status = $1, decided_by = $2, decided_at = $3
+		WHERE assignment_id = $4 AND status = $5
+		  AND NOT EXISTS (SELECT 1 FROM job_assignments x WHERE x.estimate_id = job_assignments.estimate_id AND x.status = $1)
+		RETURNING estimate_id`,
+		assignApproved, adminID, now, assignmentID, assignRequested).Scan(&estimateID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE job_assignments SET status = $1, decided_by = $2, decided_at = $3
+		WHERE estimate_id = $4 AND status = $5`,
+		assignRejected, adminID, now, estimateID, assignRequested); err != nil {
+		return 0, err
+	}
+	return estimateID, tx.Commit()
+}
+
+// RejectAssignment turns down a request.
+func (s *SQLStore) RejectAssignment(ctx context.Context, assignmentID int64, adminID int64) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `UPDATE job_assignments SET status = $1, decided_by = $2, decided_at = $3
+		WHERE assignment_id = $4 AND status = $5`,
+		assignRejected, adminID, time.Now(), assignmentID, assignRequested)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// IsAssignedContractor reports whether the estimate's job is assigned to the contractor.
+func (s *SQLStore) IsAssignedContractor(ctx context.Context, estimateID int, contractorID int64) (bool, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM job_assignments
+		WHERE estimate_id = $1 AND contractor_id = $2 AND status = $3`,
+		estimateID, contractorID, assignApproved).Scan(&n)
+	return n > 0, err
+}
+
+// **********************************************************************************
+// jobsHandler - /jobs
+//
+//	Contractors:
+//	  GET  - Open jobs in their service area (no customer details) and their assigned jobs.
+//	  POST - op=request id=N     Ask for a job.  An admin approves it.
+//	         op=areas   areas=.. Set the service area ZIP codes.
+//	Admins:
+//	  GET  - Requests waiting for approval and recent assignments.
+//	  POST - op=approve|reject assignment=N
+//
+// **********************************************************************************
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("jobs.html").Funcs(funcMap).ParseFiles("templates/jobs.html",
+		"templates/header.html", "templates/footer.html"))
+
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	isAdmin := sd.UserAuth.Role == roleAdmin // Otherwise a contractor - see main.go
+
+	userAuth := getUserAuth(r, w)
+	userAuth.Title = "Job Board"
+	data := JobsPageData{IsAdmin: isAdmin}
+	rd := renderData{
+		Page:   &data,
+		Header: &userAuth,
+	}
+	render := func() {
+		if err := tmpl.ExecuteTemplate(w, "jobs.html", rd); err != nil {
+			log.Printf("jobsHandler execute error: %v", err)
+			panic(err)
+		}
+	}
+
+	userID := sd.UserAuth.ID
+	if r.Method == http.MethodPost {
+		switch op := r.FormValue("op"); op {
+		case "request":
+			estimateID, _ := strconv.Atoi(r.FormValue("id"))
+			if isAdmin || estimateID <= 0 {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+			if err := jobStore.RequestJob(r.Context(), estimateID, userID); err != nil {
+				log.Printf("Job request failed: %v", err)
+				data.Error = "This job is no longer available."
+				break
+			}
+			log.Printf("Contractor %d requested job %d", userID, estimateID)
+			data.Message = fmt.Sprintf("Requested job #%d.  You will see the customer details once it is approved.", estimateID)
+
+		case "areas":
+			if isAdmin {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+			areas, err := parseServiceAreas(r.FormValue("areas"))
+			if err != nil {
+				data.Error = "Service area not saved: " + err.Error()
+				break
+			}
+			if err := jobStore.SaveServiceAreas(r.Context(), userID, areas); err != nil {
+				log.Printf("Failed to save service area for contractor %d: %v", userID, err)
+				data.Error = "Database error: Service area not saved."
+				break
+			}
+			data.Message = "Service area saved."
+
+		case "approve", "reject":
+			if !isAdmin {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			assignmentID, _ := strconv.ParseInt(r.FormValue("assignment"), 10, 64)
+			if op == "approve" {
+				estimateID, err := jobStore.ApproveAssignment(r.Context(), assignmentID, userID)
+				if err != nil {
+					log.Printf("Approve assignment %d failed: %v", assignmentID, err)
+					data.Error = "Assignment not approved - the job may already be assigned."
+					break
+				}
+				log.Printf("Assignment %d approved: job %d", assignmentID, estimateID)
+				data.Message = fmt.Sprintf("Job #%d assigned.", estimateID)
+			} else {
+				if err := jobStore.RejectAssignment(r.Context(), assignmentID, userID); err != nil {
+					log.Printf("Reject assignment %d failed: %v", assignmentID, err)
+					data.Error = "Request not found."
+					break
+				}
+				data.Message = "Request rejected."
+			}
+		}
+	}
+
+	if isAdmin {
+		if data.Requests, err = jobStore.LoadAssignments(r.Context(), assignRequested, 100); err == nil {
+			data.Assigned, err = jobStore.LoadAssignments(r.Context(), assignApproved, 25)
+		}
+	} else {
+		var areas []string
+		if areas, err = jobStore.LoadServiceAreas(r.Context(), userID); err == nil {
+			data.ServiceAreas = strings.Join(areas, ", ")
+			if data.Jobs, err = jobStore.LoadOpenJobs(r.Context(), userID); err == nil {
+				data.MyJobs, err = jobStore.LoadAssignedJobs(r.Context(), userID)
+			}
+		}
+		for i := range data.MyJobs {
+			if data.MyJobs[i].Attachments, err = attachmentStore.LoadAttachments(r.Context(), data.MyJobs[i].EstimateID, sd.UserAuth.Role); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Job board for user %d: %v", userID, err)
+		data.Error = "Database error: Jobs not available."
+	}
+	render()
+}
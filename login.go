@@ -1,16 +1,18 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
-	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/bcrypt" // For password hashing
 	"golang.org/x/oauth2"
@@ -40,6 +42,8 @@ type UserAuth struct {
 	Name            string
 	AuthType        string // Google or password
 	Role            string // homeowner, admin, or contractor
+	EmailVerified   bool
+	LoginAt         time.Time // Sessions from before a password reset are signed out
 	Message         string
 	Title           string // Header this is the Title page shown in <title> ... </title>
 	MetaDesc        string // this is the Meta Description in Header
@@ -63,15 +67,16 @@ func getUserAuth(r *http.Request, w http.ResponseWriter) UserAuth {
 
 // /auth/google — starts the login
 func googleLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if googleOauthConfig.ClientSecret == "" {
+		http.Error(w, "Env Failed:  Missing Oauth Secret.", http.StatusInternalServerError)
+		return
+	}
+
 	state := randToken() // simple anti-CSRF
 	session, _ := store.Get(r, "session")
 	session.Values["oauth_state"] = state
 	session.Save(r, w)
 
-	googleOauthConfig.ClientSecret = os.Getenv("GOOGLE_OAUTH_SECRET")
-	if googleOauthConfig.ClientSecret == "" {
-		http.Error(w, "Env Failed:  Missing Oauth Secret.", http.StatusInternalServerError)
-	}
 	url := googleOauthConfig.AuthCodeURL(state)
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
@@ -79,44 +84,34 @@ func googleLoginHandler(w http.ResponseWriter, r *http.Request) {
 // /auth/google/callback — Google redirects here
 func googleCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := store.Get(r, "session")
-	savedState := session.Values["oauth_state"]
+	savedState, _ := session.Values["oauth_state"].(string)
 
-	if r.URL.Query().Get("state") != savedState.(string) {
+	if savedState == "" || r.URL.Query().Get("state") != savedState {
 		http.Error(w, "Invalid state", http.StatusBadRequest)
 		return
 	}
+	delete(session.Values, "oauth_state") // One use
+	session.Save(r, w)
 
-	googleOauthConfig.ClientSecret = os.Getenv("GOOGLE_OAUTH_SECRET")
 	if googleOauthConfig.ClientSecret == "" {
 		http.Error(w, "Env Failed:  Missing Oauth Secret.", http.StatusInternalServerError)
+		return
 	}
 	token, err := googleOauthConfig.Exchange(r.Context(), r.URL.Query().Get("code"))
 	if err != nil {
+		log.Printf("Google token exchange failed: %v", err)
 		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
 		return
 	}
 
 	// Get user info
-	resp, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + token.AccessToken)
-	if err != nil || resp.StatusCode != 200 {
+	identity, err := fetchGoogleIdentity(r.Context(), token)
+	if err != nil {
+		log.Printf("Google userinfo failed: %v", err)
 		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
 		return
 	}
-	defer resp.Body.Close()
-
-	var userInfo struct {
-		Email string `json:"email"`
-		Name  string `json:"name"`
-		ID    string `json:"id"`
-	}
-	json.NewDecoder(resp.Body).Decode(&userInfo)
 
-	/* Log them in (same as your normal login)
-	IsAuthenticated bool
-	Email       string
-	Name        string
-	Message         string
-	*/
 	sessionData, err := GetSession(r, w)
 	if err != nil {
 		log.Printf("GetSession Failed!!")
@@ -124,21 +119,57 @@ func googleCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Find, link or create the account
+	u, created, err := userStore.LinkGoogleUser(r.Context(), identity)
+	if err != nil {
+		log.Printf("Google sign-in for %s failed: %v", redactEmail(identity.Email), err)
+		switch err {
+		case errGoogleEmailUnverified:
+			sessionData.UserAuth.Message = "Your Google account's email is not verified.  Please verify it with Google, or sign up with a password."
+		case errGoogleAccountConflict:
+			sessionData.UserAuth.Message = "That email is already linked to a different Google account."
+		case errGoogleEmailAmbiguous:
+			sessionData.UserAuth.Message = "We could not tell which account that email belongs to.  Please contact support@columbiaoutdoor.com."
+		default:
+			sessionData.UserAuth.Message = "Google sign-in failed.  Try again"
+		}
+		sessionData.Save(r, w)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if created {
+		log.Printf("User %d created from Google sign-in", u.ID)
+	}
+	forgetSessionsValidFrom(u.ID) // Linking may have signed out the account's other sessions
+	if err := userStore.RecordLogin(r.Context(), u.ID, time.Now()); err != nil {
+		log.Printf("Recording login for user %d failed: %v", u.ID, err)
+	}
+
 	// Get the original Rurl
 	rurl := sessionData.UserAuth.Rurl
 
+	name := u.Name()
+	if name == "" {
+		name = identity.Name
+	}
+	forgetPreviousUser(sessionData, u.ID)
 	sessionData.UserAuth = UserAuth{
+		ID:              u.ID,
 		IsAuthenticated: true,
-		//TODO :       userInfo.ID,
-		Email:   userInfo.Email,
-		Name:    userInfo.Name,
-		Message: "Welcome back, " + userInfo.Name,
+		Email:           u.Email,
+		EmailVerified:   u.EmailVerified,
+		AuthType:        "google",
+		Role:            u.Role,
+		LoginAt:         time.Now(),
+		Name:            name,
+		Message:         "Welcome back, " + name,
 	}
+	if created {
+		sessionData.UserAuth.Message = "Welcome to Columbia Outdoor!"
+	}
+	mergeOnLogin(r, sessionData)
 
-	sessionData.Save(r, w)
-
-	delete(session.Values, "oauth_state")
-	session.Save(r, w)
+	sessionData.Regenerate(r, w) // New session ID for the logged in user
 
 	// setFlash(w, r, "Welcome back, "+userInfo.Name+"!")
 	if rurl == "" {
@@ -185,12 +216,12 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 
 		// Parmas from form
 		name := strings.TrimSpace(r.FormValue("name"))
-		email := strings.TrimSpace(r.FormValue("email"))
+		email := normalizeEmail(r.FormValue("email"))
 		pass1 := r.FormValue("password")
 		pass2 := r.FormValue("password2")
 
 		// Basic validation
-		if name == "" || email == "" || pass1 == "" || pass1 != pass2 || len(pass1) < 8 {
+		if name == "" || email == "" || !passwordOK(pass1, pass2) {
 			sessionData.UserAuth.Message = "Please fill all fields correctly and ensure passwords match (8+ chars)"
 			sessionData.Save(r, w)
 			log.Printf("%s", sessionData.UserAuth.Message)
@@ -199,7 +230,7 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Create user (your existing function)
-		uid, err := createUser(name, email, pass1)
+		uid, err := createUser(r.Context(), name, email, pass1)
 		if err != nil {
 			sessionData.UserAuth.Message = "Create user DB failure."
 			sessionData.Save(r, w)
@@ -211,15 +242,21 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("User added to DB with UID: %d", uid)
 
 		// Log them in automatically
+		forgetPreviousUser(sessionData, uid)
 		sessionData.UserAuth.ID = uid
 		sessionData.UserAuth.AuthType = "password"
-		sessionData.UserAuth.Role = "homeowner"
+		sessionData.UserAuth.Role = roleHomeowner
 		sessionData.UserAuth.Email = email
 		sessionData.UserAuth.IsAuthenticated = true
-		sessionData.UserAuth.Message = "Welcome to Columbia Outdoor!"
+		sessionData.UserAuth.LoginAt = time.Now()
+		sessionData.UserAuth.Message = "Welcome to Columbia Outdoor!  Check your email for a link to verify your address."
 		sessionData.UserAuth.Name = name
+		if err := sendVerificationEmail(r.Context(), uid, name, email); err != nil {
+			log.Printf("Verification email for user %d failed: %v", uid, err) // They can resend it from My Account
+		}
+		mergeOnLogin(r, sessionData)
 
-		if err := sessionData.Save(r, w); err != nil {
+		if err := sessionData.Regenerate(r, w); err != nil {
 			log.Printf("LoginHandler: Session save Error: %v", err)
 		}
 
@@ -227,22 +264,21 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func createUser(name string, email string, pass string) (int64, error) {
-	log.Printf("User %s Signed up with email %s.", name, email)
+// NewUser is an account to create in user_auth.
+type NewUser struct {
+	Email         string
+	PasswordHash  string
+	Role          string
+	FirstName     string
+	LastName      string
+	Phone         string
+	IsActive      bool
+	EmailVerified bool
+}
 
-	// In your init or main
-	dbURL := os.Getenv("DATABASE_URL") // We'll set this to the Neon string
+func createUser(ctx context.Context, name string, email string, pass string) (int64, error) {
+	log.Printf("User %s signed up with email %s.", redactName(name), redactEmail(email))
 
-	if dbURL == "" {
-		log.Printf("DATABASE_URL environment variable is required")
-		return 0, nil
-	}
-	var err error
-	db, err = sql.Open("pgx", dbURL)
-	if err != nil {
-		log.Printf("Unable to connect to database: %v", err)
-		return 0, err
-	}
 	// Hash the plain password before storing (do this in your handler before calling)
 	passwordHash, err := hashPassword(pass)
 	if err != nil {
@@ -250,12 +286,27 @@ func createUser(name string, email string, pass string) (int64, error) {
 		return 0, err
 	}
 
-	var userID int64
-	role := "homeowner" // Set to 'homeowner for now
-	lastName := ""
-	phone := ""
-	isActive := true
-	isVerified := false
+	userID, err := userStore.CreateUser(ctx, &NewUser{
+		Email:         email,
+		PasswordHash:  passwordHash,
+		Role:          roleHomeowner, // Set to 'homeowner for now
+		FirstName:     name,
+		IsActive:      true,
+		EmailVerified: false,
+	})
+	if err != nil {
+		log.Printf("Failed to create user: %v", err)
+		return 0, err
+	}
+
+	log.Printf("Successfully created user ID: %d (email: %s)", userID, redactEmail(email))
+	return userID, nil
+}
+
+// CreateUser inserts a user_auth row and returns its ID.
+func (s *SQLStore) CreateUser(ctx context.Context, u *NewUser) (int64, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
 
 	/* Send the query to the DB - INSERT */
 	const stmt = `
@@ -267,24 +318,85 @@ func createUser(name string, email string, pass string) (int64, error) {
         $1, $2, $3, $4, $5, $6, $7, $8
     ) RETURNING id`
 
-	err = db.QueryRow(stmt,
-		email,
-		passwordHash,
-		role,
-		name,
-		lastName,
-		phone,
-		isActive,
-		isVerified,
+	var userID int64
+	err := s.db.QueryRowContext(ctx, stmt,
+		normalizeEmail(u.Email),
+		u.PasswordHash,
+		u.Role,
+		u.FirstName,
+		u.LastName,
+		u.Phone,
+		u.IsActive,
+		u.EmailVerified,
 	).Scan(&userID)
+	return userID, err
+}
 
-	if err != nil {
-		log.Printf("Failed to create user: %v", err)
-		return 0, err
-	}
+// UserLogin is what login needs from a user_auth row.
+type UserLogin struct {
+	ID            int64
+	Email         string
+	PasswordHash  string
+	Role          string
+	FirstName     string
+	LastName      string
+	IsActive      bool
+	EmailVerified bool
+	GoogleSub     string // The linked Google account, if any
+}
 
-	log.Printf("Successfully created user ID: %d (email: %s)", userID, email)
-	return userID, nil
+// Name is the user's full name, as shown in the header.
+func (u UserLogin) Name() string {
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+// LoadUser reads the user with this ID, or sql.ErrNoRows.
+func (s *SQLStore) LoadUser(ctx context.Context, userID int64) (UserLogin, error) {
+	return s.loadUser(ctx, `id = $1`, userID)
+}
+
+// LoadUserByEmail reads the user with this email, ignoring case, or sql.ErrNoRows.
+func (s *SQLStore) LoadUserByEmail(ctx context.Context, email string) (UserLogin, error) {
+	return s.loadUser(ctx, `LOWER(email) = LOWER($1)`, email)
+}
+
+func (s *SQLStore) loadUser(ctx context.Context, where string, arg any) (UserLogin, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	return scanUserLogin(s.db.QueryRowContext(ctx, `SELECT `+userLoginColumns+`
+		FROM user_auth WHERE `+where+` ORDER BY id LIMIT 1`, arg))
+}
+
+// userLoginColumns are the user_auth columns scanUserLogin reads.
+const userLoginColumns = `id, email, password_hash, role, COALESCE(first_name, ''), COALESCE(last_name, ''),
+	COALESCE(is_active, FALSE), COALESCE(email_verified, FALSE), COALESCE(google_sub, '')`
+
+func scanUserLogin(row *sql.Row) (UserLogin, error) {
+	var u UserLogin
+	err := row.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Role, &u.FirstName, &u.LastName,
+		&u.IsActive, &u.EmailVerified, &u.GoogleSub)
+	return u, err
+}
+
+// RecordLogin sets the user's last_login_at.
+func (s *SQLStore) RecordLogin(ctx context.Context, userID int64, at time.Time) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE user_auth SET last_login_at = $1 WHERE id = $2`, at, userID)
+	return err
+}
+
+// normalizeEmail is how emails are stored and looked up - trimmed and in lower
+// case, so an address is one account however it is typed.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// passwordOK checks a new password and its confirmation - they match and are 8+ chars.
+func passwordOK(pass1 string, pass2 string) bool {
+	return pass1 == pass2 && len(pass1) >= 8
 }
 
 // Helper: Hash password securely with bcrypt
@@ -304,17 +416,31 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	saveSession := sessionData.Save
 	if r.Method == "POST" {
-		if err := authN(r); err != nil {
+		if u, err := authN(r); err != nil {
 			sessionData.UserAuth.Message = "Login failed.  Try again"
 		} else {
-			sessionData.UserAuth.Email = r.FormValue("email")
+			forgetPreviousUser(sessionData, u.ID)
+			sessionData.UserAuth.ID = u.ID
+			sessionData.UserAuth.Email = u.Email
+			sessionData.UserAuth.Name = u.Name()
+			sessionData.UserAuth.Role = u.Role
+			sessionData.UserAuth.AuthType = "password"
+			sessionData.UserAuth.EmailVerified = u.EmailVerified
 			sessionData.UserAuth.IsAuthenticated = true
-			sessionData.UserAuth.Message = fmt.Sprintf("Welcome %s", sessionData.UserAuth.Email)
+			sessionData.UserAuth.LoginAt = time.Now()
+			welcome := u.Name()
+			if welcome == "" {
+				welcome = u.Email
+			}
+			sessionData.UserAuth.Message = fmt.Sprintf("Welcome %s", welcome)
+			mergeOnLogin(r, sessionData)
+			saveSession = sessionData.Regenerate // New session ID for the logged in user
 		}
 	}
 
-	if err := sessionData.Save(r, w); err != nil {
+	if err := saveSession(r, w); err != nil {
 		log.Printf("LoginHandler: Session save Error: %v", err)
 	}
 
@@ -340,9 +466,9 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	/* Set the rurl after a successful login */
+	/* Set the rurl after a successful login - a path on this site only */
 	sessionData.UserAuth.Title = "Login"
-	if rurl == "" {
+	if !strings.HasPrefix(rurl, "/") || strings.HasPrefix(rurl, "//") || strings.HasPrefix(rurl, "/\\") {
 		rurl = "/"
 	}
 	sessionData.UserAuth.Rurl = rurl
@@ -353,18 +479,50 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func authN(r *http.Request) error {
-	email := r.FormValue("email")
+// errLoginFailed is the one answer to a bad login, so the form does not tell
+// anyone which emails have accounts.
+var errLoginFailed = errors.New("incorrect email or password")
+
+// dummyPasswordHash is compared against when there is no such user, so an
+// unknown email takes as long to reject as a wrong password.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte(randToken()), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Dummy password hash failed: %v", err)
+	}
+	return hash
+})
+
+// authN checks the login form's email and password against user_auth and
+// returns the user.  Every failure is errLoginFailed; the reason is logged.
+func authN(r *http.Request) (UserLogin, error) {
+	email := normalizeEmail(r.FormValue("email"))
 	password := r.FormValue("password")
+	if email == "" || password == "" {
+		return UserLogin{}, errLoginFailed
+	}
 
-	if email == "" {
-		return fmt.Errorf("missing user name")
+	u, err := userStore.LoadUserByEmail(r.Context(), email)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		if err != sql.ErrNoRows {
+			log.Printf("Login lookup for %s failed: %v", redactEmail(email), err)
+		}
+		return UserLogin{}, errLoginFailed
 	}
 
-	// Success - For now
-	if email == password {
-		return nil
+	// Accounts from Google sign-in, and deleted accounts, have no password hash - any password fails.
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
+		log.Printf("Login failed for user %d: wrong password", u.ID)
+		return UserLogin{}, errLoginFailed
+	}
+	if !u.IsActive {
+		log.Printf("Login failed for user %d: account is not active", u.ID)
+		return UserLogin{}, errLoginFailed
 	}
 
-	return fmt.Errorf("incorrect password")
+	if err := userStore.RecordLogin(r.Context(), u.ID, time.Now()); err != nil {
+		log.Printf("Recording login for user %d failed: %v", u.ID, err) // Not a reason to refuse the login
+	}
+	return u, nil
 }
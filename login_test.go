@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// An email is one account whatever its case.
+func TestUserEmailCase(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+
+	id, err := st.CreateUser(ctx, &NewUser{Email: " Ann@Example.com", Role: roleHomeowner, IsActive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.CreateUser(ctx, &NewUser{Email: "ann@example.COM", Role: roleHomeowner, IsActive: true}); err == nil {
+		t.Errorf("created a second account for the same email in another case")
+	}
+	// A row written around CreateUser, as one from before emails were normalized
+	if _, err := st.db.ExecContext(ctx, `INSERT INTO user_auth (email, password_hash, role) VALUES ('ANN@example.com', '', 'homeowner')`); err == nil {
+		t.Errorf("the database allowed a second account for the same email in another case")
+	}
+
+	for _, email := range []string{"ann@example.com", "ANN@EXAMPLE.COM"} {
+		u, err := st.LoadUserByEmail(ctx, normalizeEmail(email))
+		if err != nil {
+			t.Fatalf("LoadUserByEmail(%q): %v", email, err)
+		}
+		if u.ID != id || u.Email != "ann@example.com" {
+			t.Errorf("LoadUserByEmail(%q) = %d %q, want %d ann@example.com", email, u.ID, u.Email, id)
+		}
+	}
+}
@@ -87,6 +87,28 @@ func privacyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Profiles set with APP_ENV
+const (
+	profileProduction  = "production"
+	profileDevelopment = "development"
+)
+
+// appProfile returns APP_ENV - production (the default) or development.
+// -dev means development unless APP_ENV says otherwise.
+func appProfile(dev bool) (string, error) {
+	switch env := os.Getenv("APP_ENV"); env {
+	case profileProduction, profileDevelopment:
+		return env, nil
+	case "":
+		if dev {
+			return profileDevelopment, nil
+		}
+		return profileProduction, nil
+	default:
+		return "", fmt.Errorf("unknown APP_ENV %q - use production or development", env)
+	}
+}
+
 func main() {
 	if err := loadCosts(); err != nil {
 		fmt.Println("Error loading costs:", err)
@@ -94,9 +116,43 @@ func main() {
 	}
 	devMode := flag.Bool("dev", false, "Run in development mode (localhost only)")
 	flag.Parse()
+	profile, err := appProfile(*devMode)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(flag.Args()[1:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+	if flag.Arg(0) == "pii" {
+		if err := runPII(profile, flag.Args()[1:]); err != nil {
+			log.Fatalf("pii: %v", err)
+		}
+		return
+	}
+
+	if err := openStores(profile); err != nil {
+		log.Fatalf("Database: %v", err)
+	}
+	if err := openSessionStore(profile); err != nil {
+		log.Fatalf("Sessions: %v", err)
+	}
+	if err := openMailer(profile); err != nil {
+		log.Fatalf("Mail: %v", err)
+	}
+	if err := loadEmailTokenKey(); err != nil {
+		log.Fatalf("Email tokens: %v", err)
+	}
+	configureGoogleOAuth()
+	startSessionSweeper(serverSessions)
+	startExpirationScheduler()
 
 	mux := http.NewServeMux()
 
+	// Routes wrapped in requireRole or requireEstimate check access first - see rbac.go.
 	mux.Handle("/images/", http.StripPrefix("/images/", http.FileServer(http.Dir("images"))))
 	mux.HandleFunc("/f7897e50677c40c4864e7f10255812bd.txt", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "static/f7897e50677c40c4864e7f10255812bd.txt") // https://www.bing.com/indexnow/getstarted
@@ -104,15 +160,35 @@ func main() {
 	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "images/colout2.png") // Adjust path to your file
 	})
-	mux.HandleFunc("/estimate", estimateHandler)
-	mux.HandleFunc("/customer", customerHandler)
-	mux.HandleFunc("/session", sessionHandler)
+	mux.HandleFunc("/estimate", requireEstimate(estimateHandler))
+	mux.HandleFunc("/estimate/signed", requireEstimate(signedHandler))
+	mux.HandleFunc("/changeorder", requireEstimate(changeOrderHandler))
+	mux.HandleFunc("/estimate/clone", requireEstimate(cloneHandler))
+	mux.HandleFunc("/estimate/compare", requireRole(compareHandler))
+	mux.HandleFunc("/estimate/export", requireEstimate(exportHandler))
+	mux.HandleFunc("/estimate/import", requireRole(importHandler, roleAdmin))
+	mux.HandleFunc("/estimates/export.csv", requireRole(estimatesCSVHandler, roleAdmin))
+	mux.HandleFunc("/estimate/attachments", requireEstimate(uploadAttachmentHandler))
+	mux.HandleFunc("/attachment", requireAttachment(attachmentHandler))
+	mux.HandleFunc("/attachment/delete", requireRole(requireAttachment(deleteAttachmentHandler)))
+	mux.HandleFunc("/jobs", requireRole(jobsHandler, roleContractor, roleAdmin))
+	mux.HandleFunc("/account", requireRole(accountHandler))
+	mux.HandleFunc("/account/export", requireRole(accountExportHandler))
+	mux.HandleFunc("/templates", requireRole(templatesHandler, roleAdmin, roleContractor))
+	mux.HandleFunc("/templates/edit", requireRole(templateEditHandler, roleAdmin))
+	mux.HandleFunc("/customer", requireEstimate(customerHandler))
+	mux.HandleFunc("/session", requireRole(sessionHandler, roleAdmin))
+	mux.HandleFunc("/debug/vars", requireRole(debugVarsHandler, roleAdmin))
 	mux.HandleFunc("/calc", calcHandler)
 	mux.HandleFunc("/css/", cssHandler)
 	mux.HandleFunc("/contact", contactHandler)
 	mux.HandleFunc("/contact/", contactHandler)
 	mux.HandleFunc("/login", loginHandler)
 	mux.HandleFunc("/signup", signupHandler)
+	mux.HandleFunc("/verify", verifyHandler)
+	mux.HandleFunc("/verify/resend", requireRole(verifyResendHandler))
+	mux.HandleFunc("/password/forgot", passwordForgotHandler)
+	mux.HandleFunc("/password/reset", passwordResetHandler)
 	mux.HandleFunc("/auth/google", googleLoginHandler)
 	mux.HandleFunc("/auth/google/callback", googleCallbackHandler)
 	mux.HandleFunc("/sitemap.xml", sitemapHandler)
@@ -133,7 +209,7 @@ func main() {
 	} else {
 		fmt.Println("Default Server starting on :8080...")
 	}
-	err := http.ListenAndServe(addr, mux)
+	err = http.ListenAndServe(addr, mux)
 	if err != nil {
 		fmt.Println("Error starting server:", err)
 	}
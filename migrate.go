@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are sql/migrations/<dialect>/NNNN_name.up.sql and NNNN_name.down.sql.
+// Both dialects use the same version numbers, so a version means the same schema.
+//
+//go:embed sql/migrations
+var migrationFiles embed.FS
+
+// migrationTimeout bounds one migration.  Longer than the query timeout - an
+// ALTER TABLE on a big table can take a while.
+const migrationTimeout = 5 * time.Minute
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`
+
+var migrationFileRE = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migration is one numbered schema change.
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// dir is the migrations directory for the dialect.
+func (d dialect) dir() string {
+	if d == dialectSQLite {
+		return "sql/migrations/sqlite"
+	}
+	return "sql/migrations/postgres"
+}
+
+// loadMigrations reads the embedded migrations for a dialect in version order.
+// Every migration must have both an up and a down script.
+func loadMigrations(d dialect) ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, d.dir())
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*migration{}
+	for _, e := range entries {
+		m := migrationFileRE.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(migrationFiles, path.Join(d.dir(), e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both up and down scripts", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// appliedMigrations returns the name of each migration recorded in schema_migrations, by version.
+func (s *SQLStore) appliedMigrations(ctx context.Context) (map[int]string, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT version, name FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]string{}
+	for rows.Next() {
+		var version int
+		var name string
+		if err := rows.Scan(&version, &name); err != nil {
+			return nil, err
+		}
+		applied[version] = name
+	}
+	return applied, rows.Err()
+}
+
+// runMigration applies (or reverts) one migration and records it, in one transaction.
+func (s *SQLStore) runMigration(ctx context.Context, m migration, up bool) error {
+	ctx, cancel := context.WithTimeout(ctx, migrationTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script := m.Up
+	if !up {
+		script = m.Down
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+			m.Version, m.Name, time.Now())
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp applies every pending migration up to and including target (0 for all).
+func (s *SQLStore) MigrateUp(ctx context.Context, target int) error {
+	migrations, err := loadMigrations(s.db.dialect)
+	if err != nil {
+		return err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := s.runMigration(ctx, m, true); err != nil {
+			return fmt.Errorf("migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// MigrateDown reverts the newest steps applied migrations.
+func (s *SQLStore) MigrateDown(ctx context.Context, steps int) error {
+	migrations, err := loadMigrations(s.db.dialect)
+	if err != nil {
+		return err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := s.runMigration(ctx, m, false); err != nil {
+			return fmt.Errorf("reverting migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+		log.Printf("Reverted migration %04d_%s", m.Version, m.Name)
+		steps--
+	}
+	return nil
+}
+
+// checkSchema returns an error unless every migration in this build has been applied.
+func (s *SQLStore) checkSchema(ctx context.Context) error {
+	migrations, err := loadMigrations(s.db.dialect)
+	if err != nil {
+		return err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, fmt.Sprintf("%04d_%s", m.Version, m.Name))
+		}
+		delete(applied, m.Version)
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind - pending migrations %s - run: colout2 migrate up",
+			strings.Join(pending, ", "))
+	}
+	for version, name := range applied {
+		log.Printf("Database has migration %04d_%s, which this build does not know about", version, name)
+	}
+	return nil
+}
+
+// runMigrate is the migrate subcommand:
+//
+//	colout2 migrate [up [version]]  apply pending migrations, all or up to version
+//	colout2 migrate down [steps]    revert the newest migration, or the newest steps
+//	colout2 migrate status          list migrations and whether each is applied
+func runMigrate(args []string) error {
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer st.db.Close()
+	ctx := context.Background()
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	n := 0
+	if len(args) > 1 {
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 0 {
+			return fmt.Errorf("invalid number %q", args[1])
+		}
+	}
+
+	switch cmd {
+	case "up":
+		return st.MigrateUp(ctx, n)
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		return st.MigrateDown(ctx, n)
+	case "status":
+		migrations, err := loadMigrations(st.db.dialect)
+		if err != nil {
+			return err
+		}
+		applied, err := st.appliedMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			state := "pending"
+			if _, ok := applied[m.Version]; ok {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%-28s %s\n", m.Version, m.Name, state)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q - use up, down or status", cmd)
+}
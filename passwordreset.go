@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// passwordResetTTL is how long a reset link works.
+const passwordResetTTL = 30 * time.Minute
+
+// passwordResetCooldown is how long after sending a reset link another request
+// for the same account is ignored, so the form cannot flood an inbox.
+const passwordResetCooldown = 5 * time.Minute
+
+// Password emails are sent after the response, at most resetMailSlots at once
+// and each within resetMailTimeout.  A request that finds no free slot is
+// dropped - the forgot form is open to anyone.
+const (
+	resetMailSlots   = 4
+	resetMailTimeout = 30 * time.Second
+)
+
+var resetMail = make(chan struct{}, resetMailSlots)
+
+var errBadResetToken = errors.New("password reset link is not valid")
+
+// PasswordPageData holds data for the forgot and reset password pages.
+type PasswordPageData struct {
+	Mode    string // forgot, sent, reset or invalid
+	Token   string
+	Message string
+}
+
+// newResetToken returns a random token for the emailed link, and the hash stored in password_resets.
+func newResetToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashResetToken(token), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreatePasswordReset stores a reset token hash for the user.
+func (s *SQLStore) CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, expires time.Time) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO password_resets (token_hash, user_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4)`, tokenHash, userID, expires, time.Now())
+	return err
+}
+
+// CheckPasswordReset returns the user a token is for, or errBadResetToken if it is
+// unknown, used or expired.
+func (s *SQLStore) CheckPasswordReset(ctx context.Context, tokenHash string, now time.Time) (int64, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	var userID int64
+	err := s.db.QueryRowContext(ctx, `SELECT p.user_id FROM password_resets p JOIN user_auth u ON u.id = p.user_id
+		WHERE p.token_hash = $1 AND p.used_at IS NULL AND p.expires_at > $2 AND u.is_active`,
+		tokenHash, now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, errBadResetToken
+	}
+	return userID, err
+}
+
+// ResetPassword sets a new password with a reset token.  It uses up all the
+// user's outstanding tokens and signs out their sessions, and as the link came
+// by email, marks the email verified.
+func (s *SQLStore) ResetPassword(ctx context.Context, tokenHash string, passwordHash string, now time.Time) (UserLogin, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return UserLogin{}, err
+	}
+	defer tx.Rollback()
+
+	// Claim the token in one statement, so of two requests with it only one gets a row
+	var u UserLogin
+	err = tx.QueryRowContext(ctx, `UPDATE password_resets SET used_at = $1
+		WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1 RETURNING user_id`, now, tokenHash).Scan(&u.ID)
+	if err == nil {
+		err = tx.QueryRowContext(ctx, `SELECT email, role, COALESCE(first_name, ''), COALESCE(last_name, '')
+			FROM user_auth WHERE id = $1 AND is_active`, u.ID).Scan(&u.Email, &u.Role, &u.FirstName, &u.LastName)
+	}
+	if err == sql.ErrNoRows {
+		return UserLogin{}, errBadResetToken
+	}
+	if err != nil {
+		return UserLogin{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE password_resets SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL`,
+		now, u.ID); err != nil {
+		return UserLogin{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE user_auth SET password_hash = $1, email_verified = TRUE,
+		sessions_valid_from = $2, updated_at = $2 WHERE id = $3`, passwordHash, now, u.ID); err != nil {
+		return UserLogin{}, err
+	}
+	u.IsActive, u.EmailVerified = true, true
+	return u, tx.Commit()
+}
+
+// PasswordResetSentAt returns when the user's latest reset link was created, or
+// the zero time if never.
+func (s *SQLStore) PasswordResetSentAt(ctx context.Context, userID int64) (time.Time, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	var at time.Time
+	err := s.db.QueryRowContext(ctx, `SELECT created_at FROM password_resets WHERE user_id = $1
+		ORDER BY created_at DESC LIMIT 1`, userID).Scan(&at)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return at, err
+}
+
+// SessionsValidFrom returns when the user's sessions were last signed out, or
+// the zero time if never.
+func (s *SQLStore) SessionsValidFrom(ctx context.Context, userID int64) (time.Time, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	var from sql.NullTime
+	err := s.db.QueryRowContext(ctx, `SELECT sessions_valid_from FROM user_auth WHERE id = $1`, userID).Scan(&from)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return from.Time, err
+}
+
+// sessionCheckInterval is how long signOutRevokedSession trusts a user's
+// sessions_valid_from before reading it again.  Sessions revoked by another
+// instance of the server take up to this long to be signed out here.
+var sessionCheckInterval = envDuration("SESSION_CHECK_INTERVAL", time.Minute)
+
+// validFromCache holds the sessions_valid_from of users seen recently, and when
+// it was read.  It is cleared when it reaches maxValidFromCache users.
+var validFromCache = struct {
+	sync.Mutex
+	users map[int64]validFrom
+}{users: map[int64]validFrom{}}
+
+const maxValidFromCache = 10000
+
+type validFrom struct {
+	from, readAt time.Time
+}
+
+// cachedSessionsValidFrom returns the user's sessions_valid_from, reading it at
+// most once per sessionCheckInterval.
+func cachedSessionsValidFrom(ctx context.Context, userID int64) (time.Time, error) {
+	validFromCache.Lock()
+	v, ok := validFromCache.users[userID]
+	validFromCache.Unlock()
+	if ok && time.Since(v.readAt) < sessionCheckInterval {
+		return v.from, nil
+	}
+
+	from, err := userStore.SessionsValidFrom(ctx, userID)
+	if err != nil {
+		return from, err
+	}
+	validFromCache.Lock()
+	if len(validFromCache.users) >= maxValidFromCache {
+		clear(validFromCache.users)
+	}
+	validFromCache.users[userID] = validFrom{from: from, readAt: time.Now()}
+	validFromCache.Unlock()
+	return from, nil
+}
+
+// forgetSessionsValidFrom drops the user's cached sessions_valid_from, so
+// sessions revoked here are signed out on their next request.
+func forgetSessionsValidFrom(userID int64) {
+	validFromCache.Lock()
+	delete(validFromCache.users, userID)
+	validFromCache.Unlock()
+}
+
+// signOutRevokedSession clears a logged in session that started before the
+// user's sessions were revoked - by a password reset, linking a Google account
+// to an unverified email, or deleting the account.  Sessions are stored by ID,
+// not by user, so they are checked as they are used rather than deleted then.
+func signOutRevokedSession(ctx context.Context, sd *SessionData) {
+	if !sd.UserAuth.IsAuthenticated || sd.UserAuth.ID <= 0 {
+		return
+	}
+	from, err := cachedSessionsValidFrom(ctx, sd.UserAuth.ID)
+	if err != nil {
+		log.Printf("Session check for user %d failed: %v", sd.UserAuth.ID, err)
+		return
+	}
+	if !from.IsZero() && sd.UserAuth.LoginAt.Before(from) {
+		log.Printf("Signing out session for user %d - sessions revoked since login", sd.UserAuth.ID)
+		*sd = SessionData{UserAuth: UserAuth{Message: "You have been signed out.  Please log in again."}}
+	}
+}
+
+var resetEmailHTML = template.Must(template.New("reset").Parse(`
+	<p>Hi {{.Name}},</p>
+	<p>Someone asked to reset the password for your Columbia Outdoor account.  To choose a new password:</p>
+	<p><a href="{{.Link}}">Reset my password</a></p>
+	<p>The link works once, for 30 minutes.  If you did not ask for this, you can ignore this email - your
+	password has not changed.</p>
+	<hr>
+	<small>Columbia Outdoor – Pacific Northwest’s trusted outdoor living platform</small>
+`))
+
+var passwordChangedEmailHTML = template.Must(template.New("changed").Parse(`
+	<p>Hi {{.Name}},</p>
+	<p>The password for your Columbia Outdoor account was changed on {{.When}}, and you have been signed out
+	everywhere.</p>
+	<p>If this was not you, please <a href="{{.Link}}">reset your password</a> and contact us at
+	support@columbiaoutdoor.com.</p>
+	<hr>
+	<small>Columbia Outdoor – Pacific Northwest’s trusted outdoor living platform</small>
+`))
+
+// sendPasswordReset emails a reset link to the account with this email, if
+// there is an active one.  Nothing tells the caller which it was.
+func sendPasswordReset(ctx context.Context, email string) {
+	u, err := userStore.LoadUserByEmail(ctx, email)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Password reset lookup for %s failed: %v", redactEmail(email), err)
+		}
+		return
+	}
+	if !u.IsActive {
+		return
+	}
+	if sent, err := userStore.PasswordResetSentAt(ctx, u.ID); err != nil {
+		log.Printf("Password reset for user %d failed: %v", u.ID, err)
+		return
+	} else if time.Since(sent) < passwordResetCooldown {
+		log.Printf("Password reset for user %d not sent - one was sent %v ago", u.ID, time.Since(sent).Round(time.Second))
+		return
+	}
+
+	token, hash, err := newResetToken()
+	if err == nil {
+		err = userStore.CreatePasswordReset(ctx, u.ID, hash, time.Now().Add(passwordResetTTL))
+	}
+	if err != nil {
+		log.Printf("Password reset for user %d failed: %v", u.ID, err)
+		return
+	}
+
+	var body bytes.Buffer
+	link := siteURL() + "/password/reset?token=" + url.QueryEscape(token)
+	if err := resetEmailHTML.Execute(&body, struct{ Name, Link string }{u.Name(), link}); err != nil {
+		log.Printf("Password reset email failed: %v", err)
+		return
+	}
+	if err := mailer.Send(ctx, Email{ToName: u.Name(), ToAddress: u.Email, Subject: "Reset your password",
+		HTML: body.String()}); err != nil {
+		log.Printf("Password reset email for user %d failed: %v", u.ID, err)
+		return
+	}
+	log.Printf("Password reset link sent to user %d", u.ID)
+}
+
+// sendResetMail runs send after the response, if one of the resetMail slots is
+// free, and reports whether it did.
+func sendResetMail(send func(ctx context.Context)) bool {
+	select {
+	case resetMail <- struct{}{}:
+	default:
+		return false
+	}
+	go func() {
+		defer func() { <-resetMail }()
+		ctx, cancel := context.WithTimeout(context.Background(), resetMailTimeout)
+		defer cancel()
+		send(ctx)
+	}()
+	return true
+}
+
+// sendPasswordChanged tells the user their password was changed.
+func sendPasswordChanged(ctx context.Context, u UserLogin, when time.Time) {
+	var body bytes.Buffer
+	err := passwordChangedEmailHTML.Execute(&body, struct{ Name, When, Link string }{
+		u.Name(), when.Format("January 2, 2006 at 3:04 PM MST"), siteURL() + "/password/forgot"})
+	if err == nil {
+		err = mailer.Send(ctx, Email{ToName: u.Name(), ToAddress: u.Email, Subject: "Your password was changed",
+			HTML: body.String()})
+	}
+	if err != nil {
+		log.Printf("Password changed email for user %d failed: %v", u.ID, err)
+	}
+}
+
+func renderPasswordPage(w http.ResponseWriter, r *http.Request, data PasswordPageData) {
+	tmpl := template.Must(template.New("password.html").Funcs(funcMap).ParseFiles("templates/password.html",
+		"templates/header.html", "templates/footer.html"))
+
+	userAuth := getUserAuth(r, w)
+	userAuth.Title = "Reset Password"
+	rd := renderData{
+		Page:   &data,
+		Header: &userAuth,
+	}
+	if err := tmpl.ExecuteTemplate(w, "password.html", rd); err != nil {
+		log.Printf("Password page execute error: %v", err)
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+	}
+}
+
+// passwordForgotHandler - GET/POST /password/forgot - asks for an email and sends a reset link.
+// The answer is the same whether or not the email has an account.
+func passwordForgotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if email := normalizeEmail(r.FormValue("email")); email != "" {
+			// In the background, so the response takes as long either way
+			if !sendResetMail(func(ctx context.Context) { sendPasswordReset(ctx, email) }) {
+				log.Printf("Password reset for %s dropped - too many being sent", redactEmail(email))
+			}
+		}
+		http.Redirect(w, r, "/password/forgot?sent=1", http.StatusSeeOther)
+		return
+	}
+
+	data := PasswordPageData{Mode: "forgot"}
+	if r.URL.Query().Get("sent") == "1" {
+		data.Mode = "sent"
+	}
+	renderPasswordPage(w, r, data)
+}
+
+// passwordResetHandler - GET/POST /password/reset?token= - the link in the reset email.
+func passwordResetHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	data := PasswordPageData{Mode: "reset", Token: token}
+	if token == "" {
+		data.Mode = "invalid"
+		renderPasswordPage(w, r, data)
+		return
+	}
+	hash := hashResetToken(token)
+
+	if r.Method != http.MethodPost {
+		if _, err := userStore.CheckPasswordReset(r.Context(), hash, time.Now()); err != nil {
+			if err != errBadResetToken {
+				log.Printf("Password reset check failed: %v", err)
+			}
+			data.Mode = "invalid"
+		}
+		renderPasswordPage(w, r, data)
+		return
+	}
+
+	pass1 := r.FormValue("password")
+	pass2 := r.FormValue("password2")
+	if !passwordOK(pass1, pass2) {
+		data.Message = "Please make sure the passwords match (8+ chars)"
+		renderPasswordPage(w, r, data)
+		return
+	}
+	passwordHash, err := hashPassword(pass1)
+	if err != nil {
+		log.Printf("Password Hash failed: %v", err)
+		data.Message = "Something went wrong.  Please try again."
+		renderPasswordPage(w, r, data)
+		return
+	}
+
+	now := time.Now()
+	u, err := userStore.ResetPassword(r.Context(), hash, passwordHash, now)
+	if err != nil {
+		if err != errBadResetToken {
+			log.Printf("Password reset failed: %v", err)
+		}
+		data.Mode = "invalid"
+		renderPasswordPage(w, r, data)
+		return
+	}
+	log.Printf("User %d reset their password", u.ID)
+	forgetSessionsValidFrom(u.ID)
+	if !sendResetMail(func(ctx context.Context) { sendPasswordChanged(ctx, u, now) }) {
+		log.Printf("Password changed email for user %d dropped - too many being sent", u.ID)
+	}
+
+	// This browser too - start it a fresh session
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	*sd = SessionData{UserAuth: UserAuth{Message: "Your password has been changed.  Please log in."}}
+	if err := sd.Regenerate(r, w); err != nil {
+		log.Printf("Password reset: Session save Error: %v", err)
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewResetToken(t *testing.T) {
+	token, hash, err := newResetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != hashResetToken(token) || hash == token {
+		t.Errorf("hash %q is not the hash of token %q", hash, token)
+	}
+	if other, _, _ := newResetToken(); other == token {
+		t.Errorf("two tokens are the same")
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	now := time.Now()
+
+	newUser := func(email string, active bool) int64 {
+		t.Helper()
+		id, err := st.CreateUser(ctx, &NewUser{Email: email, PasswordHash: "old", Role: roleHomeowner, IsActive: active})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	active := newUser("ann@example.com", true)
+	inactive := newUser("bob@example.com", false)
+
+	newToken := func(userID int64, expires time.Time) string {
+		t.Helper()
+		token, hash, err := newResetToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := st.CreatePasswordReset(ctx, userID, hash, expires); err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := newToken(active, now.Add(passwordResetTTL))
+	sibling := newToken(active, now.Add(passwordResetTTL)) // A second link sent to the same user
+	expired := newToken(active, now.Add(-time.Second))
+	closed := newToken(inactive, now.Add(passwordResetTTL))
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"unknown", "not-a-token", errBadResetToken},
+		{"expired", expired, errBadResetToken},
+		{"inactive account", closed, errBadResetToken},
+		{"valid", valid, nil},
+		{"used", valid, errBadResetToken},
+		{"used up by the reset", sibling, errBadResetToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash := hashResetToken(tt.token)
+			userID, err := st.CheckPasswordReset(ctx, hash, now)
+			if err != tt.wantErr {
+				t.Fatalf("CheckPasswordReset err = %v, want %v", err, tt.wantErr)
+			}
+			u, err := st.ResetPassword(ctx, hash, "new", now)
+			if err != tt.wantErr {
+				t.Fatalf("ResetPassword err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (userID != active || u.ID != active) {
+				t.Errorf("reset user %d / %d, want %d", userID, u.ID, active)
+			}
+		})
+	}
+
+	// The reset signs out sessions that logged in before it
+	from, err := st.SessionsValidFrom(ctx, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !from.Equal(now) {
+		t.Errorf("sessions valid from %v, want %v", from, now)
+	}
+	for _, tt := range []struct {
+		name    string
+		loginAt time.Time
+		signOut bool
+	}{
+		{"logged in before", now.Add(-time.Minute), true},
+		{"logged in after", now.Add(time.Minute), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sd := SessionData{UserAuth: UserAuth{ID: active, IsAuthenticated: true, LoginAt: tt.loginAt}}
+			signOutRevokedSession(ctx, &sd)
+			if signedOut := !sd.UserAuth.IsAuthenticated; signedOut != tt.signOut {
+				t.Errorf("signed out = %v, want %v", signedOut, tt.signOut)
+			}
+		})
+	}
+}
+
+// Requests racing with the same link - only one of them resets the password.
+func TestResetPasswordRace(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	userID, err := st.CreateUser(ctx, &NewUser{Email: "ann@example.com", PasswordHash: "old", Role: roleHomeowner, IsActive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, hash, err := newResetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreatePasswordReset(ctx, userID, hash, time.Now().Add(passwordResetTTL)); err != nil {
+		t.Fatal(err)
+	}
+
+	const requests = 8
+	errs := make([]error, requests)
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = st.ResetPassword(ctx, hashResetToken(token), "new", time.Now())
+		}()
+	}
+	wg.Wait()
+
+	reset := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			reset++
+		case errBadResetToken:
+		default:
+			t.Errorf("ResetPassword err = %v", err)
+		}
+	}
+	if reset != 1 {
+		t.Errorf("%d of %d requests reset the password, want 1", reset, requests)
+	}
+}
+
+func TestPasswordResetCooldown(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	sent := useSentMail(t)
+	userID, err := st.CreateUser(ctx, &NewUser{Email: "ann@example.com", PasswordHash: "old", Role: roleHomeowner, IsActive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendPasswordReset(ctx, "ann@example.com")
+	sendPasswordReset(ctx, "ann@example.com") // Within the cooldown
+	if len(sent.emails) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sent.emails))
+	}
+
+	// A link sent before the cooldown does not hold the next one back
+	if _, err := st.db.ExecContext(ctx, `UPDATE password_resets SET created_at = $1 WHERE user_id = $2`,
+		time.Now().Add(-passwordResetCooldown), userID); err != nil {
+		t.Fatal(err)
+	}
+	sendPasswordReset(ctx, "ann@example.com")
+	if len(sent.emails) != 2 {
+		t.Errorf("sent %d emails after the cooldown, want 2", len(sent.emails))
+	}
+}
+
+// Revoked sessions are found by the cached check once the cache is refreshed or
+// the revocation was made here.
+func TestSignOutRevokedSessionCache(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	userID, err := st.CreateUser(ctx, &NewUser{Email: "ann@example.com", PasswordHash: "old", Role: roleHomeowner, IsActive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginAt := time.Now().Add(-time.Hour)
+	signedOut := func() bool {
+		sd := SessionData{UserAuth: UserAuth{ID: userID, IsAuthenticated: true, LoginAt: loginAt}}
+		signOutRevokedSession(ctx, &sd)
+		return !sd.UserAuth.IsAuthenticated
+	}
+
+	if signedOut() {
+		t.Fatal("signed out before any revocation")
+	}
+	if _, err := st.db.ExecContext(ctx, `UPDATE user_auth SET sessions_valid_from = $1 WHERE id = $2`,
+		time.Now(), userID); err != nil {
+		t.Fatal(err)
+	}
+	if signedOut() {
+		t.Errorf("signed out within sessionCheckInterval - the check was not cached")
+	}
+	forgetSessionsValidFrom(userID)
+	if !signedOut() {
+		t.Errorf("not signed out after the cache was dropped")
+	}
+}
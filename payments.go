@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+)
+
+// PaymentTemplate is one milestone of a payment schedule in costs.yaml.
+type PaymentTemplate struct {
+	Name    string  `yaml:"name"`
+	Percent float64 `yaml:"percent"`
+	Due     string  `yaml:"due"`
+}
+
+// PaymentMilestone is a payment due on an accepted estimate.
+type PaymentMilestone struct {
+	Seq     int
+	Name    string
+	Due     string
+	Percent float64
+	Amount  float64 // Dollars, always whole cents
+	Paid    bool
+}
+
+// validatePaymentSchedules checks each schedule in costs.yaml adds up to 100%.
+func validatePaymentSchedules(c Costs) error {
+	for productType, schedule := range c.PaymentSchedules {
+		if len(schedule) == 0 {
+			return fmt.Errorf("payment schedule %q has no milestones", productType)
+		}
+		total := 0.0
+		for _, m := range schedule {
+			if m.Percent <= 0 {
+				return fmt.Errorf("payment schedule %q: milestone %q must have a positive percent", productType, m.Name)
+			}
+			total += m.Percent
+		}
+		if math.Abs(total-100) > 0.0001 {
+			return fmt.Errorf("payment schedule %q adds up to %.2f%%, not 100%%", productType, total)
+		}
+	}
+	return nil
+}
+
+// buildPaymentSchedule splits the total into milestones for the product type.
+//
+// The math is done in whole cents.  Each milestone is rounded to the nearest
+// cent and the last one takes the remainder, so the milestones always add up
+// to exactly the total.
+func buildPaymentSchedule(c Costs, productType string, total float64) []PaymentMilestone {
+	schedule := c.PaymentSchedules[productType]
+	if len(schedule) == 0 {
+		// No schedule configured - everything due on completion
+		schedule = []PaymentTemplate{{Name: "Payment in full", Percent: 100, Due: "On completion"}}
+	}
+
+	totalCents := int64(math.Round(total * 100))
+	remaining := totalCents
+	milestones := make([]PaymentMilestone, 0, len(schedule))
+	for i, t := range schedule {
+		cents := int64(math.Round(float64(totalCents) * t.Percent / 100))
+		if i == len(schedule)-1 {
+			cents = remaining
+		}
+		remaining -= cents
+		milestones = append(milestones, PaymentMilestone{
+			Seq:     i + 1,
+			Name:    t.Name,
+			Due:     t.Due,
+			Percent: t.Percent,
+			Amount:  float64(cents) / 100,
+		})
+	}
+	return milestones
+}
+
+// insertPaymentSchedule writes the milestones for an estimate as part of a transaction.
+func insertPaymentSchedule(ctx context.Context, tx *sqlTx, estimateID int, milestones []PaymentMilestone) error {
+	const stmt = `INSERT INTO payment_milestones (estimate_id, seq, name, due, percent, amount)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	for _, m := range milestones {
+		if _, err := tx.ExecContext(ctx, stmt, estimateID, m.Seq, m.Name, m.Due, m.Percent, m.Amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadPaymentSchedule reads the milestones for an estimate in order.
+func (s *SQLStore) LoadPaymentSchedule(ctx context.Context, estimateID int) ([]PaymentMilestone, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, paymentScheduleQuery, estimateID)
+	if err != nil {
+		return nil, err
+	}
+	return scanPaymentSchedule(rows)
+}
+
+const paymentScheduleQuery = `SELECT seq, name, due, percent, amount, paid_at IS NOT NULL
+	FROM payment_milestones WHERE estimate_id = $1 ORDER BY seq`
+
+func scanPaymentSchedule(rows *sql.Rows) ([]PaymentMilestone, error) {
+	defer rows.Close()
+
+	var milestones []PaymentMilestone
+	for rows.Next() {
+		var m PaymentMilestone
+		if err := rows.Scan(&m.Seq, &m.Name, &m.Due, &m.Percent, &m.Amount, &m.Paid); err != nil {
+			return nil, err
+		}
+		milestones = append(milestones, m)
+	}
+	return milestones, rows.Err()
+}
+
+// rebalancePaymentSchedule moves a schedule to a new contract total.  Paid
+// milestones keep their amounts; the rest of the total is split over the unpaid
+// ones by their percent, in whole cents with the last taking the remainder.  If
+// nothing is left unpaid, or more has been paid than the new total, the unpaid
+// milestones drop to zero and the difference is a new milestone with the given
+// name - negative when it is owed back.
+func rebalancePaymentSchedule(milestones []PaymentMilestone, total float64, name string) []PaymentMilestone {
+	out := append([]PaymentMilestone(nil), milestones...)
+	remaining := int64(math.Round(total * 100))
+	var unpaid []int
+	percent := 0.0
+	for i, m := range out {
+		if m.Paid {
+			remaining -= int64(math.Round(m.Amount * 100))
+			continue
+		}
+		unpaid = append(unpaid, i)
+		percent += m.Percent
+	}
+
+	if len(unpaid) == 0 || remaining < 0 || percent <= 0 {
+		for _, i := range unpaid {
+			out[i].Amount = 0
+		}
+		if remaining == 0 {
+			return out
+		}
+		due := "On completion"
+		if remaining < 0 {
+			due = "Refund"
+		}
+		return append(out, PaymentMilestone{Seq: out[len(out)-1].Seq + 1, Name: name, Due: due,
+			Amount: float64(remaining) / 100})
+	}
+
+	left := remaining
+	for n, i := range unpaid {
+		cents := int64(math.Round(float64(remaining) * out[i].Percent / percent))
+		if n == len(unpaid)-1 {
+			cents = left
+		}
+		left -= cents
+		out[i].Amount = float64(cents) / 100
+	}
+	return out
+}
+
+// reschedulePayments rebalances an estimate's payment schedule to a new total as
+// part of a transaction.  Estimates accepted before there were schedules have none
+// and are left alone.
+func reschedulePayments(ctx context.Context, tx *sqlTx, estimateID int, total float64, name string) error {
+	rows, err := tx.QueryContext(ctx, paymentScheduleQuery, estimateID)
+	if err != nil {
+		return err
+	}
+	milestones, err := scanPaymentSchedule(rows)
+	if err != nil || len(milestones) == 0 {
+		return err
+	}
+
+	rebalanced := rebalancePaymentSchedule(milestones, total, name)
+	for _, m := range rebalanced[:len(milestones)] {
+		if m.Paid {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE payment_milestones SET amount = $1
+			WHERE estimate_id = $2 AND seq = $3 AND paid_at IS NULL`, m.Amount, estimateID, m.Seq); err != nil {
+			return err
+		}
+	}
+	return insertPaymentSchedule(ctx, tx, estimateID, rebalanced[len(milestones):])
+}
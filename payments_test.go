@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuildPaymentSchedule(t *testing.T) {
+	thirds := Costs{PaymentSchedules: map[string][]PaymentTemplate{
+		productDeck: {
+			{Name: "Deposit", Percent: 100.0 / 3, Due: "On signing"},
+			{Name: "Materials", Percent: 100.0 / 3, Due: "On delivery"},
+			{Name: "Final", Percent: 100.0 / 3, Due: "On completion"},
+		},
+	}}
+	tests := []struct {
+		name        string
+		costs       Costs
+		productType string
+		total       float64
+		want        []float64
+	}{
+		{"splits evenly", thirds, productDeck, 300, []float64{100, 100, 100}},
+		{"last takes the remainder", thirds, productDeck, 100, []float64{33.33, 33.33, 33.34}},
+		{"rounds each to the cent", thirds, productDeck, 1000.01, []float64{333.34, 333.34, 333.33}},
+		{"zero total", thirds, productDeck, 0, []float64{0, 0, 0}},
+		{"no schedule - paid in full", Costs{}, productDeck, 1234.56, []float64{1234.56}},
+		{"unknown product - paid in full", thirds, "pergola", 99.99, []float64{99.99}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildPaymentSchedule(tt.costs, tt.productType, tt.total)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d milestones, want %d", len(got), len(tt.want))
+			}
+			var sumCents int64
+			for i, m := range got {
+				if m.Seq != i+1 {
+					t.Errorf("milestone %d: Seq = %d", i, m.Seq)
+				}
+				if m.Amount != tt.want[i] {
+					t.Errorf("milestone %d: Amount = %v, want %v", i, m.Amount, tt.want[i])
+				}
+				sumCents += int64(math.Round(m.Amount * 100))
+			}
+			if totalCents := int64(math.Round(tt.total * 100)); sumCents != totalCents {
+				t.Errorf("milestones add up to %d cents, want %d", sumCents, totalCents)
+			}
+		})
+	}
+}
+
+func TestValidatePaymentSchedules(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule []PaymentTemplate
+		wantErr  bool
+	}{
+		{"adds up to 100", []PaymentTemplate{{Name: "a", Percent: 30}, {Name: "b", Percent: 70}}, false},
+		{"thirds", []PaymentTemplate{{Name: "a", Percent: 100.0 / 3}, {Name: "b", Percent: 100.0 / 3}, {Name: "c", Percent: 100.0 / 3}}, false},
+		{"under 100", []PaymentTemplate{{Name: "a", Percent: 30}, {Name: "b", Percent: 60}}, true},
+		{"over 100", []PaymentTemplate{{Name: "a", Percent: 50}, {Name: "b", Percent: 60}}, true},
+		{"zero percent", []PaymentTemplate{{Name: "a", Percent: 100}, {Name: "b", Percent: 0}}, true},
+		{"empty", []PaymentTemplate{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePaymentSchedules(Costs{PaymentSchedules: map[string][]PaymentTemplate{productDeck: tt.schedule}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRebalancePaymentSchedule(t *testing.T) {
+	// 30 / 40 / 30 of 10000
+	schedule := func(paid ...bool) []PaymentMilestone {
+		ms := []PaymentMilestone{
+			{Seq: 1, Name: "Deposit", Percent: 30, Amount: 3000},
+			{Seq: 2, Name: "Framing", Percent: 40, Amount: 4000},
+			{Seq: 3, Name: "Final", Percent: 30, Amount: 3000},
+		}
+		for i, p := range paid {
+			ms[i].Paid = p
+		}
+		return ms
+	}
+	tests := []struct {
+		name      string
+		schedule  []PaymentMilestone
+		total     float64
+		want      []float64
+		wantExtra string // Due of the added milestone, "" if none
+	}{
+		{"nothing paid", schedule(), 12000, []float64{3600, 4800, 3600}, ""},
+		{"deposit paid", schedule(true), 12000, []float64{3000, 5142.86, 3857.14}, ""},
+		{"deposit paid, lower total", schedule(true), 8000, []float64{3000, 2857.14, 2142.86}, ""},
+		{"all paid", schedule(true, true, true), 10500, []float64{3000, 4000, 3000, 500}, "On completion"},
+		{"paid more than the new total", schedule(true, true), 6000, []float64{3000, 4000, 0, -1000}, "Refund"},
+		{"all paid, no change", schedule(true, true, true), 10000, []float64{3000, 4000, 3000}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rebalancePaymentSchedule(tt.schedule, tt.total, "Change order #1")
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d milestones, want %d", len(got), len(tt.want))
+			}
+			var sumCents int64
+			for i, m := range got {
+				if m.Amount != tt.want[i] {
+					t.Errorf("milestone %d: Amount = %v, want %v", i+1, m.Amount, tt.want[i])
+				}
+				sumCents += int64(math.Round(m.Amount * 100))
+			}
+			if totalCents := int64(math.Round(tt.total * 100)); sumCents != totalCents {
+				t.Errorf("milestones add up to %d cents, want %d", sumCents, totalCents)
+			}
+			if tt.wantExtra != "" {
+				extra := got[len(got)-1]
+				if extra.Seq != 4 || extra.Name != "Change order #1" || extra.Due != tt.wantExtra {
+					t.Errorf("added milestone = %+v, want #4 due %q", extra, tt.wantExtra)
+				}
+			}
+			if tt.schedule[1].Amount != 4000 {
+				t.Errorf("the schedule passed in was changed")
+			}
+		})
+	}
+}
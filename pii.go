@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Customer PII (names, street address, phone and email) is encrypted by the
+// application before it is written, so a copy of the database or a backup does
+// not give it away.  City, state and zip stay in the clear - the job board and
+// sales tax need them.
+//
+//	PII_KEYS       id:base64key[,id:base64key...]  32 byte AES keys.  The first
+//	               key encrypts; all of them decrypt.  Rotate by putting a new
+//	               key first, then running "colout2 pii rotate".
+//	PII_INDEX_KEY  base64 HMAC key for the email blind index.
+//
+// A stored value is "pii1:<key id>:<base64 nonce+ciphertext>" (AES-256-GCM).
+// Anything without the prefix is a value written before encryption and is
+// returned as is until "pii rotate" encrypts it.
+
+const piiPrefix = "pii1:"
+
+var piiKeyIDRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// piiKeyring holds the loaded keys.
+type piiKeyring struct {
+	current  string                 // ID of the key new values are encrypted with
+	ciphers  map[string]cipher.AEAD // By key ID
+	indexKey []byte
+}
+
+// piiKeys is set by loadPIIKeys.  With no keys, values are stored in the clear.
+var piiKeys = &piiKeyring{ciphers: map[string]cipher.AEAD{}}
+
+// loadPIIKeys reads PII_KEYS and PII_INDEX_KEY.  They are required outside the
+// development profile.
+func loadPIIKeys(profile string) error {
+	kr, err := parsePIIKeys(os.Getenv("PII_KEYS"), os.Getenv("PII_INDEX_KEY"))
+	if err != nil {
+		return err
+	}
+	if kr.current == "" {
+		if profile != profileDevelopment {
+			return errors.New("PII_KEYS and PII_INDEX_KEY are required - set APP_ENV=development to run without them")
+		}
+		log.Printf("PII_KEYS is not set - customer details are stored unencrypted")
+	}
+	piiKeys = kr
+	return nil
+}
+
+func parsePIIKeys(keys, indexKey string) (*piiKeyring, error) {
+	kr := &piiKeyring{ciphers: map[string]cipher.AEAD{}}
+	for _, entry := range strings.Split(keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, b64, ok := strings.Cut(entry, ":")
+		if !ok || !piiKeyIDRE.MatchString(id) {
+			return nil, fmt.Errorf("PII_KEYS: entry %q is not id:base64key", id)
+		}
+		if _, dup := kr.ciphers[id]; dup {
+			return nil, fmt.Errorf("PII_KEYS: key %q is listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("PII_KEYS: key %q must be 32 bytes, base64 encoded", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		kr.ciphers[id] = gcm
+		if kr.current == "" {
+			kr.current = id
+		}
+	}
+
+	if indexKey != "" {
+		key, err := base64.StdEncoding.DecodeString(indexKey)
+		if err != nil || len(key) < 16 {
+			return nil, errors.New("PII_INDEX_KEY must be at least 16 bytes, base64 encoded")
+		}
+		kr.indexKey = key
+	} else if kr.current != "" {
+		return nil, errors.New("PII_INDEX_KEY is required with PII_KEYS")
+	}
+	return kr, nil
+}
+
+// encrypt seals a value with the current key.  Empty stays empty.
+func (kr *piiKeyring) encrypt(value string) (string, error) {
+	if value == "" || kr.current == "" {
+		return value, nil
+	}
+	gcm := kr.ciphers[kr.current]
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return piiPrefix + kr.current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a stored value with whichever key sealed it.
+func (kr *piiKeyring) decrypt(stored string) (string, error) {
+	rest, ok := strings.CutPrefix(stored, piiPrefix)
+	if !ok {
+		return stored, nil // Written before encryption
+	}
+	id, b64, _ := strings.Cut(rest, ":")
+	gcm := kr.ciphers[id]
+	if gcm == nil {
+		return "", fmt.Errorf("pii: no key %q in PII_KEYS", id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("pii: malformed value for key %q", id)
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("pii: value does not decrypt with key %q", id)
+	}
+	return string(plain), nil
+}
+
+// isCurrent reports whether a stored value needs no rotation.
+func (kr *piiKeyring) isCurrent(stored string) bool {
+	if stored == "" {
+		return true
+	}
+	if kr.current == "" {
+		return !strings.HasPrefix(stored, piiPrefix)
+	}
+	return strings.HasPrefix(stored, piiPrefix+kr.current+":")
+}
+
+// emailIndex is the blind index stored next to an encrypted email - an HMAC of the
+// normalized address - so rows can be found by email without decrypting them.
+func (kr *piiKeyring) emailIndex(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, kr.indexKey)
+	mac.Write([]byte(email))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encryptAll encrypts each value in place.
+func (kr *piiKeyring) encryptAll(values ...*string) error {
+	for _, v := range values {
+		enc, err := kr.encrypt(*v)
+		if err != nil {
+			return err
+		}
+		*v = enc
+	}
+	return nil
+}
+
+// decryptAll decrypts each value in place.
+func (kr *piiKeyring) decryptAll(values ...*string) error {
+	for _, v := range values {
+		dec, err := kr.decrypt(*v)
+		if err != nil {
+			return err
+		}
+		*v = dec
+	}
+	return nil
+}
+
+// encrypt returns a copy of the customer with the PII fields encrypted.
+func (c Customer) encrypt() (Customer, error) {
+	err := piiKeys.encryptAll(&c.FirstName, &c.LastName, &c.Address, &c.PhoneNumber, &c.Email)
+	return c, err
+}
+
+// decrypt decrypts the customer's PII fields in place.
+func (c *Customer) decrypt() error {
+	return piiKeys.decryptAll(&c.FirstName, &c.LastName, &c.Address, &c.PhoneNumber, &c.Email)
+}
+
+// String keeps customer details out of the logs.
+func (c Customer) String() string {
+	return fmt.Sprintf("{%s %s, %s, %s %s}", redactName(c.FirstName), redactName(c.LastName),
+		redactEmail(c.Email), c.State, c.Zip)
+}
+
+// redactName keeps the first letter of a name, for logs.
+func redactName(name string) string {
+	for _, r := range name {
+		return string(r) + "***"
+	}
+	return ""
+}
+
+// redactEmail keeps the first letter and domain of an email address, for logs.
+func redactEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return redactName(email)
+	}
+	return redactName(local) + "@" + domain
+}
+
+// piiTable is a table with encrypted columns and an email blind index.
+type piiTable struct {
+	Table   string
+	IDCol   string
+	Columns []string // Encrypted columns
+	Email   string   // Column the email_index is computed from, "" if none
+}
+
+var piiTables = []piiTable{
+	{"customers", "customer_id", []string{"first_name", "last_name", "address", "phone_number", "email"}, "email"},
+	{"contact_submissions", "submission_id", []string{"name", "email", "phone", "message"}, "email"},
+	{"estimate_reminders", "reminder_id", []string{"email"}, ""},
+	{"estimate_acceptances", "acceptance_id", []string{"signer_name", "document_text"}, ""},
+	{"change_orders", "change_order_id", []string{"signer_name", "ip_address"}, ""},
+}
+
+// piiRotateBatch is the number of rows re-encrypted per transaction.
+const piiRotateBatch = 500
+
+// RotatePII re-encrypts every value not sealed with the current key - including
+// values written before encryption was turned on - and recomputes the email
+// index.  Old keys can be removed from PII_KEYS once it has run.
+func (s *SQLStore) RotatePII(ctx context.Context) (int, error) {
+	total := 0
+	for _, t := range piiTables {
+		var lastID int64
+		for {
+			n, next, err := s.rotatePIIBatch(ctx, t, lastID)
+			if err != nil {
+				return total, fmt.Errorf("%s: %w", t.Table, err)
+			}
+			total += n
+			if next == lastID {
+				break
+			}
+			lastID = next
+		}
+	}
+	return total, nil
+}
+
+// rotatePIIBatch rotates the rows after lastID and returns how many changed and
+// the last ID seen.
+func (s *SQLStore) rotatePIIBatch(ctx context.Context, t piiTable, lastID int64) (int, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, migrationTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, lastID, err
+	}
+	defer tx.Rollback()
+
+	// Columns such as a pending change order's signer are NULL until filled in
+	coalesced := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		coalesced[i] = fmt.Sprintf("COALESCE(%s, '')", c)
+	}
+	cols := strings.Join(coalesced, ", ")
+	if t.Email != "" {
+		cols += ", email_index"
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s > $1 ORDER BY %s LIMIT $2`,
+		t.IDCol, cols, t.Table, t.IDCol, t.IDCol), lastID, piiRotateBatch)
+	if err != nil {
+		return 0, lastID, err
+	}
+	type row struct {
+		id     int64
+		values []string
+		index  string
+	}
+	var batch []row
+	for rows.Next() {
+		r := row{values: make([]string, len(t.Columns))}
+		dest := []any{&r.id}
+		for i := range r.values {
+			dest = append(dest, &r.values[i])
+		}
+		if t.Email != "" {
+			dest = append(dest, &r.index)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, lastID, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, lastID, err
+	}
+
+	set := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		set[i] = fmt.Sprintf("%s = $%d", c, i+1)
+	}
+	if t.Email != "" {
+		set = append(set, fmt.Sprintf("email_index = $%d", len(t.Columns)+1))
+	}
+	update := fmt.Sprintf(`UPDATE %s SET %s WHERE %s = $%d`,
+		t.Table, strings.Join(set, ", "), t.IDCol, len(set)+1)
+
+	changed := 0
+	for _, r := range batch {
+		lastID = r.id
+		stale := false
+		index := ""
+		args := make([]any, 0, len(r.values)+2)
+		for i, v := range r.values {
+			plain, err := piiKeys.decrypt(v)
+			if err != nil {
+				return 0, lastID, fmt.Errorf("row %d: %w", r.id, err)
+			}
+			if !piiKeys.isCurrent(v) {
+				stale = true
+				if v, err = piiKeys.encrypt(plain); err != nil {
+					return 0, lastID, err
+				}
+			}
+			if t.Columns[i] == t.Email {
+				index = piiKeys.emailIndex(plain)
+			}
+			args = append(args, v)
+		}
+		if t.Email != "" {
+			stale = stale || index != r.index
+			args = append(args, index)
+		}
+		if !stale {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, update, append(args, r.id)...); err != nil {
+			return 0, lastID, err
+		}
+		changed++
+	}
+	return changed, lastID, tx.Commit()
+}
+
+// runPII is the pii subcommand:
+//
+//	colout2 pii genkey    print a new random key for PII_KEYS / PII_INDEX_KEY
+//	colout2 pii rotate    re-encrypt customer details with the first key in PII_KEYS
+func runPII(profile string, args []string) error {
+	cmd := ""
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "genkey":
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(key))
+		return nil
+	case "rotate":
+		if err := loadPIIKeys(profile); err != nil {
+			return err
+		}
+		st, err := openStore()
+		if err != nil {
+			return err
+		}
+		defer st.db.Close()
+		if err := st.checkSchema(context.Background()); err != nil {
+			return err
+		}
+		n, err := st.RotatePII(context.Background())
+		log.Printf("Re-encrypted %d rows", n)
+		return err
+	}
+	return fmt.Errorf("unknown pii command %q - use genkey or rotate", cmd)
+}
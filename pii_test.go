@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePIIKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     string
+		indexKey string
+		current  string
+		wantErr  bool
+	}{
+		{"none", "", "", "", false},
+		{"one", "a:" + testPIIKey("a"), testPIIKey("i"), "a", false},
+		{"first encrypts", "b:" + testPIIKey("b") + ", a:" + testPIIKey("a"), testPIIKey("i"), "b", false},
+		{"no index key", "a:" + testPIIKey("a"), "", "", true},
+		{"short key", "a:" + testPIIKey("a")[:20], testPIIKey("i"), "", true},
+		{"no id", testPIIKey("a"), testPIIKey("i"), "", true},
+		{"bad id", "a b:" + testPIIKey("a"), testPIIKey("i"), "", true},
+		{"duplicate id", "a:" + testPIIKey("a") + ",a:" + testPIIKey("b"), testPIIKey("i"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kr, err := parsePIIKeys(tt.keys, tt.indexKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && kr.current != tt.current {
+				t.Errorf("current = %q, want %q", kr.current, tt.current)
+			}
+		})
+	}
+}
+
+func TestPIIEncryptDecrypt(t *testing.T) {
+	kr := usePIIKeys(t, "a:"+testPIIKey("a"))
+	onlyB, err := parsePIIKeys("b:"+testPIIKey("b"), testPIIKey("i"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := kr.encrypt("Ann Smith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, "pii1:a:") || strings.Contains(sealed, "Ann") {
+		t.Fatalf("encrypt = %q, want it sealed with key a", sealed)
+	}
+	if again, _ := kr.encrypt("Ann Smith"); again == sealed {
+		t.Errorf("encrypting twice gave the same value - nonce reused")
+	}
+	flip := byte('A')
+	if sealed[len(sealed)-6] == flip {
+		flip = 'B'
+	}
+	tampered := sealed[:len(sealed)-6] + string(flip) + sealed[len(sealed)-5:]
+
+	tests := []struct {
+		name    string
+		kr      *piiKeyring
+		stored  string
+		want    string
+		wantErr bool
+	}{
+		{"round trip", kr, sealed, "Ann Smith", false},
+		{"written before encryption", kr, "Ann Smith", "Ann Smith", false},
+		{"empty", kr, "", "", false},
+		{"key no longer listed", onlyB, sealed, "", true},
+		{"tampered", kr, tampered, "", true},
+		{"malformed", kr, "pii1:a:not base64!", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.kr.decrypt(tt.stored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("decrypt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if empty, _ := kr.encrypt(""); empty != "" {
+		t.Errorf("encrypt(\"\") = %q, want empty", empty)
+	}
+	if kr.emailIndex("Ann@Example.com ") != kr.emailIndex("ann@example.com") {
+		t.Errorf("email index is not normalized")
+	}
+}
+
+func TestRotatePII(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+
+	// A row written before encryption was turned on, then rows sealed with key a
+	usePIIKeys(t, "")
+	now := time.Now()
+	plain := DeckEstimate{Customer: Customer{FirstName: "Bob", LastName: "Jones", Email: "bob@example.com", City: "Vancouver"},
+		SaveDate: now, ExpirationDate: now.Add(24 * time.Hour), ProductType: productDeck}
+	if err := st.InsertEstimate(ctx, &plain, 0, statusSaved); err != nil {
+		t.Fatal(err)
+	}
+	usePIIKeys(t, "a:"+testPIIKey("a"))
+	sealed := DeckEstimate{Customer: Customer{FirstName: "Ann", LastName: "Smith", Email: "ann@example.com", City: "Camas"},
+		SaveDate: now, ExpirationDate: now.Add(24 * time.Hour), ProductType: productDeck}
+	if err := st.InsertEstimate(ctx, &sealed, 0, statusSaved); err != nil {
+		t.Fatal(err)
+	}
+	signed := Acceptance{EstimateID: sealed.EstimateID, SignerName: "Ann Smith", Consent: true, IPAddress: "192.0.2.1",
+		UserAgent: "test", AcceptedAt: now, DocumentText: "Customer: Ann Smith", DocumentHash: hashDocument("Customer: Ann Smith")}
+	if err := st.InsertAcceptance(ctx, &signed, nil); err != nil {
+		t.Fatal(err)
+	}
+	// An accepted change order, then a pending one with no signer yet
+	changed := ChangeOrder{EstimateID: sealed.EstimateID, Reason: "Wider stairs", Status: changePending, CreatedAt: now}
+	if err := st.InsertChangeOrder(ctx, &changed); err != nil {
+		t.Fatal(err)
+	}
+	changed.AcceptDate, changed.SignerName, changed.IPAddress, changed.UserAgent = now, "Ann Smith", "192.0.2.1", "test"
+	if err := st.AcceptChangeOrder(ctx, &changed); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.InsertChangeOrder(ctx, &ChangeOrder{EstimateID: sealed.EstimateID, Reason: "Lights", Status: changePending, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate to key b, keeping a to read with
+	usePIIKeys(t, "b:"+testPIIKey("b")+",a:"+testPIIKey("a"))
+	if _, err := st.RotatePII(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := st.RotatePII(ctx); err != nil || n != 0 {
+		t.Errorf("second rotate changed %d rows (err %v), want 0", n, err)
+	}
+
+	for _, q := range []string{
+		`SELECT first_name FROM customers`, `SELECT email FROM customers`,
+		`SELECT signer_name FROM estimate_acceptances`, `SELECT document_text FROM estimate_acceptances`,
+		`SELECT signer_name FROM change_orders WHERE status = 'accepted'`,
+		`SELECT ip_address FROM change_orders WHERE status = 'accepted'`,
+	} {
+		rows, err := st.db.QueryContext(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var v string
+			if err := rows.Scan(&v); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(v, "pii1:b:") {
+				t.Errorf("%s: %q is not sealed with key b", q, v)
+			}
+		}
+		rows.Close()
+	}
+
+	// Key a can go now
+	usePIIKeys(t, "b:"+testPIIKey("b"))
+	for _, want := range []DeckEstimate{plain, sealed} {
+		got, _, err := st.LoadEstimate(ctx, want.EstimateID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Customer != want.Customer {
+			t.Errorf("estimate %d customer = %+v, want %+v", want.EstimateID, got.Customer, want.Customer)
+		}
+	}
+	a, err := st.LoadAcceptance(ctx, sealed.EstimateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.SignerName != signed.SignerName || a.DocumentText != signed.DocumentText {
+		t.Errorf("acceptance = %q / %q, want %q / %q", a.SignerName, a.DocumentText, signed.SignerName, signed.DocumentText)
+	}
+	if hashDocument(a.DocumentText) != a.DocumentHash {
+		t.Errorf("signed copy no longer matches its hash")
+	}
+	orders, err := st.LoadChangeOrders(ctx, sealed.EstimateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 || orders[0].SignerName != "Ann Smith" || orders[0].IPAddress != "192.0.2.1" || orders[1].SignerName != "" {
+		t.Errorf("change orders = %+v, want Ann Smith's signature on the first only", orders)
+	}
+
+	// Only the encrypted columns of an acceptance can change, and only to ciphertext
+	if _, err := st.db.ExecContext(ctx, `UPDATE estimate_acceptances SET ip_address = '198.51.100.1'`); err == nil {
+		t.Errorf("acceptance ip_address was changed")
+	}
+	if _, err := st.db.ExecContext(ctx, `UPDATE estimate_acceptances SET signer_name = 'Someone Else'`); err == nil {
+		t.Errorf("acceptance signer_name was rewritten in plaintext")
+	}
+	if _, err := st.db.ExecContext(ctx, `UPDATE estimate_acceptances SET document_text = 'Customer: Someone Else'`); err == nil {
+		t.Errorf("acceptance document_text was rewritten in plaintext")
+	}
+	resealed, err := piiKeys.encrypt(signed.SignerName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.db.ExecContext(ctx, `UPDATE estimate_acceptances SET signer_name = $1`, resealed); err != nil {
+		t.Errorf("re-keying the acceptance signer_name: %v", err)
+	}
+	if _, err := st.db.ExecContext(ctx, `DELETE FROM estimate_acceptances`); err == nil {
+		t.Errorf("acceptance was deleted")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// Roles in user_auth.role
+const (
+	roleHomeowner  = "homeowner"
+	roleContractor = "contractor"
+	roleAdmin      = "admin"
+)
+
+// Access is declared per route in main.go by wrapping the handler:
+//
+//	requireRole(h)                        any logged in user
+//	requireRole(h, roleAdmin)             logged in with one of the roles
+//	requireEstimate(h)                    whoever may see the estimate in ?id=
+//	requireAttachment(h)                  whoever may see the attachment in ?id=
+//
+// A visitor who is not logged in is sent to /login, and back to the page after.
+// A logged in user with the wrong role gets the 403 page.  Handlers still check
+// finer grained rules themselves - admin only form operations, for example.
+
+// requireRole lets through logged in users with one of roles, or any logged in
+// user if no roles are given.
+func requireRole(h http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sd, err := GetSession(r, w)
+		if err != nil {
+			http.Error(w, "Session error", http.StatusInternalServerError)
+			return
+		}
+		if !sd.UserAuth.IsAuthenticated {
+			redirectToLogin(w, r, sd)
+			return
+		}
+		if len(roles) > 0 && !slices.Contains(roles, sd.UserAuth.Role) {
+			log.Printf("Forbidden - user %d (%s) - %s %s", sd.UserAuth.ID, sd.UserAuth.Role, r.Method, r.URL.Path)
+			forbiddenHandler(w, r)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// requireEstimate lets through whoever may see the saved estimate named by the
+// id parameter - its owner, an admin or the assigned contractor, or a visitor
+// who is not logged in and has it open in their session (see canAccessEstimate).
+// A logged in user gets a 404 for an estimate they may not see and for one that
+// does not exist alike, and a visitor is sent to log in for both, so estimate
+// IDs cannot be probed.  Without an id the handler works on the session's
+// estimate, which is always allowed.  Conflicting ids are a bad request.
+func requireEstimate(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Every id given - in the query and in a posted form - must be the same,
+		// and the handler gets the one checked here (checkedEstimateID).
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		ids := r.Form["id"]
+		if len(ids) == 0 {
+			h(w, r)
+			return
+		}
+		for _, id := range ids[1:] {
+			if id != ids[0] {
+				log.Printf("Conflicting estimate ids %q - %s %s", ids, r.Method, r.URL.Path)
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+		}
+		estimateID, err := strconv.Atoi(ids[0])
+		if err != nil || estimateID <= 0 {
+			notFoundHandler(w, r)
+			return
+		}
+
+		sd, err := GetSession(r, w)
+		if err != nil {
+			http.Error(w, "Session error", http.StatusInternalServerError)
+			return
+		}
+		ownerID, err := estimateStore.EstimateOwner(r.Context(), estimateID)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Failed to load owner of estimate %d: %v", estimateID, err)
+			http.Error(w, "Database error: Estimate not available.", http.StatusInternalServerError)
+			return
+		}
+		if err == sql.ErrNoRows || !canAccessEstimate(r.Context(), sd, estimateID, ownerID) {
+			if !sd.UserAuth.IsAuthenticated {
+				redirectToLogin(w, r, sd) // Perhaps theirs - they need to log in to see it
+				return
+			}
+			log.Printf("Estimate %d not available to user %d - %s %s", estimateID, sd.UserAuth.ID, r.Method, r.URL.Path)
+			notFoundHandler(w, r)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), estimateIDKey{}, estimateID)))
+	}
+}
+
+// requireAttachment lets through whoever may see the attachment named by the id
+// parameter - anyone who may see its estimate, if the attachment is visible to
+// their role.  Like requireEstimate, a logged in user gets a 404 and a visitor
+// is sent to log in whether or not the attachment exists.  The handler gets the
+// attachment from checkedAttachment.
+func requireAttachment(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		attachmentID, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil || attachmentID <= 0 || attachmentFiles == nil {
+			notFoundHandler(w, r)
+			return
+		}
+
+		sd, err := GetSession(r, w)
+		if err != nil {
+			http.Error(w, "Session error", http.StatusInternalServerError)
+			return
+		}
+		a, err := attachmentStore.LoadAttachment(r.Context(), attachmentID)
+		var ownerID int64
+		if err == nil {
+			ownerID, err = estimateStore.EstimateOwner(r.Context(), a.EstimateID)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Failed to load attachment %d: %v", attachmentID, err)
+			http.Error(w, "Database error: Attachment not available.", http.StatusInternalServerError)
+			return
+		}
+		if err == sql.ErrNoRows || !canAccessEstimate(r.Context(), sd, a.EstimateID, ownerID) || !a.visibleTo(sd.UserAuth.Role) {
+			if !sd.UserAuth.IsAuthenticated {
+				redirectToLogin(w, r, sd)
+				return
+			}
+			log.Printf("Attachment %d not available to user %d - %s %s", attachmentID, sd.UserAuth.ID, r.Method, r.URL.Path)
+			notFoundHandler(w, r)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), attachmentKey{}, a)))
+	}
+}
+
+// attachmentKey is the request context key for the attachment requireAttachment checked.
+type attachmentKey struct{}
+
+// checkedAttachment returns the attachment requireAttachment checked.
+func checkedAttachment(r *http.Request) (Attachment, bool) {
+	a, ok := r.Context().Value(attachmentKey{}).(Attachment)
+	return a, ok
+}
+
+// estimateIDKey is the request context key for the estimate id requireEstimate checked.
+type estimateIDKey struct{}
+
+// checkedEstimateID returns the estimate id requireEstimate checked, or false if
+// the request named none.  Handlers behind requireEstimate read the id here, not
+// from the form, so they work on the estimate that was checked.
+func checkedEstimateID(r *http.Request) (int, bool) {
+	id, ok := r.Context().Value(estimateIDKey{}).(int)
+	return id, ok
+}
+
+// redirectToLogin sends the visitor to log in, then back to this page.
+func redirectToLogin(w http.ResponseWriter, r *http.Request, sd *SessionData) {
+	sd.UserAuth.Message = "Please Login to continue"
+	sd.Save(r, w)
+	http.Redirect(w, r, "/login?rurl="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+}
+
+// forbiddenHandler serves the 403 page.
+func forbiddenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+
+	tmpl := template.Must(template.New("error403.html").
+		Funcs(funcMap).
+		ParseFiles("templates/error403.html", "templates/header.html", "templates/footer.html"))
+
+	data := PageData{PageTitle: "Sorry - No Access"}
+
+	userAuth := getUserAuth(r, w)
+	userAuth.Title = "403 - Forbidden"
+	userAuth.Subtitle = "Sorry, you don't have access to this page."
+	rd := renderData{
+		Page:   &data,
+		Header: &userAuth,
+	}
+	if err := tmpl.ExecuteTemplate(w, "error403.html", rd); err != nil {
+		log.Printf("403 error page failed: %v", err)
+	}
+}
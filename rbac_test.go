@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useMemorySessions makes an in-memory session store the one handlers use until the test ends.
+func useMemorySessions(t *testing.T) {
+	t.Helper()
+	opts, err := sessionCookieOptions(profileDevelopment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, backend, err := newSessionBackend("memory", "", opts, []byte(strings.Repeat("k", 32)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevStore, prevBackend := store, serverSessions
+	store, serverSessions = st, backend
+	t.Cleanup(func() { store, serverSessions = prevStore, prevBackend })
+}
+
+// sessionCookies saves sd as a new session and returns its cookies.
+func sessionCookies(t *testing.T, sd SessionData) []*http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	if err := sd.Save(httptest.NewRequest(http.MethodGet, "/", nil), rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Result().Cookies()
+}
+
+// accessFixture is a set of users and estimates to check access with.
+type accessFixture struct {
+	owner, other, admin, assigned, unassigned int64 // Users
+	owned, others                             int   // Estimates of owner and other
+}
+
+// newAccessFixture adds the users and estimates to st.  The contractor
+// assigned is approved for the owner's estimate.
+func newAccessFixture(t *testing.T, st *SQLStore) accessFixture {
+	t.Helper()
+	ctx := context.Background()
+	newUser := func(email, role string) int64 {
+		t.Helper()
+		id, err := st.CreateUser(ctx, &NewUser{Email: email, Role: role, IsActive: true, EmailVerified: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	newEstimate := func(userID int64) int {
+		t.Helper()
+		e := DeckEstimate{Customer: Customer{FirstName: "Ann", Email: "owner@example.com"}, Length: 10, Width: 10,
+			SaveDate: time.Now(), ExpirationDate: time.Now().Add(24 * time.Hour), ProductType: productDeck}
+		if err := st.InsertEstimate(ctx, &e, userID, statusSaved); err != nil {
+			t.Fatal(err)
+		}
+		return e.EstimateID
+	}
+
+	f := accessFixture{
+		owner:      newUser("owner@example.com", roleHomeowner),
+		other:      newUser("other@example.com", roleHomeowner),
+		admin:      newUser("admin@example.com", roleAdmin),
+		assigned:   newUser("assigned@example.com", roleContractor),
+		unassigned: newUser("unassigned@example.com", roleContractor),
+	}
+	f.owned, f.others = newEstimate(f.owner), newEstimate(f.other)
+	if _, err := st.db.ExecContext(ctx, `INSERT INTO job_assignments (estimate_id, contractor_id, status, requested_at)
+		VALUES ($1, $2, $3, $4)`, f.owned, f.assigned, assignApproved, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// login returns a session logged in as the user.
+func login(id int64, role string) SessionData {
+	return SessionData{UserAuth: UserAuth{ID: id, IsAuthenticated: true, Role: role, LoginAt: time.Now()}}
+}
+
+func TestRequireEstimate(t *testing.T) {
+	f := newAccessFixture(t, newTestStore(t))
+	useMemorySessions(t)
+	ownedID, othersID := strconv.Itoa(f.owned), strconv.Itoa(f.others)
+
+	tests := []struct {
+		name       string
+		sd         SessionData
+		method     string
+		query      string     // ?id=
+		form       url.Values // Posted body
+		wantStatus int
+		wantID     int // Checked id the handler sees, 0 for none
+	}{
+		{"no id - session estimate", SessionData{}, http.MethodGet, "", nil, http.StatusOK, 0},
+		{"owner", login(f.owner, roleHomeowner), http.MethodGet, ownedID, nil, http.StatusOK, f.owned},
+		{"other homeowner", login(f.other, roleHomeowner), http.MethodGet, ownedID, nil, http.StatusNotFound, 0},
+		{"admin", login(f.admin, roleAdmin), http.MethodGet, othersID, nil, http.StatusOK, f.others},
+		{"assigned contractor", login(f.assigned, roleContractor), http.MethodGet, ownedID, nil, http.StatusOK, f.owned},
+		{"unassigned contractor", login(f.unassigned, roleContractor), http.MethodGet, ownedID, nil, http.StatusNotFound, 0},
+		{"open in the session", SessionData{Estimate: DeckEstimate{EstimateID: f.owned}}, http.MethodGet, ownedID, nil, http.StatusOK, f.owned},
+		{"open in another user's session", SessionData{UserAuth: login(f.other, roleHomeowner).UserAuth, Estimate: DeckEstimate{EstimateID: f.owned}},
+			http.MethodGet, ownedID, nil, http.StatusNotFound, 0},
+		{"not logged in", SessionData{}, http.MethodGet, ownedID, nil, http.StatusSeeOther, 0},
+		{"unknown id", login(f.admin, roleAdmin), http.MethodGet, "999999", nil, http.StatusNotFound, 0},
+		{"unknown id, not logged in", SessionData{}, http.MethodGet, "999999", nil, http.StatusSeeOther, 0},
+		{"not a number", login(f.admin, roleAdmin), http.MethodGet, "abc", nil, http.StatusNotFound, 0},
+		{"posted id", login(f.owner, roleHomeowner), http.MethodPost, "", url.Values{"id": {ownedID}}, http.StatusOK, f.owned},
+		{"posted id of another's", login(f.owner, roleHomeowner), http.MethodPost, "", url.Values{"id": {othersID}}, http.StatusNotFound, 0},
+		{"same id in query and form", login(f.owner, roleHomeowner), http.MethodPost, ownedID, url.Values{"id": {ownedID}}, http.StatusOK, f.owned},
+		{"query id checked, form id another's", login(f.owner, roleHomeowner), http.MethodPost, ownedID, url.Values{"id": {othersID}}, http.StatusBadRequest, 0},
+		{"two query ids", login(f.owner, roleHomeowner), http.MethodGet, ownedID + "&id=" + othersID, nil, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotID int
+			h := requireEstimate(func(w http.ResponseWriter, r *http.Request) {
+				gotID, _ = checkedEstimateID(r)
+			})
+
+			target := "/estimate/clone"
+			if tt.query != "" {
+				target += "?id=" + tt.query
+			}
+			req := httptest.NewRequest(tt.method, target, strings.NewReader(tt.form.Encode()))
+			if tt.form != nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			for _, c := range sessionCookies(t, tt.sd) {
+				req.AddCookie(c)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gotID != tt.wantID {
+				t.Errorf("handler saw estimate %d, want %d", gotID, tt.wantID)
+			}
+			if tt.wantStatus == http.StatusSeeOther && !strings.HasPrefix(rec.Header().Get("Location"), "/login?rurl=") {
+				t.Errorf("redirected to %q, want /login", rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	newTestStore(t)
+	useMemorySessions(t)
+
+	tests := []struct {
+		name       string
+		sd         SessionData
+		roles      []string
+		wantStatus int
+	}{
+		{"not logged in", SessionData{}, nil, http.StatusSeeOther},
+		{"any logged in user", SessionData{UserAuth: UserAuth{ID: 1, IsAuthenticated: true, Role: roleHomeowner}}, nil, http.StatusOK},
+		{"role allowed", SessionData{UserAuth: UserAuth{ID: 1, IsAuthenticated: true, Role: roleAdmin}}, []string{roleAdmin}, http.StatusOK},
+		{"role not allowed", SessionData{UserAuth: UserAuth{ID: 1, IsAuthenticated: true, Role: roleHomeowner}}, []string{roleAdmin}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := requireRole(func(w http.ResponseWriter, r *http.Request) {}, tt.roles...)
+			req := httptest.NewRequest(http.MethodGet, "/jobs", nil)
+			for _, c := range sessionCookies(t, tt.sd) {
+				req.AddCookie(c)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRequireAttachment(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	f := newAccessFixture(t, st)
+	useMemorySessions(t)
+	if attachmentFiles == nil {
+		t.Skip("attachment storage is not available")
+	}
+
+	newAttachment := func(visibility string) string {
+		t.Helper()
+		a := Attachment{EstimateID: f.owned, UserID: f.owner, Filename: "deck.pdf", ContentType: "application/pdf",
+			StorageKey: "estimates/" + visibility + ".pdf", Visibility: visibility, UploadedAt: time.Now()}
+		if err := st.InsertAttachment(ctx, &a); err != nil {
+			t.Fatal(err)
+		}
+		return strconv.FormatInt(a.AttachmentID, 10)
+	}
+	shared, staff := newAttachment(visibilityCustomer), newAttachment(visibilityStaff)
+
+	tests := []struct {
+		name       string
+		sd         SessionData
+		id         string
+		wantStatus int
+	}{
+		{"owner", login(f.owner, roleHomeowner), shared, http.StatusOK},
+		{"owner, staff only", login(f.owner, roleHomeowner), staff, http.StatusNotFound},
+		{"assigned contractor, staff only", login(f.assigned, roleContractor), staff, http.StatusOK},
+		{"unassigned contractor", login(f.unassigned, roleContractor), shared, http.StatusNotFound},
+		{"other homeowner", login(f.other, roleHomeowner), shared, http.StatusNotFound},
+		{"admin", login(f.admin, roleAdmin), staff, http.StatusOK},
+		{"unknown id", login(f.admin, roleAdmin), "999999", http.StatusNotFound},
+		{"not logged in", SessionData{}, shared, http.StatusSeeOther},
+		{"unknown id, not logged in", SessionData{}, "999999", http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Attachment
+			h := requireAttachment(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = checkedAttachment(r)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/attachment?id="+tt.id, nil)
+			for _, c := range sessionCookies(t, tt.sd) {
+				req.AddCookie(c)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if want := tt.wantStatus == http.StatusOK; (strconv.FormatInt(got.AttachmentID, 10) == tt.id) != want {
+				t.Errorf("handler saw attachment %d, want it to see %s: %v", got.AttachmentID, tt.id, want)
+			}
+		})
+	}
+}
+
+// A user who logs in on a browser another account left signed in does not get
+// the estimate that account had open.
+func TestLoginForgetsPreviousUser(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	useMemorySessions(t)
+
+	hash, err := hashPassword("password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newUser := func(email string) int64 {
+		t.Helper()
+		id, err := st.CreateUser(ctx, &NewUser{Email: email, PasswordHash: hash, Role: roleHomeowner, IsActive: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	ann := newUser("ann@example.com")
+	newUser("bob@example.com")
+	e := DeckEstimate{Customer: Customer{FirstName: "Ann", Email: "ann@example.com"}, Length: 10, Width: 10,
+		SaveDate: time.Now(), ExpirationDate: time.Now().Add(24 * time.Hour), ProductType: productDeck}
+	if err := st.InsertEstimate(ctx, &e, ann, statusSaved); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		email      string
+		wantStatus int
+		wantKept   bool // Session still has the estimate and customer
+	}{
+		{"another user logs in", "bob@example.com", http.StatusNotFound, false},
+		{"same user logs in again", "ann@example.com", http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sd := SessionData{
+				UserAuth: UserAuth{ID: ann, IsAuthenticated: true, Role: roleHomeowner, LoginAt: time.Now()},
+				Estimate: e,
+				Customer: e.Customer,
+			}
+			form := url.Values{"email": {tt.email}, "password": {"password1"}}
+			req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for _, c := range sessionCookies(t, sd) {
+				req.AddCookie(c)
+			}
+			rec := httptest.NewRecorder()
+			loginHandler(rec, req)
+			if rec.Code != http.StatusSeeOther {
+				t.Fatalf("login status = %d, want %d", rec.Code, http.StatusSeeOther)
+			}
+
+			req = httptest.NewRequest(http.MethodGet, "/estimate/signed?id="+strconv.Itoa(e.EstimateID), nil)
+			for _, c := range rec.Result().Cookies() {
+				req.AddCookie(c)
+			}
+			rec = httptest.NewRecorder()
+			requireEstimate(func(w http.ResponseWriter, r *http.Request) {})(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			after, err := GetSession(req, httptest.NewRecorder())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := after.Estimate.EstimateID == e.EstimateID && after.Customer == e.Customer; kept != tt.wantKept {
+				t.Errorf("session estimate %d, customer %+v kept = %v, want %v", after.Estimate.EstimateID, after.Customer, kept, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestCloneEstimate(t *testing.T) {
+	f := newAccessFixture(t, newTestStore(t))
+	useMemorySessions(t)
+
+	tests := []struct {
+		name       string
+		sd         SessionData
+		wantStatus int
+	}{
+		{"owner", login(f.owner, roleHomeowner), http.StatusSeeOther},
+		{"admin", login(f.admin, roleAdmin), http.StatusSeeOther},
+		{"assigned contractor", login(f.assigned, roleContractor), http.StatusSeeOther},
+		{"other homeowner", login(f.other, roleHomeowner), http.StatusNotFound},
+		{"unassigned contractor", login(f.unassigned, roleContractor), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"id": {strconv.Itoa(f.owned)}}
+			req := httptest.NewRequest(http.MethodPost, "/estimate/clone", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for _, c := range sessionCookies(t, tt.sd) {
+				req.AddCookie(c)
+			}
+			rec := httptest.NewRecorder()
+			requireEstimate(cloneHandler)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusSeeOther && rec.Header().Get("Location") != "/estimate" {
+				t.Errorf("redirected to %q, want the new draft", rec.Header().Get("Location"))
+			}
+		})
+	}
+}
@@ -6,7 +6,8 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/sessions"
 )
@@ -18,49 +19,131 @@ type SessionData struct {
 	UserAuth UserAuth
 }
 
-// Session store - in-memory for now, single secret key
-var sessionName = "colout2-session3"
-var secretKey []byte
-var store *sessions.FilesystemStore
-var sessionStoreDir = "./sessions" // or "./sessions" for local dev
+// Session store - see sessionstore.go for the backends
+var sessionName = "colout2-session3" // Struct changes no longer need a new name - see sessiondoc.go
+var store sessions.Store
+var serverSessions sessionBackend  // Where store keeps them - see sessionsweep.go
+var sessionStoreDir = "./sessions" // SESSION_DIR overrides
 
-func init() {
+// openSessionStore sets up the session store named by SESSION_BACKEND, with
+// cookie flags for the profile (production or development).  The database
+// backend needs openStores to have run.
+func openSessionStore(profile string) error {
 	log.Printf("Initializing Session Store")
 
-	// Secret key (at least 32 bytes) - load from env var in production
-	secretKey = []byte(os.Getenv("SESSION_SECRET")) // e.g., generate with crypto/rand
-	if len(secretKey) == 0 {
-		log.Fatal("SESSION_SECRET env var is required")
+	keyPairs, err := sessionKeyPairs()
+	if err != nil {
+		return err
+	}
+	opts, err := sessionCookieOptions(profile)
+	if err != nil {
+		return err
 	}
 
-	// store = sessions.NewCookieStore([]byte("super-secret-key-12345"))
-	// In your initialization (e.g., main.go)
-	if err := os.MkdirAll(sessionStoreDir, 0755); err != nil {
-		log.Fatalf("Failed to create session directory: %v", err)
+	backend := os.Getenv("SESSION_BACKEND")
+	if backend == "" {
+		backend = "filesystem"
 	}
+	if backend == "filesystem" {
+		if dir := os.Getenv("SESSION_DIR"); dir != "" {
+			sessionStoreDir = dir
+		}
+		if err := os.MkdirAll(sessionStoreDir, 0755); err != nil {
+			return fmt.Errorf("failed to create session directory: %v", err)
+		}
 
-	// Test write to confirm directory is usable
-	testFile := filepath.Join(sessionStoreDir, "init-test.txt") // Use filepath.Join for cross-platform safety
-	f, err := os.Create(testFile)
+		// Test write to confirm directory is usable
+		f, err := os.CreateTemp(sessionStoreDir, "write-test-*")
+		if err != nil {
+			return fmt.Errorf("session directory %s is not writable: %v", sessionStoreDir, err)
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	st, kept, err := newSessionBackend(backend, sessionStoreDir, opts, keyPairs...)
 	if err != nil {
-		log.Fatalf("Session directory %s is not writable: %v", sessionStoreDir, err)
+		return err
 	}
-	fmt.Fprintln(f, "Session dir test - writable on startup")
-	f.Close()
-	log.Printf("Session directory test file created at: %s", testFile)
+	store, serverSessions = st, kept
+	log.Printf("Session backend: %s, %d keys, Secure=%v SameSite=%v", backend, len(keyPairs)/2, opts.Secure, opts.SameSite)
+	return nil
+}
 
-	store = sessions.NewFilesystemStore(sessionStoreDir, secretKey)
+// sessionKeyPairs reads the session keys, newest first, as signing and encryption
+// key pairs for securecookie.
+//
+//	SESSION_SECRETS  signingKey[:encryptionKey],...  The first pair signs (and
+//	                 encrypts) new sessions; the rest only read existing ones, so
+//	                 a key can be retired without logging everyone out.  Signing
+//	                 keys are 32+ bytes, encryption keys 16, 24 or 32 bytes.
+//	SESSION_SECRET   a single signing key.  With SESSION_SECRETS it is tried last,
+//	                 so moving to SESSION_SECRETS keeps existing sessions.
+func sessionKeyPairs() ([][]byte, error) {
+	var pairs [][]byte
+	for _, entry := range strings.Split(os.Getenv("SESSION_SECRETS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		signing, encryption, _ := strings.Cut(entry, ":")
+		if len(signing) < 32 {
+			return nil, fmt.Errorf("SESSION_SECRETS: signing keys must be at least 32 bytes")
+		}
+		var block []byte
+		if encryption != "" {
+			if n := len(encryption); n != 16 && n != 24 && n != 32 {
+				return nil, fmt.Errorf("SESSION_SECRETS: encryption keys must be 16, 24 or 32 bytes")
+			}
+			block = []byte(encryption)
+		}
+		pairs = append(pairs, []byte(signing), block)
+	}
 
-	if store == nil {
-		log.Panic("Init!  Session store is nil!")
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		pairs = append(pairs, []byte(secret), nil)
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("SESSION_SECRETS or SESSION_SECRET env var is required")
 	}
+	return pairs, nil
+}
 
-	store.Options = &sessions.Options{
+// sessionCookieOptions returns the session cookie flags for the profile.
+//
+//	production   Secure, HttpOnly, SameSite=Lax
+//	development  HttpOnly, SameSite=Lax - plain http on localhost
+//
+// SameSite is Lax, not Strict, so the cookie comes back with Google's redirect
+// to /auth/google/callback.  SESSION_COOKIE_SECURE, SESSION_COOKIE_SAMESITE
+// (lax, strict or none) and SESSION_COOKIE_DOMAIN override the profile.
+func sessionCookieOptions(profile string) (*sessions.Options, error) {
+	opts := &sessions.Options{
 		Path:     "/",
 		MaxAge:   86400 * 7, // 7 days
 		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
+		Secure:   profile != profileDevelopment,
+		SameSite: http.SameSiteLaxMode,
+		Domain:   os.Getenv("SESSION_COOKIE_DOMAIN"),
+	}
+	if env := os.Getenv("SESSION_COOKIE_SECURE"); env != "" {
+		secure, err := strconv.ParseBool(env)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SESSION_COOKIE_SECURE %q", env)
+		}
+		opts.Secure = secure
+	}
+	switch env := strings.ToLower(os.Getenv("SESSION_COOKIE_SAMESITE")); env {
+	case "", "lax":
+	case "strict":
+		opts.SameSite = http.SameSiteStrictMode
+	case "none":
+		opts.SameSite = http.SameSiteNoneMode
+		opts.Secure = true // Browsers drop SameSite=None cookies that are not Secure
+	default:
+		return nil, fmt.Errorf("invalid SESSION_COOKIE_SAMESITE %q - use lax, strict or none", env)
 	}
+	return opts, nil
 }
 
 // This is used to test / debug the session data
@@ -104,29 +187,25 @@ func GetSession(r *http.Request, w http.ResponseWriter) (*SessionData, error) {
 		// Clear any invalid/old cookie and force a fresh session
 		session.Options.MaxAge = -1 // Deletes the cookie immediately
 		session.Save(r, w)          // Sends deletion header
-		log.Printf("Reset old/invalid session for new FilesystemStore")
+		log.Printf("Reset old/invalid session")
 
 		return &SessionData{}, err
 	}
 
-	// Extract session data
+	// Extract session data - see sessiondoc.go
 	data := SessionData{}
-	if est, ok := session.Values["estimate"].(DeckEstimate); ok {
-		data.Estimate = est
-	} else {
-		data.Estimate = DeckEstimate{}
-	}
-	if cust, ok := session.Values["customer"].(Customer); ok {
-		data.Customer = cust
-	} else {
-		data.Customer = Customer{}
-	}
-	if ua, ok := session.Values["userauth"].(UserAuth); ok {
-		data.UserAuth = ua
-	} else {
-		data.UserAuth = UserAuth{}
+	if doc, ok := session.Values[sessionDocKey].(string); ok {
+		data, err = decodeSessionDoc(doc)
+		if err != nil {
+			log.Printf("Session document error, keeping what decoded: %v", err)
+		}
+		signOutRevokedSession(r.Context(), &data)
+		return &data, nil
 	}
 
+	// Sessions saved before the JSON document - rewritten as one on the next Save
+	data = decodeLegacySession(session.Values)
+	signOutRevokedSession(r.Context(), &data)
 	return &data, nil
 }
 
@@ -139,11 +218,17 @@ func (s *SessionData) Save(r *http.Request, w http.ResponseWriter) error {
 		return err
 	}
 
-	session.Values["estimate"] = s.Estimate
-	session.Values["customer"] = s.Customer
-	session.Values["userauth"] = s.UserAuth
+	doc, err := encodeSessionDoc(s)
+	if err != nil {
+		log.Printf("Session encode error: %v", err)
+		return err
+	}
+	session.Values[sessionDocKey] = doc
+	delete(session.Values, "estimate")
+	delete(session.Values, "customer")
+	delete(session.Values, "userauth")
 
-	log.Printf("Saving User Session for %s", s.UserAuth.Email)
+	log.Printf("Saving User Session for %s", redactEmail(s.UserAuth.Email))
 
 	if err := session.Save(r, w); err != nil {
 		log.Printf("Session save error: %v", err)
@@ -154,6 +239,36 @@ func (s *SessionData) Save(r *http.Request, w http.ResponseWriter) error {
 
 }
 
+// Regenerate saves the session under a new ID and removes the old one, so an ID
+// seen before a login (or planted by someone else) is no use after it.  Call it
+// instead of Save whenever UserAuth changes who is logged in or their role.
+func (s *SessionData) Regenerate(r *http.Request, w http.ResponseWriter) error {
+	session, err := store.Get(r, sessionName)
+	if err != nil {
+		log.Printf("Session get error: %v", err)
+		return err
+	}
+	if err := newSessionID(r, session); err != nil {
+		return err
+	}
+	return s.Save(r, w) // Same session - store.Get caches it for the request
+}
+
+// newSessionID removes the server side copy of a session and clears its ID, so the
+// next save issues a new one.
+func newSessionID(r *http.Request, session *sessions.Session) error {
+	if session.ID != "" {
+		if err := serverSessions.DeleteSession(r.Context(), session.ID); err != nil {
+			log.Printf("Session delete error: %v", err)
+			return err
+		}
+	}
+	session.ID = ""
+	session.IsNew = true
+	return nil
+}
+
+// Delete empties the session - on logout - and moves it to a new ID.
 func (s *SessionData) Delete(r *http.Request, w http.ResponseWriter) error {
 	// Get session
 	session, err := store.Get(r, sessionName)
@@ -163,9 +278,13 @@ func (s *SessionData) Delete(r *http.Request, w http.ResponseWriter) error {
 	}
 
 	// Reset session by clearing values
+	delete(session.Values, sessionDocKey)
 	delete(session.Values, "estimate")
 	delete(session.Values, "customer")
 	delete(session.Values, "userauth")
+	if err := newSessionID(r, session); err != nil {
+		return err
+	}
 
 	if err := session.Save(r, w); err != nil {
 		log.Printf("Session save error: %v", err)
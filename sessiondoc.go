@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Session data is stored as one versioned JSON document under sessionDocKey,
+// rather than as gob encoded structs.  JSON ignores fields it does not know and
+// leaves missing ones zero, so adding a field to DeckEstimate, Customer or
+// UserAuth needs nothing here and in-progress estimates survive the deploy.
+//
+// Bump sessionDocVersion, and add a migration, only when old documents would
+// lose data under the new structs - a renamed, moved or retyped field.
+
+// sessionDocVersion is the version written by this build.
+const sessionDocVersion = 1
+
+// sessionDocKey is the session value holding the document.
+const sessionDocKey = "doc"
+
+// sessionDoc is SessionData as stored.
+type sessionDoc struct {
+	Version  int          `json:"version"`
+	Estimate DeckEstimate `json:"estimate"`
+	Customer Customer     `json:"customer"`
+	UserAuth UserAuth     `json:"user_auth"`
+}
+
+// sessionMigrations upgrade a document from one version to the next:
+// sessionMigrations[v] turns version v into v+1, working on the raw JSON so it
+// can see fields the current structs no longer have.  For example:
+//
+//	1: func(doc map[string]any) error {
+//		est, _ := doc["estimate"].(map[string]any)
+//		if est != nil {
+//			est["Description"] = est["Desc"] // Desc renamed
+//		}
+//		return nil
+//	},
+var sessionMigrations = map[int]func(doc map[string]any) error{}
+
+// encodeSessionDoc returns the session document for s.
+func encodeSessionDoc(s *SessionData) (string, error) {
+	b, err := json.Marshal(sessionDoc{
+		Version:  sessionDocVersion,
+		Estimate: s.Estimate,
+		Customer: s.Customer,
+		UserAuth: s.UserAuth,
+	})
+	return string(b), err
+}
+
+// decodeSessionDoc migrates a stored document to the current version and decodes it.
+// On a type mismatch it returns the fields that did decode along with the error.
+func decodeSessionDoc(stored string) (SessionData, error) {
+	var raw map[string]any
+	if err := json.Unmarshal([]byte(stored), &raw); err != nil {
+		return SessionData{}, err
+	}
+	version, _ := raw["version"].(float64)
+	if int(version) > sessionDocVersion {
+		// Written by a newer build, after a rollback.  Its fields may mean
+		// something else now, so start again rather than guess.
+		return SessionData{}, fmt.Errorf("session document version %d is newer than %d", int(version), sessionDocVersion)
+	}
+	for v := int(version); v < sessionDocVersion; v++ {
+		if migrate := sessionMigrations[v]; migrate != nil {
+			if err := migrate(raw); err != nil {
+				return SessionData{}, fmt.Errorf("session document version %d: %w", v, err)
+			}
+		}
+	}
+	raw["version"] = sessionDocVersion
+
+	// Back through JSON into the structs
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return SessionData{}, err
+	}
+	var doc sessionDoc
+	err = json.Unmarshal(b, &doc)
+	return SessionData{Estimate: doc.Estimate, Customer: doc.Customer, UserAuth: doc.UserAuth}, err
+}
+
+// decodeLegacySession reads a session saved before the JSON document, when each
+// struct was its own gob encoded session value.
+func decodeLegacySession(values map[any]any) SessionData {
+	var data SessionData
+	if est, ok := values["estimate"].(DeckEstimate); ok {
+		data.Estimate = est
+	}
+	if cust, ok := values["customer"].(Customer); ok {
+		data.Customer = cust
+	}
+	if ua, ok := values["userauth"].(UserAuth); ok {
+		data.UserAuth = ua
+	}
+	return data
+}
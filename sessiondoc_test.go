@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testSessionData is a session with something in every part of the document.
+func testSessionData() SessionData {
+	at := time.Date(2026, 5, 1, 9, 30, 0, 0, time.UTC)
+	return SessionData{
+		Estimate: DeckEstimate{Desc: "Back deck", ProductType: productDeck, Length: 12, Width: 10,
+			Material: "Cedar", TotalCost: 14500, SaveDate: at},
+		Customer: Customer{FirstName: "Ann", LastName: "Smith", Email: "ann@example.com", City: "Camas"},
+		UserAuth: UserAuth{ID: 7, IsAuthenticated: true, Email: "ann@example.com", Role: roleHomeowner, LoginAt: at},
+	}
+}
+
+func TestSessionDocRoundTrip(t *testing.T) {
+	want := testSessionData()
+	doc, err := encodeSessionDoc(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeSessionDoc(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+}
+
+func TestSessionDocMigration(t *testing.T) {
+	saved := sessionMigrations
+	t.Cleanup(func() { sessionMigrations = saved })
+	fail := errors.New("bad document")
+	sessionMigrations = map[int]func(doc map[string]any) error{
+		0: func(doc map[string]any) error {
+			est, _ := doc["estimate"].(map[string]any)
+			if est == nil {
+				return fail
+			}
+			est["Desc"] = est["Notes"] // Notes renamed
+			return nil
+		},
+	}
+
+	got, err := decodeSessionDoc(`{"estimate": {"Notes": "Back deck", "Length": 12}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Estimate.Desc != "Back deck" || got.Estimate.Length != 12 {
+		t.Errorf("estimate = %q, %v, want the renamed field moved", got.Estimate.Desc, got.Estimate.Length)
+	}
+	// Current documents are not migrated again
+	if got, err := decodeSessionDoc(`{"version": 1, "estimate": {"Notes": "Back deck"}}`); err != nil || got.Estimate.Desc != "" {
+		t.Errorf("current document migrated: %q, %v", got.Estimate.Desc, err)
+	}
+	if _, err := decodeSessionDoc(`{"customer": {}}`); !errors.Is(err, fail) {
+		t.Errorf("failed migration returned %v, want %v", err, fail)
+	}
+}
+
+func TestSessionDocNewerVersion(t *testing.T) {
+	got, err := decodeSessionDoc(`{"version": 2, "user_auth": {"ID": 7, "IsAuthenticated": true}}`)
+	if err == nil {
+		t.Errorf("document from a newer build decoded")
+	}
+	if got.UserAuth.IsAuthenticated {
+		t.Errorf("document from a newer build kept its login")
+	}
+}
+
+func TestDecodeLegacySession(t *testing.T) {
+	want := testSessionData()
+
+	// As securecookie stored them before the JSON document
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(map[any]any{
+		"estimate": want.Estimate, "customer": want.Customer, "userauth": want.UserAuth,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var values map[any]any
+	if err := gob.NewDecoder(&buf).Decode(&values); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := decodeLegacySession(values); !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Sessions are kept server side; the cookie only carries the signed session ID.
+// SESSION_BACKEND picks where:
+//
+//	filesystem  files in SESSION_DIR (./sessions) - the default.  Each Cloud Run
+//	            instance has its own disk, so only for a single instance.
+//	database    the sessions table, on the same pool as the stores.
+//	memory      in process, lost on restart - for tests and local runs.
+
+// errSessionNotFound is returned by a SessionStore for a missing or expired session.
+var errSessionNotFound = errors.New("session not found")
+
+var sessionIDEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// backendStore is a sessions.Store that keeps session values in a SessionStore.
+// Values are encoded with the same codecs as the cookie, so they are signed
+// (and encrypted, with an encryption key) wherever they are kept.
+type backendStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	backend SessionStore
+}
+
+func newBackendStore(backend SessionStore, opts *sessions.Options, keyPairs ...[]byte) *backendStore {
+	s := &backendStore{
+		Codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		Options: opts,
+		backend: backend,
+	}
+	for _, c := range s.Codecs {
+		if sc, ok := c.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(opts.MaxAge) // Reject values older than the session
+			sc.MaxLength(0)        // Values are not in the cookie, so no 4096 byte limit
+		}
+	}
+	return s
+}
+
+// Get returns the named session for the request, cached for the request.
+func (s *backendStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named in the request's cookie, or starts a new one.
+// As with the gorilla stores, a session is returned even when err is set.
+func (s *backendStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, errCookie := r.Cookie(name)
+	if errCookie != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+	data, err := s.backend.LoadSession(r.Context(), session.ID)
+	if err == errSessionNotFound {
+		session.ID = "" // Expired or removed - start again under a new ID, never one from a cookie
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	if err := securecookie.DecodeMulti(name, string(data), &session.Values, s.Codecs...); err != nil {
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save writes the session and sets its cookie.  MaxAge <= 0 deletes it.
+func (s *backendStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := s.backend.DeleteSession(r.Context(), session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = sessionIDEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	}
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+	if err := s.backend.SaveSession(r.Context(), session.ID, []byte(data), expires); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// newSessionBackend returns the gorilla store for SESSION_BACKEND, and where it
+// keeps sessions.
+func newSessionBackend(backend, dir string, opts *sessions.Options, keyPairs ...[]byte) (sessions.Store, sessionBackend, error) {
+	switch backend {
+	case "filesystem":
+		fs := sessions.NewFilesystemStore(dir, keyPairs...)
+		fs.Options = opts
+		fs.MaxAge(opts.MaxAge)
+		fs.MaxLength(0) // Kept in files, not the cookie - an estimate is more than 4096 bytes
+		return fs, fsSessionFiles{dir: dir, maxAge: time.Duration(opts.MaxAge) * time.Second}, nil
+	case "database":
+		if sessionStore == nil {
+			return nil, nil, errors.New("database sessions need the database - call openStores first")
+		}
+		return newBackendStore(sessionStore, opts, keyPairs...), sessionStore, nil
+	case "memory":
+		mem := newMemorySessionStore()
+		return newBackendStore(mem, opts, keyPairs...), mem, nil
+	}
+	return nil, nil, fmt.Errorf("unknown SESSION_BACKEND %q - use filesystem, database or memory", backend)
+}
+
+// memorySessionStore keeps sessions in a map.
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: map[string]memorySession{}}
+}
+
+func (m *memorySessionStore) LoadSession(ctx context.Context, id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || !time.Now().Before(s.expires) {
+		delete(m.sessions, id)
+		return nil, errSessionNotFound
+	}
+	return s.data, nil
+}
+
+func (m *memorySessionStore) SaveSession(ctx context.Context, id string, data []byte, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[id] = memorySession{data: append([]byte(nil), data...), expires: expires}
+	return nil
+}
+
+func (m *memorySessionStore) DeleteSession(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+// LoadSession returns a session's encoded values, or errSessionNotFound.
+func (s *SQLStore) LoadSession(ctx context.Context, id string) ([]byte, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM sessions WHERE session_id = $1 AND expires_at > $2`,
+		id, time.Now()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, errSessionNotFound
+	}
+	return []byte(data), err
+}
+
+// SaveSession adds or replaces a session.
+func (s *SQLStore) SaveSession(ctx context.Context, id string, data []byte, expires time.Time) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO sessions (session_id, data, expires_at, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (session_id) DO UPDATE SET data = excluded.data, expires_at = excluded.expires_at,
+			updated_at = excluded.updated_at`,
+		id, string(data), expires, time.Now())
+	return err
+}
+
+// DeleteSession removes a session.  Deleting a missing session is not an error.
+func (s *SQLStore) DeleteSession(ctx context.Context, id string) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE session_id = $1`, id)
+	return err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultSessionSweep is how often expired sessions are removed unless
+// SESSION_SWEEP_INTERVAL is set.
+const defaultSessionSweep = time.Hour
+
+// sessionStats is what is left in a session backend after a sweep.
+type sessionStats struct {
+	Removed  int64 // Expired sessions removed by this sweep
+	Sessions int64
+	Bytes    int64
+}
+
+// sessionBackend is where a session store keeps sessions server side.  It removes
+// a session when its ID is rotated, and expired sessions in the sweep.
+type sessionBackend interface {
+	DeleteSession(ctx context.Context, id string) error
+	SweepSessions(ctx context.Context, now time.Time) (sessionStats, error)
+}
+
+// Session metrics, published at /debug/vars under "sessions".
+var (
+	sessionMetrics      = expvar.NewMap("sessions")
+	sessionCount        = new(expvar.Int)
+	sessionBytes        = new(expvar.Int)
+	sessionRemoved      = new(expvar.Int) // Total since start
+	sessionSweeps       = new(expvar.Int)
+	sessionSweepErrors  = new(expvar.Int)
+	sessionLastSweep    = new(expvar.String)
+	sessionSweepSeconds = new(expvar.Float) // Seconds, last sweep
+)
+
+func init() {
+	sessionMetrics.Set("count", sessionCount)
+	sessionMetrics.Set("bytes", sessionBytes)
+	sessionMetrics.Set("removed", sessionRemoved)
+	sessionMetrics.Set("sweeps", sessionSweeps)
+	sessionMetrics.Set("sweep_errors", sessionSweepErrors)
+	sessionMetrics.Set("last_sweep", sessionLastSweep)
+	sessionMetrics.Set("last_sweep_seconds", sessionSweepSeconds)
+}
+
+// startSessionSweeper removes expired sessions in the background until the process exits.
+func startSessionSweeper(sweeper sessionBackend) {
+	interval := envDuration("SESSION_SWEEP_INTERVAL", defaultSessionSweep)
+	log.Printf("Session sweeper running every %v", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runSessionSweep(context.Background(), sweeper, time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// runSessionSweep does one sweep and updates the metrics.
+func runSessionSweep(ctx context.Context, sweeper sessionBackend, now time.Time) {
+	start := time.Now()
+	stats, err := sweeper.SweepSessions(ctx, now)
+	sessionSweeps.Add(1)
+	sessionLastSweep.Set(now.UTC().Format(time.RFC3339))
+	sessionSweepSeconds.Set(time.Since(start).Seconds())
+	sessionRemoved.Add(stats.Removed)
+	if err != nil {
+		sessionSweepErrors.Add(1)
+		log.Printf("Session sweep failed: %v", err)
+		return
+	}
+	sessionCount.Set(stats.Sessions)
+	sessionBytes.Set(stats.Bytes)
+	if stats.Removed > 0 {
+		log.Printf("Session sweep - %d expired sessions removed, %d left (%d bytes)",
+			stats.Removed, stats.Sessions, stats.Bytes)
+	}
+}
+
+// fsSessionFiles is the session files of the filesystem store.  The store
+// rewrites a session's file on every save, so a file not written for longer than
+// maxAge has expired.
+type fsSessionFiles struct {
+	dir    string
+	maxAge time.Duration
+}
+
+// gorilla's FilesystemStore names its files session_<id>.
+const fsSessionPrefix = "session_"
+
+func (f fsSessionFiles) DeleteSession(ctx context.Context, id string) error {
+	err := os.Remove(filepath.Join(f.dir, fsSessionPrefix+id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f fsSessionFiles) SweepSessions(ctx context.Context, now time.Time) (sessionStats, error) {
+	var stats sessionStats
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return stats, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), fsSessionPrefix) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue // Removed since ReadDir
+		}
+		if now.Sub(info.ModTime()) > f.maxAge {
+			if err := os.Remove(filepath.Join(f.dir, e.Name())); err != nil && !os.IsNotExist(err) {
+				return stats, err
+			}
+			stats.Removed++
+			continue
+		}
+		stats.Sessions++
+		stats.Bytes += info.Size()
+	}
+	return stats, nil
+}
+
+func (m *memorySessionStore) SweepSessions(ctx context.Context, now time.Time) (sessionStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var stats sessionStats
+	for id, s := range m.sessions {
+		if !now.Before(s.expires) {
+			delete(m.sessions, id)
+			stats.Removed++
+			continue
+		}
+		stats.Sessions++
+		stats.Bytes += int64(len(s.data))
+	}
+	return stats, nil
+}
+
+// SweepSessions deletes expired sessions and counts the rest.
+func (s *SQLStore) SweepSessions(ctx context.Context, now time.Time) (sessionStats, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	var stats sessionStats
+	res, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= $1`, now)
+	if err != nil {
+		return stats, err
+	}
+	if stats.Removed, err = res.RowsAffected(); err != nil {
+		return stats, err
+	}
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(LENGTH(data)), 0) FROM sessions`).
+		Scan(&stats.Sessions, &stats.Bytes)
+	return stats, err
+}
+
+// debugVarsHandler - GET /debug/vars - expvar metrics, admin only (see main.go).
+func debugVarsHandler(w http.ResponseWriter, r *http.Request) {
+	expvar.Handler().ServeHTTP(w, r)
+}
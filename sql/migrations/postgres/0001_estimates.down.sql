@@ -0,0 +1,4 @@
+-- 0001_estimates.down.sql
+
+DROP TABLE IF EXISTS estimates;
+DROP FUNCTION IF EXISTS update_updated_at_column();
@@ -1,4 +1,4 @@
--- estimates.sql
+-- 0001_estimates.up.sql
 -- Create the estimates table for Columbia Outdoor deck/patio calculator submissions
 
 CREATE TABLE IF NOT EXISTS estimates (
@@ -42,8 +42,8 @@ CREATE INDEX IF NOT EXISTS idx_estimates_created_at  ON estimates(created_at DES
 CREATE INDEX IF NOT EXISTS idx_estimates_total_cost  ON estimates(total_cost);
 CREATE INDEX IF NOT EXISTS idx_estimates_state       ON estimates(state);
 
--- This will reset back to 1000
-ALTER SEQUENCE estimates_estimate_id_seq RESTART WITH 1000;
+-- Estimate numbers start at 1000.  Only on an empty table, so running this again is safe.
+SELECT setval('estimates_estimate_id_seq', 1000, false) WHERE NOT EXISTS (SELECT 1 FROM estimates);
 
 -- Optional: Add a simple trigger to auto-update updated_at
 CREATE OR REPLACE FUNCTION update_updated_at_column()
@@ -54,7 +54,8 @@ BEGIN
 END;
 $$ language 'plpgsql';
 
+DROP TRIGGER IF EXISTS trigger_update_estimates_timestamp ON estimates;
 CREATE TRIGGER trigger_update_estimates_timestamp
     BEFORE UPDATE ON estimates
     FOR EACH ROW
-    EXECUTE FUNCTION update_updated_at_column();
\ No newline at end of file
+    EXECUTE FUNCTION update_updated_at_column();
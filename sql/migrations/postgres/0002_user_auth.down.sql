@@ -0,0 +1,4 @@
+-- 0002_user_auth.down.sql
+-- update_updated_at_column() is left for estimates - it is dropped with them.
+
+DROP TABLE IF EXISTS user_auth;
@@ -1,7 +1,7 @@
--- user_auth.sql
+-- 0002_user_auth.up.sql
 -- Authentication table for Columbia Outdoor users (homeowners and contractors)
 
-CREATE TABLE user_auth (
+CREATE TABLE IF NOT EXISTS user_auth (
     id              BIGSERIAL PRIMARY KEY,
     email           TEXT UNIQUE NOT NULL,
     password_hash   TEXT NOT NULL,                    -- Store bcrypt/argon2/scrypt hash
@@ -23,8 +23,8 @@ CREATE TABLE user_auth (
 );
 
 -- Indexes for performance
-CREATE INDEX idx_user_auth_email ON user_auth(email);
-CREATE INDEX idx_user_auth_role   ON user_auth(role);
+CREATE INDEX IF NOT EXISTS idx_user_auth_email ON user_auth(email);
+CREATE INDEX IF NOT EXISTS idx_user_auth_role   ON user_auth(role);
 
 -- Auto-update updated_at
 CREATE OR REPLACE FUNCTION update_updated_at_column()
@@ -35,7 +35,8 @@ BEGIN
 END;
 $$ language 'plpgsql';
 
+DROP TRIGGER IF EXISTS trigger_update_user_auth_timestamp ON user_auth;
 CREATE TRIGGER trigger_update_user_auth_timestamp
     BEFORE UPDATE ON user_auth
     FOR EACH ROW
-    EXECUTE FUNCTION update_updated_at_column();
\ No newline at end of file
+    EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,4 @@
+-- 0003_estimate_acceptances.down.sql
+
+DROP TABLE IF EXISTS estimate_acceptances;
+DROP FUNCTION IF EXISTS prevent_acceptance_change();
@@ -0,0 +1,35 @@
+-- 0003_estimate_acceptances.up.sql
+-- E-signature record for each accepted estimate.  Rows are immutable once written.
+
+CREATE TABLE IF NOT EXISTS estimate_acceptances (
+    acceptance_id  BIGSERIAL PRIMARY KEY,
+    estimate_id    BIGINT NOT NULL UNIQUE REFERENCES estimates(estimate_id),
+    user_id        BIGINT REFERENCES user_auth(id),   -- Logged in user when signed (if any)
+
+    -- Signature
+    signer_name    TEXT NOT NULL,                     -- Typed full name
+    consent        BOOLEAN NOT NULL CHECK (consent),  -- "I agree" checkbox must be checked
+    ip_address     TEXT NOT NULL,
+    user_agent     TEXT NOT NULL,
+    accepted_at    TIMESTAMPTZ NOT NULL,
+
+    -- The exact estimate and terms text shown, and its sha256
+    document_hash  TEXT NOT NULL,
+    document_text  TEXT NOT NULL,
+
+    created_at     TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Acceptances can never be changed or removed
+CREATE OR REPLACE FUNCTION prevent_acceptance_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'estimate_acceptances rows are immutable';
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS trigger_estimate_acceptances_immutable ON estimate_acceptances;
+CREATE TRIGGER trigger_estimate_acceptances_immutable
+    BEFORE UPDATE OR DELETE ON estimate_acceptances
+    FOR EACH ROW
+    EXECUTE FUNCTION prevent_acceptance_change();
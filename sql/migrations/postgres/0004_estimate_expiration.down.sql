@@ -0,0 +1,6 @@
+-- 0004_estimate_expiration.down.sql
+
+DROP TABLE IF EXISTS estimate_reminders;
+DROP INDEX IF EXISTS idx_estimates_status_expiration;
+ALTER TABLE estimates DROP COLUMN IF EXISTS status;
+ALTER TABLE estimates DROP COLUMN IF EXISTS product_type;
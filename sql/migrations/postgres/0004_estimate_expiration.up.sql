@@ -0,0 +1,25 @@
+-- 0004_estimate_expiration.up.sql
+-- Estimate status for the expiration scheduler, and the reminder email queue.
+
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS product_type TEXT NOT NULL DEFAULT 'deck';
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS status       TEXT NOT NULL DEFAULT 'saved'
+    CHECK (status IN ('saved', 'accepted', 'expired'));
+
+-- Existing rows
+UPDATE estimates SET status = 'accepted' WHERE accept_date IS NOT NULL;
+UPDATE estimates SET status = 'expired'  WHERE accept_date IS NULL AND expiration_date <= NOW();
+
+CREATE INDEX IF NOT EXISTS idx_estimates_status_expiration ON estimates(status, expiration_date);
+
+-- One row per reminder - queued by the scheduler, sent_at set once emailed
+CREATE TABLE IF NOT EXISTS estimate_reminders (
+    reminder_id    BIGSERIAL PRIMARY KEY,
+    estimate_id    BIGINT NOT NULL REFERENCES estimates(estimate_id),
+    days_before    INTEGER NOT NULL,                -- From reminder_days in costs.yaml
+    email          TEXT NOT NULL,
+    queued_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at        TIMESTAMPTZ,
+    UNIQUE (estimate_id, days_before)
+);
+
+CREATE INDEX IF NOT EXISTS idx_estimate_reminders_unsent ON estimate_reminders(queued_at) WHERE sent_at IS NULL;
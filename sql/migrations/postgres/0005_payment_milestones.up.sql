@@ -0,0 +1,14 @@
+-- 0005_payment_milestones.up.sql
+-- Payment schedule generated from total_cost when an estimate is accepted.
+
+CREATE TABLE IF NOT EXISTS payment_milestones (
+    estimate_id    BIGINT NOT NULL REFERENCES estimates(estimate_id),
+    seq            INTEGER NOT NULL,                -- 1, 2, 3 ... in payment order
+    name           TEXT NOT NULL,                   -- e.g., 'Deposit'
+    due            TEXT NOT NULL,                   -- e.g., 'At framing inspection'
+    percent        NUMERIC(5,2) NOT NULL,
+    amount         NUMERIC(12,2) NOT NULL,          -- Milestones add up to total_cost to the cent
+    paid_at        TIMESTAMPTZ,
+    created_at     TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (estimate_id, seq)
+);
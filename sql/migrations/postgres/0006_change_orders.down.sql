@@ -0,0 +1,6 @@
+-- 0006_change_orders.down.sql
+
+DROP TABLE IF EXISTS change_order_items;
+DROP TABLE IF EXISTS change_orders;
+ALTER TABLE estimates DROP COLUMN IF EXISTS sales_tax;
+ALTER TABLE estimates DROP COLUMN IF EXISTS subtotal;
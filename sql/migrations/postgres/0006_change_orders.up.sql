@@ -0,0 +1,42 @@
+-- 0006_change_orders.up.sql
+-- Change orders against accepted estimates.  Each one is accepted by the homeowner separately.
+
+-- Contract subtotal and tax as accepted - change orders price against these
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS subtotal  DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS sales_tax DOUBLE PRECISION;
+
+CREATE TABLE IF NOT EXISTS change_orders (
+    change_order_id BIGSERIAL PRIMARY KEY,
+    estimate_id     BIGINT NOT NULL REFERENCES estimates(estimate_id),
+    seq             INTEGER NOT NULL,               -- 1, 2, 3 ... per estimate
+    reason          TEXT,
+
+    -- Contract totals before and after this change
+    prev_subtotal   DOUBLE PRECISION NOT NULL,
+    subtotal        DOUBLE PRECISION NOT NULL,
+    sales_tax       DOUBLE PRECISION NOT NULL,
+    total_cost      DOUBLE PRECISION NOT NULL,      -- New contract total
+
+    status          TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted')),
+    created_by      BIGINT REFERENCES user_auth(id),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    -- Homeowner acceptance
+    accept_date     TIMESTAMPTZ,
+    signer_name     TEXT,
+    ip_address      TEXT,
+    user_agent      TEXT,
+
+    UNIQUE (estimate_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS change_order_items (
+    change_order_id BIGINT NOT NULL REFERENCES change_orders(change_order_id),
+    line            INTEGER NOT NULL,
+    action          TEXT NOT NULL CHECK (action IN ('add', 'remove', 'modify')),
+    category        TEXT NOT NULL,                  -- Deck, Rail, Stairs ...
+    description     TEXT NOT NULL,
+    old_amount      DOUBLE PRECISION NOT NULL DEFAULT 0,
+    new_amount      DOUBLE PRECISION NOT NULL DEFAULT 0,
+    PRIMARY KEY (change_order_id, line)
+);
@@ -0,0 +1,7 @@
+-- 0007_estimate_templates.down.sql
+
+DROP TABLE IF EXISTS estimate_templates;
+DROP INDEX IF EXISTS idx_estimates_user_id;
+ALTER TABLE estimates DROP COLUMN IF EXISTS user_id;
+ALTER TABLE estimates DROP COLUMN IF EXISTS has_stair_tk;
+ALTER TABLE estimates DROP COLUMN IF EXISTS has_stair_fascia;
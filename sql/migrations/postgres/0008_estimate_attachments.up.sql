@@ -0,0 +1,18 @@
+-- 0008_estimate_attachments.up.sql
+-- Site photos and documents uploaded to a saved estimate.  The files are in attachment storage
+-- (ATTACHMENT_DIR), this table holds who uploaded them and who can see them.
+
+CREATE TABLE IF NOT EXISTS estimate_attachments (
+    attachment_id BIGSERIAL PRIMARY KEY,
+    estimate_id   BIGINT NOT NULL REFERENCES estimates(estimate_id),
+    user_id       BIGINT REFERENCES user_auth(id),   -- Uploader, NULL when not logged in
+    filename      TEXT NOT NULL,                     -- As uploaded, for display only
+    content_type  TEXT NOT NULL,
+    size_bytes    BIGINT NOT NULL,
+    storage_key   TEXT NOT NULL UNIQUE,
+    thumb_key     TEXT,                              -- NULL for documents
+    visibility    TEXT NOT NULL DEFAULT 'customer' CHECK (visibility IN ('customer', 'staff')),
+    uploaded_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_estimate_attachments_estimate_id ON estimate_attachments(estimate_id);
@@ -0,0 +1,4 @@
+-- 0009_job_assignments.down.sql
+
+DROP TABLE IF EXISTS job_assignments;
+DROP TABLE IF EXISTS contractor_service_areas;
@@ -0,0 +1,24 @@
+-- 0009_job_assignments.up.sql
+-- Contractor job board: where each contractor works, and who is assigned each accepted estimate.
+
+CREATE TABLE IF NOT EXISTS contractor_service_areas (
+    user_id    BIGINT NOT NULL REFERENCES user_auth(id),
+    zip_prefix TEXT NOT NULL CHECK (zip_prefix ~ '^[0-9]{3,5}$'),   -- '98642' or a region like '986'
+    PRIMARY KEY (user_id, zip_prefix)
+);
+
+CREATE TABLE IF NOT EXISTS job_assignments (
+    assignment_id BIGSERIAL PRIMARY KEY,
+    estimate_id   BIGINT NOT NULL REFERENCES estimates(estimate_id),
+    contractor_id BIGINT NOT NULL REFERENCES user_auth(id),
+    status        TEXT NOT NULL DEFAULT 'requested' CHECK (status IN ('requested', 'approved', 'rejected')),
+    requested_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    decided_by    BIGINT REFERENCES user_auth(id),   -- Admin who approved or rejected
+    decided_at    TIMESTAMPTZ,
+    UNIQUE (estimate_id, contractor_id)
+);
+
+-- One contractor per job
+CREATE UNIQUE INDEX IF NOT EXISTS idx_job_assignments_approved
+    ON job_assignments(estimate_id) WHERE status = 'approved';
+CREATE INDEX IF NOT EXISTS idx_job_assignments_contractor ON job_assignments(contractor_id, status);
@@ -0,0 +1,14 @@
+-- 0010_estimate_breakdown.down.sql
+
+ALTER TABLE estimates
+    DROP COLUMN IF EXISTS deck_area,
+    DROP COLUMN IF EXISTS deck_cost,
+    DROP COLUMN IF EXISTS rail_feet,
+    DROP COLUMN IF EXISTS rail_cost,
+    DROP COLUMN IF EXISTS stair_cost,
+    DROP COLUMN IF EXISTS stair_rail_cost,
+    DROP COLUMN IF EXISTS stair_fascia_cost,
+    DROP COLUMN IF EXISTS stair_toe_kick_cost,
+    DROP COLUMN IF EXISTS fascia_feet,
+    DROP COLUMN IF EXISTS fascia_cost,
+    DROP COLUMN IF EXISTS demo_cost;
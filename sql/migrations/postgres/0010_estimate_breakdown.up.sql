@@ -0,0 +1,15 @@
+-- 0010_estimate_breakdown.up.sql
+-- The cost breakdown as quoted, so a saved estimate re-renders exactly.  NULL on estimates
+-- saved before this - their breakdown is worked out from the inputs when loaded.
+
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS deck_area           DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS deck_cost           DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS rail_feet           DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS rail_cost           DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS stair_cost          DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS stair_rail_cost     DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS stair_fascia_cost   DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS stair_toe_kick_cost DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS fascia_feet         DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS fascia_cost         DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS demo_cost           DOUBLE PRECISION;
@@ -0,0 +1,22 @@
+-- 0011_customers.down.sql
+-- Copies the details back onto each estimate.
+
+ALTER TABLE estimates
+    ADD COLUMN IF NOT EXISTS first_name   TEXT,
+    ADD COLUMN IF NOT EXISTS last_name    TEXT,
+    ADD COLUMN IF NOT EXISTS address      TEXT,
+    ADD COLUMN IF NOT EXISTS city         TEXT,
+    ADD COLUMN IF NOT EXISTS state        TEXT,
+    ADD COLUMN IF NOT EXISTS zip          TEXT,
+    ADD COLUMN IF NOT EXISTS phone_number TEXT,
+    ADD COLUMN IF NOT EXISTS email        TEXT;
+
+UPDATE estimates e SET first_name = c.first_name, last_name = c.last_name, address = c.address, city = c.city,
+    state = c.state, zip = c.zip, phone_number = c.phone_number, email = c.email
+FROM customers c WHERE c.customer_id = e.customer_id;
+
+CREATE INDEX IF NOT EXISTS idx_estimates_email ON estimates(email);
+CREATE INDEX IF NOT EXISTS idx_estimates_state ON estimates(state);
+
+ALTER TABLE estimates DROP COLUMN IF EXISTS customer_id;
+DROP TABLE IF EXISTS customers;
@@ -0,0 +1,48 @@
+-- 0011_customers.up.sql
+-- Customer contact details move out of estimates into their own table.  A customer row is
+-- never changed once an estimate uses it - new details get a new row - so every estimate
+-- keeps the details it was quoted with.
+
+CREATE TABLE IF NOT EXISTS customers (
+    customer_id   BIGSERIAL PRIMARY KEY,
+    user_id       BIGINT REFERENCES user_auth(id),   -- Account the details were entered under, if any
+    first_name    TEXT NOT NULL DEFAULT '',
+    last_name     TEXT NOT NULL DEFAULT '',
+    address       TEXT NOT NULL DEFAULT '',
+    city          TEXT NOT NULL DEFAULT '',
+    state         TEXT NOT NULL DEFAULT '',
+    zip           TEXT NOT NULL DEFAULT '',
+    phone_number  TEXT NOT NULL DEFAULT '',
+    email         TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_customers_user_id ON customers(user_id);
+CREATE INDEX IF NOT EXISTS idx_customers_email   ON customers(email);
+
+ALTER TABLE estimates ADD COLUMN IF NOT EXISTS customer_id BIGINT REFERENCES customers(customer_id);
+CREATE INDEX IF NOT EXISTS idx_estimates_customer_id ON estimates(customer_id);
+
+-- One customer per distinct set of details on existing estimates
+INSERT INTO customers (user_id, first_name, last_name, address, city, state, zip, phone_number, email)
+SELECT DISTINCT user_id, COALESCE(first_name, ''), COALESCE(last_name, ''), COALESCE(address, ''),
+       COALESCE(city, ''), COALESCE(state, ''), COALESCE(zip, ''), COALESCE(phone_number, ''), COALESCE(email, '')
+FROM estimates;
+
+UPDATE estimates SET customer_id = (
+    SELECT MIN(c.customer_id) FROM customers c
+    WHERE COALESCE(c.user_id, 0) = COALESCE(estimates.user_id, 0)
+      AND c.first_name = COALESCE(estimates.first_name, '') AND c.last_name = COALESCE(estimates.last_name, '')
+      AND c.address = COALESCE(estimates.address, '') AND c.city = COALESCE(estimates.city, '')
+      AND c.state = COALESCE(estimates.state, '') AND c.zip = COALESCE(estimates.zip, '')
+      AND c.phone_number = COALESCE(estimates.phone_number, '') AND c.email = COALESCE(estimates.email, ''));
+
+ALTER TABLE estimates
+    DROP COLUMN IF EXISTS first_name,
+    DROP COLUMN IF EXISTS last_name,
+    DROP COLUMN IF EXISTS address,
+    DROP COLUMN IF EXISTS city,
+    DROP COLUMN IF EXISTS state,
+    DROP COLUMN IF EXISTS zip,
+    DROP COLUMN IF EXISTS phone_number,
+    DROP COLUMN IF EXISTS email;
@@ -0,0 +1,4 @@
+-- 0012_privacy.down.sql
+
+DROP TABLE IF EXISTS privacy_requests;
+DROP TABLE IF EXISTS contact_submissions;
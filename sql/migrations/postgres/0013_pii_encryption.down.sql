@@ -0,0 +1,12 @@
+-- 0013_pii_encryption.down.sql
+-- Drops the blind index only.  Encrypted values stay encrypted - the code before
+-- this migration cannot read them, so restore from a backup taken before it.
+
+DROP INDEX IF EXISTS idx_contact_submissions_email_index;
+DROP INDEX IF EXISTS idx_customers_email_index;
+
+ALTER TABLE contact_submissions DROP COLUMN IF EXISTS email_index;
+ALTER TABLE customers DROP COLUMN IF EXISTS email_index;
+
+CREATE INDEX IF NOT EXISTS idx_customers_email           ON customers(email);
+CREATE INDEX IF NOT EXISTS idx_contact_submissions_email ON contact_submissions(email);
@@ -0,0 +1,3 @@
+-- 0014_sessions.down.sql
+
+DROP TABLE IF EXISTS sessions;
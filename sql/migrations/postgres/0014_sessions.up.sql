@@ -0,0 +1,12 @@
+-- 0014_sessions.up.sql
+-- Server side sessions for SESSION_BACKEND=database.  data is the signed,
+-- encoded session values; the cookie only holds the session ID.
+
+CREATE TABLE IF NOT EXISTS sessions (
+    session_id  TEXT PRIMARY KEY,
+    data        TEXT NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
@@ -0,0 +1,3 @@
+-- 0015_estimate_drafts.down.sql
+
+DROP TABLE IF EXISTS estimate_drafts;
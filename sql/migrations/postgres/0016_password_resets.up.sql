@@ -0,0 +1,16 @@
+-- 0016_password_resets.up.sql
+-- Password reset links.  Only a SHA-256 hash of the token is kept, so the table
+-- cannot be used to reset a password.  A token works once, until expires_at.
+-- sessions_valid_from on user_auth signs out sessions started before a reset.
+
+CREATE TABLE IF NOT EXISTS password_resets (
+    token_hash   TEXT PRIMARY KEY,
+    user_id      BIGINT NOT NULL REFERENCES user_auth(id),
+    expires_at   TIMESTAMPTZ NOT NULL,
+    used_at      TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets(user_id);
+
+ALTER TABLE user_auth ADD COLUMN IF NOT EXISTS sessions_valid_from TIMESTAMPTZ;
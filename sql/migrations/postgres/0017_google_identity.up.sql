@@ -0,0 +1,8 @@
+-- 0017_google_identity.up.sql
+-- The Google account (the OpenID subject) a user signs in with.  A user found by
+-- email on their first Google sign-in is linked, so password and Google logins
+-- for the same person are one user.
+
+ALTER TABLE user_auth ADD COLUMN IF NOT EXISTS google_sub TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_auth_google_sub ON user_auth(google_sub);
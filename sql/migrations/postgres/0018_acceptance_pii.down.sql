@@ -0,0 +1,8 @@
+-- 0018_acceptance_pii.down.sql
+
+CREATE OR REPLACE FUNCTION prevent_acceptance_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'estimate_acceptances rows are immutable';
+END;
+$$ language 'plpgsql';
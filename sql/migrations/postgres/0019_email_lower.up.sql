@@ -0,0 +1,10 @@
+-- 0019_email_lower.up.sql
+-- One account per email, whatever its case.  Emails are stored trimmed and in
+-- lower case from now on, and the ones already stored are changed to match.  If
+-- two accounts differ only in the case of their email this fails, and they must
+-- be merged by hand first.  To find them:
+--   SELECT LOWER(TRIM(email)), COUNT(*) FROM user_auth GROUP BY 1 HAVING COUNT(*) > 1;
+
+UPDATE user_auth SET email = LOWER(TRIM(email)) WHERE email <> LOWER(TRIM(email));
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_auth_email_lower ON user_auth(LOWER(email));
@@ -0,0 +1,16 @@
+-- 0020_acceptance_rekey.down.sql
+
+CREATE OR REPLACE FUNCTION prevent_acceptance_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND
+        (NEW.acceptance_id, NEW.estimate_id, NEW.user_id, NEW.consent, NEW.ip_address, NEW.user_agent,
+         NEW.accepted_at, NEW.document_hash, NEW.created_at)
+        IS NOT DISTINCT FROM
+        (OLD.acceptance_id, OLD.estimate_id, OLD.user_id, OLD.consent, OLD.ip_address, OLD.user_agent,
+         OLD.accepted_at, OLD.document_hash, OLD.created_at) THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'estimate_acceptances rows are immutable';
+END;
+$$ language 'plpgsql';
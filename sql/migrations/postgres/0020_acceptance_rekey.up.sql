@@ -0,0 +1,22 @@
+-- 0020_acceptance_rekey.up.sql
+-- 0018 let signer_name and document_text change so pii rotate could re-encrypt
+-- them, but any new value was accepted.  Only re-keying is allowed now: the new
+-- value must be ciphertext (pii1:...), whether the old one was plaintext or
+-- sealed with an older key.  Every other column stays as signed.
+
+CREATE OR REPLACE FUNCTION prevent_acceptance_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND
+        (NEW.acceptance_id, NEW.estimate_id, NEW.user_id, NEW.consent, NEW.ip_address, NEW.user_agent,
+         NEW.accepted_at, NEW.document_hash, NEW.created_at)
+        IS NOT DISTINCT FROM
+        (OLD.acceptance_id, OLD.estimate_id, OLD.user_id, OLD.consent, OLD.ip_address, OLD.user_agent,
+         OLD.accepted_at, OLD.document_hash, OLD.created_at) AND
+        (NEW.signer_name IS NOT DISTINCT FROM OLD.signer_name OR NEW.signer_name LIKE 'pii1:%') AND
+        (NEW.document_text IS NOT DISTINCT FROM OLD.document_text OR NEW.document_text LIKE 'pii1:%') THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'estimate_acceptances rows are immutable';
+END;
+$$ language 'plpgsql';
@@ -0,0 +1,3 @@
+-- 0021_reminder_lease.down.sql
+
+ALTER TABLE estimate_reminders DROP COLUMN IF EXISTS claimed_until;
@@ -0,0 +1,6 @@
+-- 0021_reminder_lease.up.sql
+-- A reminder is claimed for a while (claimed_until) before it is emailed, and
+-- sent_at is set only once the email has gone.  If the instance sending it dies,
+-- the claim runs out and another sweep sends it.
+
+ALTER TABLE estimate_reminders ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMPTZ;
@@ -0,0 +1,4 @@
+-- 0001_estimates.down.sql
+
+DROP TABLE IF EXISTS estimates;
+DELETE FROM sqlite_sequence WHERE name = 'estimates';
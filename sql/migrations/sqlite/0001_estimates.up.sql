@@ -0,0 +1,37 @@
+-- 0001_estimates.up.sql
+-- The estimates table, as first created on Postgres.
+
+CREATE TABLE IF NOT EXISTS estimates (
+    estimate_id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    description      TEXT,
+    length           DOUBLE PRECISION,
+    width            DOUBLE PRECISION,
+    height           DOUBLE PRECISION,
+    material         TEXT,
+    rail_material    TEXT,
+    rail_infill      TEXT,
+    stair_width      DOUBLE PRECISION,
+    stair_rail_count DOUBLE PRECISION,
+    has_demo         BOOLEAN DEFAULT FALSE,
+    has_fascia       BOOLEAN DEFAULT FALSE,
+    total_cost       DOUBLE PRECISION,
+    first_name       TEXT,
+    last_name        TEXT,
+    address          TEXT,
+    city             TEXT,
+    state            TEXT,
+    zip              TEXT,
+    phone_number     TEXT,
+    email            TEXT,
+    save_date        TIMESTAMP,
+    accept_date      TIMESTAMP,
+    expiration_date  TIMESTAMP,
+    created_at       TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at       TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Estimate numbers start at 1000, as in Postgres
+INSERT INTO sqlite_sequence (name, seq)
+    SELECT 'estimates', 999 WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'estimates');
+
+CREATE INDEX IF NOT EXISTS idx_estimates_email ON estimates(email);
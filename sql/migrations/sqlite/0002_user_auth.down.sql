@@ -0,0 +1,3 @@
+-- 0002_user_auth.down.sql
+
+DROP TABLE IF EXISTS user_auth;
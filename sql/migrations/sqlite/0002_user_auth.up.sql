@@ -0,0 +1,18 @@
+-- 0002_user_auth.up.sql
+
+CREATE TABLE IF NOT EXISTS user_auth (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    email           TEXT UNIQUE NOT NULL,
+    password_hash   TEXT NOT NULL,
+    role            TEXT NOT NULL CHECK (role IN ('homeowner', 'contractor', 'admin')),
+    first_name      TEXT,
+    last_name       TEXT,
+    phone           TEXT,
+    is_active       BOOLEAN DEFAULT TRUE,
+    email_verified  BOOLEAN DEFAULT FALSE,
+    created_at      TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at      TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_login_at   TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_auth_role ON user_auth(role);
@@ -0,0 +1,4 @@
+-- 0003_estimate_acceptances.down.sql
+-- Dropping the table drops its triggers too.
+
+DROP TABLE IF EXISTS estimate_acceptances;
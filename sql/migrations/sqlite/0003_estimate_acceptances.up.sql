@@ -0,0 +1,28 @@
+-- 0003_estimate_acceptances.up.sql
+-- E-signature record for each accepted estimate.  Rows are immutable once written.
+
+CREATE TABLE IF NOT EXISTS estimate_acceptances (
+    acceptance_id  INTEGER PRIMARY KEY AUTOINCREMENT,
+    estimate_id    INTEGER NOT NULL UNIQUE REFERENCES estimates(estimate_id),
+    user_id        INTEGER REFERENCES user_auth(id),
+    signer_name    TEXT NOT NULL,
+    consent        BOOLEAN NOT NULL CHECK (consent),
+    ip_address     TEXT NOT NULL,
+    user_agent     TEXT NOT NULL,
+    accepted_at    TIMESTAMP NOT NULL,
+    document_hash  TEXT NOT NULL,
+    document_text  TEXT NOT NULL,
+    created_at     TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER IF NOT EXISTS trigger_estimate_acceptances_no_update
+    BEFORE UPDATE ON estimate_acceptances
+BEGIN
+    SELECT RAISE(ABORT, 'estimate_acceptances rows are immutable');
+END;
+
+CREATE TRIGGER IF NOT EXISTS trigger_estimate_acceptances_no_delete
+    BEFORE DELETE ON estimate_acceptances
+BEGIN
+    SELECT RAISE(ABORT, 'estimate_acceptances rows are immutable');
+END;
@@ -0,0 +1,20 @@
+-- 0004_estimate_expiration.up.sql
+-- Estimate status for the expiration scheduler, and the reminder email queue.
+
+ALTER TABLE estimates ADD COLUMN product_type TEXT NOT NULL DEFAULT 'deck';
+ALTER TABLE estimates ADD COLUMN status       TEXT NOT NULL DEFAULT 'saved'
+    CHECK (status IN ('saved', 'accepted', 'expired'));
+
+UPDATE estimates SET status = 'accepted' WHERE accept_date IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_estimates_status_expiration ON estimates(status, expiration_date);
+
+CREATE TABLE IF NOT EXISTS estimate_reminders (
+    reminder_id    INTEGER PRIMARY KEY AUTOINCREMENT,
+    estimate_id    INTEGER NOT NULL REFERENCES estimates(estimate_id),
+    days_before    INTEGER NOT NULL,
+    email          TEXT NOT NULL,
+    queued_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at        TIMESTAMP,
+    UNIQUE (estimate_id, days_before)
+);
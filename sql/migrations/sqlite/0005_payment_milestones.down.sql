@@ -0,0 +1,3 @@
+-- 0005_payment_milestones.down.sql
+
+DROP TABLE IF EXISTS payment_milestones;
@@ -0,0 +1,14 @@
+-- 0005_payment_milestones.up.sql
+-- Payment schedule generated from total_cost when an estimate is accepted.
+
+CREATE TABLE IF NOT EXISTS payment_milestones (
+    estimate_id    INTEGER NOT NULL REFERENCES estimates(estimate_id),
+    seq            INTEGER NOT NULL,
+    name           TEXT NOT NULL,
+    due            TEXT NOT NULL,
+    percent        NUMERIC NOT NULL,
+    amount         NUMERIC NOT NULL,
+    paid_at        TIMESTAMP,
+    created_at     TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (estimate_id, seq)
+);
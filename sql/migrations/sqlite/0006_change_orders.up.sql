@@ -0,0 +1,35 @@
+-- 0006_change_orders.up.sql
+-- Change orders against accepted estimates.  Each one is accepted by the homeowner separately.
+
+ALTER TABLE estimates ADD COLUMN subtotal  DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN sales_tax DOUBLE PRECISION;
+
+CREATE TABLE IF NOT EXISTS change_orders (
+    change_order_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    estimate_id     INTEGER NOT NULL REFERENCES estimates(estimate_id),
+    seq             INTEGER NOT NULL,
+    reason          TEXT,
+    prev_subtotal   DOUBLE PRECISION NOT NULL,
+    subtotal        DOUBLE PRECISION NOT NULL,
+    sales_tax       DOUBLE PRECISION NOT NULL,
+    total_cost      DOUBLE PRECISION NOT NULL,
+    status          TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted')),
+    created_by      INTEGER REFERENCES user_auth(id),
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    accept_date     TIMESTAMP,
+    signer_name     TEXT,
+    ip_address      TEXT,
+    user_agent      TEXT,
+    UNIQUE (estimate_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS change_order_items (
+    change_order_id INTEGER NOT NULL REFERENCES change_orders(change_order_id),
+    line            INTEGER NOT NULL,
+    action          TEXT NOT NULL CHECK (action IN ('add', 'remove', 'modify')),
+    category        TEXT NOT NULL,
+    description     TEXT NOT NULL,
+    old_amount      DOUBLE PRECISION NOT NULL DEFAULT 0,
+    new_amount      DOUBLE PRECISION NOT NULL DEFAULT 0,
+    PRIMARY KEY (change_order_id, line)
+);
@@ -0,0 +1,29 @@
+-- 0007_estimate_templates.up.sql
+-- Named calculator inputs for typical decks, and the columns needed to clone a saved estimate.
+
+ALTER TABLE estimates ADD COLUMN has_stair_fascia BOOLEAN DEFAULT FALSE;
+ALTER TABLE estimates ADD COLUMN has_stair_tk     BOOLEAN DEFAULT FALSE;
+ALTER TABLE estimates ADD COLUMN user_id          INTEGER REFERENCES user_auth(id);
+
+CREATE INDEX IF NOT EXISTS idx_estimates_user_id ON estimates(user_id);
+
+CREATE TABLE IF NOT EXISTS estimate_templates (
+    template_id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    name             TEXT UNIQUE NOT NULL,
+    description      TEXT,
+    product_type     TEXT NOT NULL DEFAULT 'deck',
+    length           DOUBLE PRECISION NOT NULL,
+    width            DOUBLE PRECISION NOT NULL,
+    height           DOUBLE PRECISION NOT NULL,
+    material         TEXT NOT NULL,
+    rail_material    TEXT NOT NULL DEFAULT '',
+    rail_infill      TEXT NOT NULL DEFAULT '',
+    stair_width      DOUBLE PRECISION NOT NULL DEFAULT 0,
+    stair_rail_count DOUBLE PRECISION NOT NULL DEFAULT 0,
+    has_demo         BOOLEAN NOT NULL DEFAULT FALSE,
+    has_fascia       BOOLEAN NOT NULL DEFAULT FALSE,
+    has_stair_fascia BOOLEAN NOT NULL DEFAULT FALSE,
+    has_stair_tk     BOOLEAN NOT NULL DEFAULT FALSE,
+    created_by       INTEGER REFERENCES user_auth(id),
+    created_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,4 @@
+-- 0008_estimate_attachments.down.sql
+-- Removes the records only.  The files stay in attachment storage.
+
+DROP TABLE IF EXISTS estimate_attachments;
@@ -0,0 +1,17 @@
+-- 0008_estimate_attachments.up.sql
+-- Site photos and documents uploaded to a saved estimate.
+
+CREATE TABLE IF NOT EXISTS estimate_attachments (
+    attachment_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    estimate_id   INTEGER NOT NULL REFERENCES estimates(estimate_id),
+    user_id       INTEGER REFERENCES user_auth(id),
+    filename      TEXT NOT NULL,
+    content_type  TEXT NOT NULL,
+    size_bytes    INTEGER NOT NULL,
+    storage_key   TEXT NOT NULL UNIQUE,
+    thumb_key     TEXT,
+    visibility    TEXT NOT NULL DEFAULT 'customer' CHECK (visibility IN ('customer', 'staff')),
+    uploaded_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_estimate_attachments_estimate_id ON estimate_attachments(estimate_id);
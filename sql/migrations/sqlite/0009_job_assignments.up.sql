@@ -0,0 +1,24 @@
+-- 0009_job_assignments.up.sql
+-- Contractor job board: where each contractor works, and who is assigned each accepted estimate.
+
+CREATE TABLE IF NOT EXISTS contractor_service_areas (
+    user_id    INTEGER NOT NULL REFERENCES user_auth(id),
+    zip_prefix TEXT NOT NULL CHECK (length(zip_prefix) BETWEEN 3 AND 5 AND zip_prefix NOT GLOB '*[^0-9]*'),
+    PRIMARY KEY (user_id, zip_prefix)
+);
+
+CREATE TABLE IF NOT EXISTS job_assignments (
+    assignment_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    estimate_id   INTEGER NOT NULL REFERENCES estimates(estimate_id),
+    contractor_id INTEGER NOT NULL REFERENCES user_auth(id),
+    status        TEXT NOT NULL DEFAULT 'requested' CHECK (status IN ('requested', 'approved', 'rejected')),
+    requested_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    decided_by    INTEGER REFERENCES user_auth(id),
+    decided_at    TIMESTAMP,
+    UNIQUE (estimate_id, contractor_id)
+);
+
+-- One contractor per job
+CREATE UNIQUE INDEX IF NOT EXISTS idx_job_assignments_approved
+    ON job_assignments(estimate_id) WHERE status = 'approved';
+CREATE INDEX IF NOT EXISTS idx_job_assignments_contractor ON job_assignments(contractor_id, status);
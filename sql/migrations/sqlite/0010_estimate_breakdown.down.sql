@@ -0,0 +1,13 @@
+-- 0010_estimate_breakdown.down.sql
+
+ALTER TABLE estimates DROP COLUMN deck_area;
+ALTER TABLE estimates DROP COLUMN deck_cost;
+ALTER TABLE estimates DROP COLUMN rail_feet;
+ALTER TABLE estimates DROP COLUMN rail_cost;
+ALTER TABLE estimates DROP COLUMN stair_cost;
+ALTER TABLE estimates DROP COLUMN stair_rail_cost;
+ALTER TABLE estimates DROP COLUMN stair_fascia_cost;
+ALTER TABLE estimates DROP COLUMN stair_toe_kick_cost;
+ALTER TABLE estimates DROP COLUMN fascia_feet;
+ALTER TABLE estimates DROP COLUMN fascia_cost;
+ALTER TABLE estimates DROP COLUMN demo_cost;
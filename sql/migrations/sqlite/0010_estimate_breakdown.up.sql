@@ -0,0 +1,15 @@
+-- 0010_estimate_breakdown.up.sql
+-- The cost breakdown as quoted, so a saved estimate re-renders exactly.  NULL on estimates
+-- saved before this - their breakdown is worked out from the inputs when loaded.
+
+ALTER TABLE estimates ADD COLUMN deck_area           DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN deck_cost           DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN rail_feet           DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN rail_cost           DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN stair_cost          DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN stair_rail_cost     DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN stair_fascia_cost   DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN stair_toe_kick_cost DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN fascia_feet         DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN fascia_cost         DOUBLE PRECISION;
+ALTER TABLE estimates ADD COLUMN demo_cost           DOUBLE PRECISION;
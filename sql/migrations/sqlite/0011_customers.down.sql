@@ -0,0 +1,21 @@
+-- 0011_customers.down.sql
+-- Copies the details back onto each estimate.
+
+ALTER TABLE estimates ADD COLUMN first_name   TEXT;
+ALTER TABLE estimates ADD COLUMN last_name    TEXT;
+ALTER TABLE estimates ADD COLUMN address      TEXT;
+ALTER TABLE estimates ADD COLUMN city         TEXT;
+ALTER TABLE estimates ADD COLUMN state        TEXT;
+ALTER TABLE estimates ADD COLUMN zip          TEXT;
+ALTER TABLE estimates ADD COLUMN phone_number TEXT;
+ALTER TABLE estimates ADD COLUMN email        TEXT;
+
+UPDATE estimates SET (first_name, last_name, address, city, state, zip, phone_number, email) = (
+    SELECT c.first_name, c.last_name, c.address, c.city, c.state, c.zip, c.phone_number, c.email
+    FROM customers c WHERE c.customer_id = estimates.customer_id);
+
+CREATE INDEX IF NOT EXISTS idx_estimates_email ON estimates(email);
+
+DROP INDEX IF EXISTS idx_estimates_customer_id;
+ALTER TABLE estimates DROP COLUMN customer_id;
+DROP TABLE IF EXISTS customers;
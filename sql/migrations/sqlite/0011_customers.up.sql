@@ -0,0 +1,46 @@
+-- 0011_customers.up.sql
+-- Customer contact details move out of estimates into their own table.  A customer row is
+-- never changed once an estimate uses it - new details get a new row.
+
+CREATE TABLE IF NOT EXISTS customers (
+    customer_id   INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id       INTEGER REFERENCES user_auth(id),
+    first_name    TEXT NOT NULL DEFAULT '',
+    last_name     TEXT NOT NULL DEFAULT '',
+    address       TEXT NOT NULL DEFAULT '',
+    city          TEXT NOT NULL DEFAULT '',
+    state         TEXT NOT NULL DEFAULT '',
+    zip           TEXT NOT NULL DEFAULT '',
+    phone_number  TEXT NOT NULL DEFAULT '',
+    email         TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_customers_user_id ON customers(user_id);
+CREATE INDEX IF NOT EXISTS idx_customers_email   ON customers(email);
+
+ALTER TABLE estimates ADD COLUMN customer_id INTEGER REFERENCES customers(customer_id);
+CREATE INDEX IF NOT EXISTS idx_estimates_customer_id ON estimates(customer_id);
+
+INSERT INTO customers (user_id, first_name, last_name, address, city, state, zip, phone_number, email)
+SELECT DISTINCT user_id, COALESCE(first_name, ''), COALESCE(last_name, ''), COALESCE(address, ''),
+       COALESCE(city, ''), COALESCE(state, ''), COALESCE(zip, ''), COALESCE(phone_number, ''), COALESCE(email, '')
+FROM estimates;
+
+UPDATE estimates SET customer_id = (
+    SELECT MIN(c.customer_id) FROM customers c
+    WHERE COALESCE(c.user_id, 0) = COALESCE(estimates.user_id, 0)
+      AND c.first_name = COALESCE(estimates.first_name, '') AND c.last_name = COALESCE(estimates.last_name, '')
+      AND c.address = COALESCE(estimates.address, '') AND c.city = COALESCE(estimates.city, '')
+      AND c.state = COALESCE(estimates.state, '') AND c.zip = COALESCE(estimates.zip, '')
+      AND c.phone_number = COALESCE(estimates.phone_number, '') AND c.email = COALESCE(estimates.email, ''));
+
+DROP INDEX IF EXISTS idx_estimates_email;
+ALTER TABLE estimates DROP COLUMN first_name;
+ALTER TABLE estimates DROP COLUMN last_name;
+ALTER TABLE estimates DROP COLUMN address;
+ALTER TABLE estimates DROP COLUMN city;
+ALTER TABLE estimates DROP COLUMN state;
+ALTER TABLE estimates DROP COLUMN zip;
+ALTER TABLE estimates DROP COLUMN phone_number;
+ALTER TABLE estimates DROP COLUMN email;
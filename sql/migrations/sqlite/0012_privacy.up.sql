@@ -0,0 +1,30 @@
+-- 0012_privacy.up.sql
+-- Contact form submissions, and the audit trail of personal data export and deletion requests.
+
+CREATE TABLE IF NOT EXISTS contact_submissions (
+    submission_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id       INTEGER REFERENCES user_auth(id),   -- Logged in user when sent (if any)
+    name          TEXT NOT NULL,
+    email         TEXT NOT NULL,
+    phone         TEXT NOT NULL DEFAULT '',
+    project       TEXT NOT NULL DEFAULT '',
+    message       TEXT NOT NULL DEFAULT '',
+    ip_address    TEXT NOT NULL,
+    submitted_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_contact_submissions_user_id ON contact_submissions(user_id);
+CREATE INDEX IF NOT EXISTS idx_contact_submissions_email   ON contact_submissions(email);
+
+-- One row per request, written before it is carried out.  Never deleted.
+CREATE TABLE IF NOT EXISTS privacy_requests (
+    request_id    INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id       INTEGER NOT NULL REFERENCES user_auth(id),
+    kind          TEXT NOT NULL CHECK (kind IN ('export', 'delete')),
+    ip_address    TEXT NOT NULL,
+    user_agent    TEXT NOT NULL,
+    requested_at  TIMESTAMP NOT NULL,
+    completed_at  TIMESTAMP                         -- NULL if it failed
+);
+
+CREATE INDEX IF NOT EXISTS idx_privacy_requests_user_id ON privacy_requests(user_id);
@@ -0,0 +1,13 @@
+-- 0013_pii_encryption.up.sql
+-- Customer details are now encrypted by the application, so email can no longer be
+-- searched directly.  email_index is an HMAC of the normalized email (see pii.go).
+-- Existing rows get their index, and are encrypted, by: colout2 pii rotate
+
+ALTER TABLE customers ADD COLUMN email_index TEXT NOT NULL DEFAULT '';
+ALTER TABLE contact_submissions ADD COLUMN email_index TEXT NOT NULL DEFAULT '';
+
+DROP INDEX IF EXISTS idx_customers_email;
+DROP INDEX IF EXISTS idx_contact_submissions_email;
+
+CREATE INDEX IF NOT EXISTS idx_customers_email_index           ON customers(email_index);
+CREATE INDEX IF NOT EXISTS idx_contact_submissions_email_index ON contact_submissions(email_index);
@@ -0,0 +1,24 @@
+-- 0015_estimate_drafts.up.sql
+-- The unsaved estimate a user was working on when they logged in or signed up,
+-- kept with their account.  One per user.  Inputs only, like estimate_templates -
+-- costs are recalculated when the draft is resumed.
+
+CREATE TABLE IF NOT EXISTS estimate_drafts (
+    user_id          INTEGER PRIMARY KEY REFERENCES user_auth(id),
+    customer_id      INTEGER REFERENCES customers(customer_id),
+    description      TEXT NOT NULL DEFAULT '',
+    product_type     TEXT NOT NULL DEFAULT 'deck',
+    length           DOUBLE PRECISION NOT NULL DEFAULT 0,
+    width            DOUBLE PRECISION NOT NULL DEFAULT 0,
+    height           DOUBLE PRECISION NOT NULL DEFAULT 0,
+    material         TEXT NOT NULL DEFAULT '',
+    rail_material    TEXT NOT NULL DEFAULT '',
+    rail_infill      TEXT NOT NULL DEFAULT '',
+    stair_width      DOUBLE PRECISION NOT NULL DEFAULT 0,
+    stair_rail_count DOUBLE PRECISION NOT NULL DEFAULT 0,
+    has_demo         BOOLEAN NOT NULL DEFAULT FALSE,
+    has_fascia       BOOLEAN NOT NULL DEFAULT FALSE,
+    has_stair_fascia BOOLEAN NOT NULL DEFAULT FALSE,
+    has_stair_tk     BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
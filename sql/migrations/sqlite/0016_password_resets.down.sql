@@ -0,0 +1,4 @@
+-- 0016_password_resets.down.sql
+
+DROP TABLE IF EXISTS password_resets;
+ALTER TABLE user_auth DROP COLUMN sessions_valid_from;
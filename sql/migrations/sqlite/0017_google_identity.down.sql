@@ -0,0 +1,4 @@
+-- 0017_google_identity.down.sql
+
+DROP INDEX IF EXISTS idx_user_auth_google_sub;
+ALTER TABLE user_auth DROP COLUMN google_sub;
@@ -0,0 +1,9 @@
+-- 0018_acceptance_pii.down.sql
+
+DROP TRIGGER IF EXISTS trigger_estimate_acceptances_no_update;
+
+CREATE TRIGGER IF NOT EXISTS trigger_estimate_acceptances_no_update
+    BEFORE UPDATE ON estimate_acceptances
+BEGIN
+    SELECT RAISE(ABORT, 'estimate_acceptances rows are immutable');
+END;
@@ -0,0 +1,21 @@
+-- 0018_acceptance_pii.up.sql
+-- signer_name and document_text are now encrypted like other PII (see pii.go).
+-- pii rotate must re-encrypt them, so an update may change those two columns -
+-- and nothing else.  Acceptances still cannot be removed.
+
+DROP TRIGGER IF EXISTS trigger_estimate_acceptances_no_update;
+
+CREATE TRIGGER IF NOT EXISTS trigger_estimate_acceptances_no_update
+    BEFORE UPDATE ON estimate_acceptances
+    WHEN NEW.acceptance_id IS NOT OLD.acceptance_id
+        OR NEW.estimate_id IS NOT OLD.estimate_id
+        OR NEW.user_id IS NOT OLD.user_id
+        OR NEW.consent IS NOT OLD.consent
+        OR NEW.ip_address IS NOT OLD.ip_address
+        OR NEW.user_agent IS NOT OLD.user_agent
+        OR NEW.accepted_at IS NOT OLD.accepted_at
+        OR NEW.document_hash IS NOT OLD.document_hash
+        OR NEW.created_at IS NOT OLD.created_at
+BEGIN
+    SELECT RAISE(ABORT, 'estimate_acceptances rows are immutable');
+END;
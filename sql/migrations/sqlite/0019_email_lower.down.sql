@@ -0,0 +1,4 @@
+-- 0019_email_lower.down.sql
+-- Stored emails stay in lower case.
+
+DROP INDEX IF EXISTS idx_user_auth_email_lower;
@@ -0,0 +1,24 @@
+-- 0020_acceptance_rekey.up.sql
+-- 0018 let signer_name and document_text change so pii rotate could re-encrypt
+-- them, but any new value was accepted.  Only re-keying is allowed now: the new
+-- value must be ciphertext (pii1:...), whether the old one was plaintext or
+-- sealed with an older key.  Every other column stays as signed.
+
+DROP TRIGGER IF EXISTS trigger_estimate_acceptances_no_update;
+
+CREATE TRIGGER IF NOT EXISTS trigger_estimate_acceptances_no_update
+    BEFORE UPDATE ON estimate_acceptances
+    WHEN NEW.acceptance_id IS NOT OLD.acceptance_id
+        OR NEW.estimate_id IS NOT OLD.estimate_id
+        OR NEW.user_id IS NOT OLD.user_id
+        OR NEW.consent IS NOT OLD.consent
+        OR NEW.ip_address IS NOT OLD.ip_address
+        OR NEW.user_agent IS NOT OLD.user_agent
+        OR NEW.accepted_at IS NOT OLD.accepted_at
+        OR NEW.document_hash IS NOT OLD.document_hash
+        OR NEW.created_at IS NOT OLD.created_at
+        OR (NEW.signer_name IS NOT OLD.signer_name AND substr(NEW.signer_name, 1, 5) IS NOT 'pii1:')
+        OR (NEW.document_text IS NOT OLD.document_text AND substr(NEW.document_text, 1, 5) IS NOT 'pii1:')
+BEGIN
+    SELECT RAISE(ABORT, 'estimate_acceptances rows are immutable');
+END;
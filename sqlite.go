@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3" // registers "sqlite3" driver
+)
+
+// sqliteOptions turn on foreign keys, wait for locks rather than failing, let
+// readers run alongside the writer, and take the write lock when a transaction
+// starts so two transactions cannot deadlock upgrading their locks.
+const sqliteOptions = "_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate&_loc=auto"
+
+// openSQLiteStore opens dir/estimates.db, creating the file if needed.  Run
+// "colout2 migrate up" to create the tables.
+func openSQLiteStore(dir string) (*SQLStore, error) {
+	if dir == "" {
+		dir = "./db" // Default to ./db if DB_DIR not set
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	dsn := "file:" + filepath.Join(dir, "estimates.db") + "?" + sqliteOptions
+	return openSQLStore("sqlite3", dsn, dialectSQLite)
+}
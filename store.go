@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// EstimateStore saves estimates and everything that hangs off them: the
+// signature, payment schedule, change orders and expiry reminders.
+type EstimateStore interface {
+	InsertEstimate(ctx context.Context, estimate *DeckEstimate, userID int64, status string) error
+	LoadEstimate(ctx context.Context, estimateID int) (DeckEstimate, int64, error)
+	LoadUserEstimates(ctx context.Context, userID int64) ([]DeckEstimate, error)
+	EstimateOwner(ctx context.Context, estimateID int) (int64, error)
+	ListEstimates(ctx context.Context, status string) ([]EstimateListing, error)
+
+	InsertAcceptance(ctx context.Context, a *Acceptance, payments []PaymentMilestone) error
+	LoadAcceptance(ctx context.Context, estimateID int) (Acceptance, error)
+	LoadPaymentSchedule(ctx context.Context, estimateID int) ([]PaymentMilestone, error)
+
+	LoadAcceptedTotals(ctx context.Context, estimateID int) (float64, float64, error)
+	InsertChangeOrder(ctx context.Context, co *ChangeOrder) error
+	AcceptChangeOrder(ctx context.Context, co *ChangeOrder) error
+	LoadChangeOrders(ctx context.Context, estimateID int) ([]ChangeOrder, error)
+
+	ExpireEstimates(ctx context.Context, now time.Time) (int64, error)
+	QueueReminders(ctx context.Context, now time.Time, daysBefore int) error
+	ClaimReminders(ctx context.Context, now time.Time, limit int) ([]EstimateReminder, error)
+	MarkReminderSent(ctx context.Context, reminderID int64, sentAt time.Time) error
+	ReleaseReminder(ctx context.Context, reminderID int64) error
+}
+
+// TemplateStore saves the named estimate templates.
+type TemplateStore interface {
+	SaveTemplate(ctx context.Context, t *EstimateTemplate) error
+	LoadTemplates(ctx context.Context) ([]EstimateTemplate, error)
+	LoadTemplate(ctx context.Context, templateID int64) (EstimateTemplate, error)
+	DeleteTemplate(ctx context.Context, templateID int64) error
+}
+
+// AttachmentStore records attachments.  The files themselves are in attachmentFiles.
+type AttachmentStore interface {
+	InsertAttachment(ctx context.Context, a *Attachment) error
+	LoadAttachment(ctx context.Context, attachmentID int64) (Attachment, error)
+	LoadAttachments(ctx context.Context, estimateID int, role string) ([]Attachment, error)
+	DeleteAttachment(ctx context.Context, attachmentID int64) error
+}
+
+// JobStore holds contractor service areas and job assignments.
+type JobStore interface {
+	LoadServiceAreas(ctx context.Context, contractorID int64) ([]string, error)
+	SaveServiceAreas(ctx context.Context, contractorID int64, areas []string) error
+	LoadOpenJobs(ctx context.Context, contractorID int64) ([]Job, error)
+	LoadAssignedJobs(ctx context.Context, contractorID int64) ([]Job, error)
+	LoadAssignments(ctx context.Context, status string, limit int) ([]Job, error)
+	RequestJob(ctx context.Context, estimateID int, contractorID int64) error
+	ApproveAssignment(ctx context.Context, assignmentID int64, adminID int64) (int, error)
+	RejectAssignment(ctx context.Context, assignmentID int64, adminID int64) error
+	IsAssignedContractor(ctx context.Context, estimateID int, contractorID int64) (bool, error)
+}
+
+// UserStore holds user accounts.
+type UserStore interface {
+	CreateUser(ctx context.Context, u *NewUser) (int64, error)
+	LoadUser(ctx context.Context, userID int64) (UserLogin, error)
+	LoadUserByEmail(ctx context.Context, email string) (UserLogin, error)
+	RecordLogin(ctx context.Context, userID int64, at time.Time) error
+	SetEmailVerified(ctx context.Context, userID int64) error
+	CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, expires time.Time) error
+	CheckPasswordReset(ctx context.Context, tokenHash string, now time.Time) (int64, error)
+	ResetPassword(ctx context.Context, tokenHash string, passwordHash string, now time.Time) (UserLogin, error)
+	PasswordResetSentAt(ctx context.Context, userID int64) (time.Time, error)
+	SessionsValidFrom(ctx context.Context, userID int64) (time.Time, error)
+	LinkGoogleUser(ctx context.Context, g GoogleIdentity) (UserLogin, bool, error)
+}
+
+// AccountStore gathers and erases a user's personal data, and keeps the audit
+// trail of those requests.
+type AccountStore interface {
+	LoadAccountData(ctx context.Context, userID int64) (AccountData, error)
+	AnonymizeAccount(ctx context.Context, userID int64) ([]Attachment, error)
+	InsertPrivacyRequest(ctx context.Context, req *PrivacyRequest) error
+	CompletePrivacyRequest(ctx context.Context, requestID int64, completedAt time.Time) error
+	LoadPrivacyRequests(ctx context.Context, userID int64) ([]PrivacyRequest, error)
+}
+
+// ContactStore keeps contact form submissions.
+type ContactStore interface {
+	InsertContactSubmission(ctx context.Context, c *ContactSubmission) error
+	PurgeContactSubmissions(ctx context.Context, before time.Time) (int64, error)
+}
+
+// DraftStore keeps each user's unsaved estimate - see draft.go.
+type DraftStore interface {
+	SaveDraft(ctx context.Context, d *EstimateDraft) error
+	LoadDraft(ctx context.Context, userID int64) (EstimateDraft, error)
+	DeleteDraft(ctx context.Context, userID int64) error
+}
+
+// SessionStore keeps server side session data by session ID - see sessionstore.go.
+type SessionStore interface {
+	LoadSession(ctx context.Context, id string) ([]byte, error)
+	SaveSession(ctx context.Context, id string, data []byte, expires time.Time) error
+	DeleteSession(ctx context.Context, id string) error
+	SweepSessions(ctx context.Context, now time.Time) (sessionStats, error)
+}
+
+// The stores handlers use, set up by openStores at startup.
+var (
+	estimateStore   EstimateStore
+	templateStore   TemplateStore
+	attachmentStore AttachmentStore
+	jobStore        JobStore
+	userStore       UserStore
+	accountStore    AccountStore
+	contactStore    ContactStore
+	sessionStore    SessionStore
+	draftStore      DraftStore
+)
+
+// Pool defaults - override with DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS,
+// DB_CONN_MAX_LIFETIME and DB_QUERY_TIMEOUT.
+const (
+	defaultMaxOpenConns    = 10
+	defaultMaxIdleConns    = 5
+	defaultConnMaxLifetime = 30 * time.Minute
+	defaultQueryTimeout    = 5 * time.Second
+)
+
+// SQLStore implements the stores on one connection pool, Postgres or SQLite.
+type SQLStore struct {
+	db      *sqlDB
+	timeout time.Duration // Applied to every query
+}
+
+// dialect is the SQL flavour of the database behind a store.
+type dialect int
+
+const (
+	dialectPostgres dialect = iota
+	dialectSQLite
+)
+
+var placeholderRE = regexp.MustCompile(`\$(\d+)`)
+
+// rebind turns the Postgres $N placeholders the queries are written with into
+// SQLite's ?N, which binds by number however often and in whatever order they appear.
+func (d dialect) rebind(query string) string {
+	if d != dialectSQLite {
+		return query
+	}
+	return placeholderRE.ReplaceAllString(query, "?$1")
+}
+
+// args stores times in UTC for SQLite, which keeps them as text, so that
+// comparisons in queries sort the same way Postgres timestamps do.
+func (d dialect) args(args []any) []any {
+	if d != dialectSQLite {
+		return args
+	}
+	for i, a := range args {
+		if t, ok := a.(time.Time); ok {
+			args[i] = t.UTC()
+		}
+	}
+	return args
+}
+
+// sqlDB is a *sql.DB that speaks the dialect of its database.
+type sqlDB struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (db *sqlDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.dialect.rebind(query), db.dialect.args(args)...)
+}
+
+func (db *sqlDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.dialect.rebind(query), db.dialect.args(args)...)
+}
+
+func (db *sqlDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.dialect.rebind(query), db.dialect.args(args)...)
+}
+
+func (db *sqlDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sqlTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, dialect: db.dialect}, nil
+}
+
+// sqlTx is a *sql.Tx that speaks the dialect of its database.
+type sqlTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx *sqlTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, tx.dialect.rebind(query), tx.dialect.args(args)...)
+}
+
+func (tx *sqlTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.dialect.rebind(query), tx.dialect.args(args)...)
+}
+
+func (tx *sqlTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.dialect.rebind(query), tx.dialect.args(args)...)
+}
+
+// envInt reads a positive int setting, falling back to def.
+func envInt(name string, def int) int {
+	if env := os.Getenv(name); env != "" {
+		if n, err := strconv.Atoi(env); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Invalid %s %q - using %d", name, env, def)
+	}
+	return def
+}
+
+// envDuration reads a positive duration setting, falling back to def.
+func envDuration(name string, def time.Duration) time.Duration {
+	if env := os.Getenv(name); env != "" {
+		if d, err := time.ParseDuration(env); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid %s %q - using %v", name, env, def)
+	}
+	return def
+}
+
+// openSQLStore opens the connection pool and checks the database is reachable.
+func openSQLStore(driver, dsn string, d dialect) (*SQLStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(envInt("DB_MAX_OPEN_CONNS", defaultMaxOpenConns))
+	db.SetMaxIdleConns(envInt("DB_MAX_IDLE_CONNS", defaultMaxIdleConns))
+	db.SetConnMaxLifetime(envDuration("DB_CONN_MAX_LIFETIME", defaultConnMaxLifetime))
+
+	s := &SQLStore{db: &sqlDB{DB: db, dialect: d}, timeout: envDuration("DB_QUERY_TIMEOUT", defaultQueryTimeout)}
+	ctx, cancel := s.ctx(context.Background())
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// ctx bounds a query by the store timeout, as well as by the request.
+func (s *SQLStore) ctx(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, s.timeout)
+}
+
+// openStore connects to the database chosen by DB_DRIVER.  postgres (the
+// default) needs DATABASE_URL; sqlite keeps a file in DB_DIR.
+func openStore() (*SQLStore, error) {
+	var st *SQLStore
+	var err error
+	switch driver := os.Getenv("DB_DRIVER"); driver {
+	case "", "postgres":
+		dbURL := os.Getenv("DATABASE_URL") // The Neon connection string - see console.neon.tech
+		if dbURL == "" {
+			return nil, fmt.Errorf("DATABASE_URL environment variable is required")
+		}
+		st, err = openSQLStore("pgx", dbURL, dialectPostgres)
+	case "sqlite":
+		st, err = openSQLiteStore(os.Getenv("DB_DIR"))
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q - use postgres or sqlite", driver)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	return st, nil
+}
+
+// openStores connects to the database, checks its schema is up to date and sets up the stores.
+func openStores(profile string) error {
+	if err := loadPIIKeys(profile); err != nil {
+		return err
+	}
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+	if err := st.checkSchema(context.Background()); err != nil {
+		st.db.Close()
+		return err
+	}
+	estimateStore, templateStore, attachmentStore, jobStore, userStore = st, st, st, st, st
+	accountStore, contactStore, sessionStore, draftStore = st, st, st, st
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestStore opens a migrated SQLite database in a temporary directory and
+// makes it the store handlers use until the test ends.
+func newTestStore(t *testing.T) *SQLStore {
+	t.Helper()
+	st, err := openSQLiteStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.MigrateUp(context.Background(), 0); err != nil {
+		st.db.Close()
+		t.Fatal(err)
+	}
+
+	es, ts, as, js, us := estimateStore, templateStore, attachmentStore, jobStore, userStore
+	acs, cs, ss, ds := accountStore, contactStore, sessionStore, draftStore
+	estimateStore, templateStore, attachmentStore, jobStore, userStore = st, st, st, st, st
+	accountStore, contactStore, sessionStore, draftStore = st, st, st, st
+	clearValidFromCache := func() {
+		validFromCache.Lock()
+		clear(validFromCache.users)
+		validFromCache.Unlock()
+	}
+	clearValidFromCache() // User IDs start again in each database
+	t.Cleanup(func() {
+		clearValidFromCache()
+		estimateStore, templateStore, attachmentStore, jobStore, userStore = es, ts, as, js, us
+		accountStore, contactStore, sessionStore, draftStore = acs, cs, ss, ds
+		st.db.Close()
+	})
+	return st
+}
+
+// testPIIKey returns a base64 32 byte key made of c.
+func testPIIKey(c string) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(c, 32)))
+}
+
+// usePIIKeys loads PII_KEYS style keys, with a fixed index key, until the test ends.
+func usePIIKeys(t *testing.T, keys string) *piiKeyring {
+	t.Helper()
+	kr, err := parsePIIKeys(keys, testPIIKey("i"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := piiKeys
+	piiKeys = kr
+	t.Cleanup(func() { piiKeys = prev })
+	return kr
+}
+
+// sentMail records the email the handlers send.
+type sentMail struct {
+	sync.Mutex
+	emails []Email
+}
+
+func (m *sentMail) Send(ctx context.Context, e Email) error {
+	m.Lock()
+	defer m.Unlock()
+	m.emails = append(m.emails, e)
+	return nil
+}
+
+// useSentMail records email in place of sending it until the test ends.
+func useSentMail(t *testing.T) *sentMail {
+	t.Helper()
+	m := &sentMail{}
+	prev := mailer
+	mailer = m
+	t.Cleanup(func() { mailer = prev })
+	return m
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// verifyTokenTTL is how long an email verification link works.
+const verifyTokenTTL = 48 * time.Hour
+
+var errBadVerifyToken = errors.New("verification link is not valid")
+
+// emailTokenKey signs verification links - EMAIL_TOKEN_KEY, or if that is not
+// set a key derived from the newest session signing key.
+var emailTokenKey []byte
+
+// loadEmailTokenKey sets emailTokenKey.  Call it after the session keys are checked.
+func loadEmailTokenKey() error {
+	if key := os.Getenv("EMAIL_TOKEN_KEY"); key != "" {
+		if len(key) < 32 {
+			return fmt.Errorf("EMAIL_TOKEN_KEY must be at least 32 bytes")
+		}
+		emailTokenKey = []byte(key)
+		return nil
+	}
+	pairs, err := sessionKeyPairs()
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, pairs[0])
+	mac.Write([]byte("email verification"))
+	emailTokenKey = mac.Sum(nil)
+	return nil
+}
+
+// verifyTokenMAC signs the user ID, expiry and email.  Including the email means
+// a link stops working if the account's address changes.
+func verifyTokenMAC(userID int64, expires int64, email string) []byte {
+	mac := hmac.New(sha256.New, emailTokenKey)
+	fmt.Fprintf(mac, "verify-email\x00%d\x00%d\x00%s", userID, expires, strings.ToLower(email))
+	return mac.Sum(nil)
+}
+
+// newVerifyToken returns a token for the link in the verification email:
+// <user id>.<expiry, unix seconds>.<signature>.
+func newVerifyToken(userID int64, email string, now time.Time) string {
+	expires := now.Add(verifyTokenTTL).Unix()
+	return fmt.Sprintf("%d.%d.%s", userID, expires,
+		base64.RawURLEncoding.EncodeToString(verifyTokenMAC(userID, expires, email)))
+}
+
+// checkVerifyToken returns the user a token was issued to, if it is genuine,
+// unexpired and the user's email is unchanged.
+func checkVerifyToken(ctx context.Context, token string, now time.Time) (UserLogin, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return UserLogin{}, errBadVerifyToken
+	}
+	userID, err1 := strconv.ParseInt(parts[0], 10, 64)
+	expires, err2 := strconv.ParseInt(parts[1], 10, 64)
+	sig, err3 := base64.RawURLEncoding.DecodeString(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return UserLogin{}, errBadVerifyToken
+	}
+	if now.Unix() > expires {
+		return UserLogin{}, errBadVerifyToken
+	}
+
+	u, err := userStore.LoadUser(ctx, userID)
+	if err != nil {
+		return UserLogin{}, errBadVerifyToken
+	}
+	if !hmac.Equal(sig, verifyTokenMAC(userID, expires, u.Email)) {
+		return UserLogin{}, errBadVerifyToken
+	}
+	return u, nil
+}
+
+var verifyEmailHTML = template.Must(template.New("verify").Parse(`
+	<p>Hi {{.Name}},</p>
+	<p>Thanks for signing up with Columbia Outdoor.  Please confirm your email address:</p>
+	<p><a href="{{.Link}}">Verify my email</a></p>
+	<p>The link works for 48 hours.  If you did not sign up, you can ignore this email.</p>
+	<hr>
+	<small>Columbia Outdoor – Pacific Northwest’s trusted outdoor living platform</small>
+`))
+
+// sendVerificationEmail emails the user a link to /verify.
+func sendVerificationEmail(ctx context.Context, userID int64, name string, email string) error {
+	link := siteURL() + "/verify?token=" + url.QueryEscape(newVerifyToken(userID, email, time.Now()))
+
+	var body bytes.Buffer
+	if err := verifyEmailHTML.Execute(&body, struct{ Name, Link string }{name, link}); err != nil {
+		return err
+	}
+	return mailer.Send(ctx, Email{ToName: name, ToAddress: email, Subject: "Please verify your email", HTML: body.String()})
+}
+
+// SetEmailVerified marks the user's email address as verified.
+func (s *SQLStore) SetEmailVerified(ctx context.Context, userID int64) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE user_auth SET email_verified = TRUE, updated_at = $1 WHERE id = $2`,
+		time.Now(), userID)
+	return err
+}
+
+// emailVerified reports whether the session's user has verified their email.
+// A session from before they clicked the link is brought up to date.
+func emailVerified(r *http.Request, w http.ResponseWriter, sd *SessionData) bool {
+	if sd.UserAuth.EmailVerified || sd.UserAuth.ID <= 0 {
+		return sd.UserAuth.EmailVerified
+	}
+	u, err := userStore.LoadUser(r.Context(), sd.UserAuth.ID)
+	if err != nil {
+		log.Printf("Loading user %d failed: %v", sd.UserAuth.ID, err)
+		return false
+	}
+	if u.EmailVerified {
+		sd.UserAuth.EmailVerified = true
+		if err := sd.Save(r, w); err != nil {
+			log.Printf("Session save failed: %v", err)
+		}
+	}
+	return u.EmailVerified
+}
+
+// verifyHandler - GET /verify?token= - the link in the verification email.
+func verifyHandler(w http.ResponseWriter, r *http.Request) {
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	u, err := checkVerifyToken(r.Context(), r.URL.Query().Get("token"), time.Now())
+	if err == nil && !u.EmailVerified {
+		err = userStore.SetEmailVerified(r.Context(), u.ID)
+		if err != nil {
+			log.Printf("Verifying email for user %d failed: %v", u.ID, err)
+		} else {
+			log.Printf("User %d verified their email", u.ID)
+		}
+	}
+
+	loggedIn := sd.UserAuth.IsAuthenticated && sd.UserAuth.ID > 0
+	switch {
+	case err != nil && loggedIn:
+		http.Redirect(w, r, "/account?verify=failed", http.StatusSeeOther)
+	case err != nil:
+		sd.UserAuth.Message = "That verification link has expired or is not valid.  Log in to send a new one."
+		sd.Save(r, w)
+		http.Redirect(w, r, "/login?rurl="+url.QueryEscape("/account"), http.StatusSeeOther)
+	case loggedIn && sd.UserAuth.ID == u.ID:
+		sd.UserAuth.EmailVerified = true
+		sd.Save(r, w)
+		http.Redirect(w, r, "/account?verify=done", http.StatusSeeOther)
+	default:
+		sd.UserAuth.Message = "Your email is verified.  Please log in."
+		sd.Save(r, w)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
+}
+
+// verifyResendHandler - POST /verify/resend - sends the logged in user a new verification link.
+func verifyResendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sd, err := GetSession(r, w)
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if !sd.UserAuth.IsAuthenticated || sd.UserAuth.ID <= 0 {
+		http.Redirect(w, r, "/login?rurl="+url.QueryEscape("/account"), http.StatusSeeOther)
+		return
+	}
+
+	u, err := userStore.LoadUser(r.Context(), sd.UserAuth.ID)
+	if err != nil {
+		log.Printf("Loading user %d failed: %v", sd.UserAuth.ID, err)
+		http.Redirect(w, r, "/account?verify=error", http.StatusSeeOther)
+		return
+	}
+	if u.EmailVerified {
+		http.Redirect(w, r, "/account?verify=done", http.StatusSeeOther)
+		return
+	}
+	if err := sendVerificationEmail(r.Context(), u.ID, u.Name(), u.Email); err != nil {
+		log.Printf("Verification email for user %d failed: %v", u.ID, err)
+		http.Redirect(w, r, "/account?verify=error", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/account?verify=sent", http.StatusSeeOther)
+}
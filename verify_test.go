@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useEmailTokenKey signs verification links with a fixed key until the test ends.
+func useEmailTokenKey(t *testing.T) {
+	t.Helper()
+	prev := emailTokenKey
+	emailTokenKey = []byte(strings.Repeat("v", 32))
+	t.Cleanup(func() { emailTokenKey = prev })
+}
+
+func TestCheckVerifyToken(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	useEmailTokenKey(t)
+	now := time.Now()
+
+	ann, err := st.CreateUser(ctx, &NewUser{Email: "ann@example.com", Role: roleHomeowner, IsActive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := st.CreateUser(ctx, &NewUser{Email: "bob@example.com", Role: roleHomeowner, IsActive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := newVerifyToken(ann, "ann@example.com", now)
+	parts := strings.Split(valid, ".")
+	bobsSig := strings.Split(newVerifyToken(bob, "bob@example.com", now), ".")[2]
+
+	tests := []struct {
+		name    string
+		token   string
+		wantID  int64
+		wantErr bool
+	}{
+		{"valid", valid, ann, false},
+		{"email in another case", newVerifyToken(ann, "Ann@Example.com", now), ann, false},
+		{"expired", newVerifyToken(ann, "ann@example.com", now.Add(-verifyTokenTTL-time.Minute)), 0, true},
+		{"email changed since", newVerifyToken(ann, "old@example.com", now), 0, true},
+		{"another user's id", strconv.FormatInt(bob, 10) + "." + parts[1] + "." + parts[2], 0, true},
+		{"another user's signature", parts[0] + "." + parts[1] + "." + bobsSig, 0, true},
+		{"expiry moved", parts[0] + "." + strconv.FormatInt(now.Add(90*24*time.Hour).Unix(), 10) + "." + parts[2], 0, true},
+		{"unknown user", newVerifyToken(9999, "ann@example.com", now), 0, true},
+		{"two parts", parts[0] + "." + parts[1], 0, true},
+		{"not numbers", "a.b." + parts[2], 0, true},
+		{"empty", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := checkVerifyToken(ctx, tt.token, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if u.ID != tt.wantID {
+				t.Errorf("user = %d, want %d", u.ID, tt.wantID)
+			}
+		})
+	}
+
+	// Signed with another key
+	emailTokenKey = []byte(strings.Repeat("w", 32))
+	if _, err := checkVerifyToken(ctx, valid, now); err == nil {
+		t.Errorf("a token signed with another key was accepted")
+	}
+}
+
+// The log mailer logs the link whole, so it can be followed in development.
+func TestVerifyLinkInLog(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	useEmailTokenKey(t)
+	prev := mailer
+	mailer = logMailer{}
+	t.Cleanup(func() { mailer = prev })
+
+	ann, err := st.CreateUser(ctx, &NewUser{Email: "ann@example.com", Role: roleHomeowner, IsActive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged bytes.Buffer
+	out := log.Writer()
+	log.SetOutput(&logged)
+	err = sendVerificationEmail(ctx, ann, "Ann", "ann@example.com")
+	log.SetOutput(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := regexp.MustCompile(`/verify\?token=([^"&\s]+)`).FindStringSubmatch(logged.String())
+	if m == nil {
+		t.Fatalf("no verify link in the log:\n%s", logged.String())
+	}
+	if u, err := checkVerifyToken(ctx, m[1], time.Now()); err != nil || u.ID != ann {
+		t.Errorf("logged link: user %d, err %v, want user %d", u.ID, err, ann)
+	}
+}
+
+// A session from before the link was clicked is brought up to date.
+func TestEmailVerified(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	useMemorySessions(t)
+
+	newUser := func(email string, verified bool) int64 {
+		t.Helper()
+		id, err := st.CreateUser(ctx, &NewUser{Email: email, Role: roleHomeowner, IsActive: true, EmailVerified: verified})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	tests := []struct {
+		name string
+		sd   SessionData
+		want bool
+	}{
+		{"verified since login", login(newUser("ann@example.com", true), roleHomeowner), true},
+		{"not verified", login(newUser("bob@example.com", false), roleHomeowner), false},
+		{"session already verified", SessionData{UserAuth: UserAuth{ID: 9999, IsAuthenticated: true, EmailVerified: true}}, true},
+		{"not logged in", SessionData{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sd := tt.sd
+			r := httptest.NewRequest(http.MethodGet, "/estimate", nil)
+			if got := emailVerified(r, httptest.NewRecorder(), &sd); got != tt.want {
+				t.Errorf("emailVerified = %v, want %v", got, tt.want)
+			}
+			if sd.UserAuth.EmailVerified != tt.want {
+				t.Errorf("session EmailVerified = %v, want %v", sd.UserAuth.EmailVerified, tt.want)
+			}
+		})
+	}
+}